@@ -0,0 +1,108 @@
+/*
+Herramienta de línea de comandos del registro de auditoría:
+
+	sdshttp audit verify [-log audit.log] [-pub audit.pub]  (la pública junto al registro por defecto)
+	sdshttp audit query [-log audit.log] [-user u] [-event e] [-since t] [-until t] [-json]
+
+(las fechas en RFC 3339, p.ej. 2024-05-01T10:00:00Z, o sólo el día, 2024-05-01, en UTC)
+*/
+package audit
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Run ejecuta un subcomando de auditoría (args sin "audit") y termina con error si falla
+func Run(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "uso: sdshttp audit verify|query [opciones] (-h para ver las opciones)")
+		os.Exit(2)
+	}
+	var err error
+	switch args[0] {
+	case "verify":
+		err = runVerify(args[1:])
+	case "query":
+		err = runQuery(args[1:])
+	default:
+		err = fmt.Errorf("subcomando desconocido %q (verify o query)", args[0])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("audit verify", flag.ExitOnError)
+	path := fs.String("log", DefaultPath, "fichero del registro")
+	pubPath := fs.String("pub", "", "clave pública de verificación (base64; "+DefaultPub+" junto al registro por defecto)")
+	fs.Parse(args)
+	if *pubPath == "" {
+		_, *pubPath = KeyPaths(*path)
+	}
+
+	pub, err := LoadPublicKey(*pubPath)
+	if err != nil {
+		return err
+	}
+	rep, err := Verify(*path, pub)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("registro íntegro: %d registros, %d puntos de control firmados", rep.Records, rep.Checkpoints)
+	if rep.Records > 0 {
+		fmt.Printf(", del %s al %s", rep.First.Format(time.RFC3339), rep.Last.Format(time.RFC3339))
+	}
+	fmt.Println()
+	return nil
+}
+
+func runQuery(args []string) error {
+	fs := flag.NewFlagSet("audit query", flag.ExitOnError)
+	path := fs.String("log", DefaultPath, "fichero del registro")
+	var f Filter
+	fs.StringVar(&f.User, "user", "", "sólo los registros de este usuario")
+	fs.StringVar(&f.Event, "event", "", "sólo este tipo de evento (login, login_failed, access, token_rejected...)")
+	since := fs.String("since", "", "desde esta fecha (incluida)")
+	until := fs.String("until", "", "hasta esta fecha (excluida)")
+	asJSON := fs.Bool("json", false, "escribe los registros completos en JSON (uno por línea)")
+	fs.Parse(args)
+
+	var err error
+	if f.Since, err = parseTime(*since); err != nil {
+		return err
+	}
+	if f.Until, err = parseTime(*until); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	return Query(*path, f, func(r Record) error {
+		if *asJSON {
+			return enc.Encode(r)
+		}
+		_, err := fmt.Printf("%6d  %s  %-15s %-12s %-15s %-20s %s\n",
+			r.Seq, r.Time.Format(time.RFC3339), r.Event, r.User, r.IP, r.Result, r.Detail)
+		return err
+	})
+}
+
+// parseTime interpreta una fecha de los filtros (vacía = sin límite)
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return t, fmt.Errorf("fecha inválida %q (RFC 3339 o AAAA-MM-DD)", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,355 @@
+/*
+Registro de auditoría a prueba de manipulaciones
+
+cada registro es una línea JSON encadenada con el anterior (incluye su hash, y el hash de cada
+registro cubre todos sus campos); cada cierto número de registros se añade un punto de control
+firmado con Ed25519 y, tras cada escritura, se firma la cabecera (fichero .head) con el número y
+el hash del último registro, de modo que se detecta tanto la modificación como el truncado
+(salvo que se vuelva a la vez a una copia anterior del registro y de la cabecera)
+
+el registro llega al disco antes que la cabecera: si el servidor cae entre los dos, al abrirlo
+queda un registro más que en la cabecera; se acepta sólo si es uno y está encadenado con el de
+la cabecera, se vuelve a firmar la cabecera y se anota el evento recovered
+*/
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ficheros por defecto (junto al servidor; las claves van siempre junto al registro, ver KeyPaths)
+const (
+	DefaultPath = "audit.log" // registro (la cabecera firmada va en audit.log.head)
+	DefaultKey  = "audit.key" // clave de firma del servidor (semilla Ed25519)
+	DefaultPub  = "audit.pub" // clave pública para verificar (base64)
+)
+
+// KeyPaths devuelve las rutas de la clave de firma y de la pública del registro en path
+// (DefaultKey y DefaultPub en su mismo directorio)
+func KeyPaths(path string) (key, pub string) {
+	dir := filepath.Dir(path)
+	return filepath.Join(dir, DefaultKey), filepath.Join(dir, DefaultPub)
+}
+
+// CheckpointEvery es el número de registros entre dos puntos de control firmados
+const CheckpointEvery = 100
+
+// tipos de registro del propio registro
+const (
+	EventCheckpoint = "checkpoint" // punto de control firmado
+	EventRecovered  = "recovered"  // cabecera recuperada tras una caída al escribir
+)
+
+// ErrTampered indica que el registro no cuadra con su cabecera firmada (modificado o truncado)
+var ErrTampered = errors.New("audit: el registro no coincide con su cabecera firmada")
+
+// Record es un registro de auditoría
+type Record struct {
+	Seq    uint64    // número de registro (desde 1, sin huecos)
+	Time   time.Time // instante del evento
+	Event  string    // tipo de evento (login, login_failed, access, token_rejected...)
+	User   string    `json:",omitempty"` // usuario afectado
+	IP     string    `json:",omitempty"` // dirección del cliente
+	Sid    string    `json:",omitempty"` // sesión
+	Result string    `json:",omitempty"` // "ok" o código de error
+	Detail string    `json:",omitempty"` // información adicional (p.ej. el recurso accedido)
+	Prev   string    // hash del registro anterior (hex; vacío en el primero)
+	Hash   string    `json:",omitempty"` // hash de este registro (hex, sin Hash ni Sig)
+	Sig    []byte    `json:",omitempty"` // firma del hash (sólo en los puntos de control)
+}
+
+// hash calcula el hash del registro (de su codificación JSON sin Hash ni Sig)
+func (r Record) hash() (string, error) {
+	r.Hash, r.Sig = "", nil
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Head es la cabecera firmada: el último registro escrito
+type Head struct {
+	Seq  uint64 // número del último registro
+	Hash string // hash del último registro
+	Sig  []byte // firma del hash
+}
+
+// mensajes firmados (separados para que una firma no sirva para el otro uso)
+func checkpointMsg(hash string) []byte { return []byte("sdshttp audit checkpoint " + hash) }
+func headMsg(hash string) []byte       { return []byte("sdshttp audit head " + hash) }
+
+// Log es un registro de auditoría abierto para añadir (seguro para uso concurrente)
+type Log struct {
+	mu    sync.Mutex
+	f     *os.File
+	path  string
+	key   ed25519.PrivateKey
+	now   func() time.Time
+	last  Record // último registro escrito
+	since int    // registros desde el último punto de control
+}
+
+// Open abre (o crea) el registro en path y comprueba que su último registro coincide con la
+// cabecera firmada (si no, devuelve ErrTampered y hay que investigar con audit verify)
+func Open(path string, key ed25519.PrivateKey, now func() time.Time) (*Log, error) {
+	l := &Log{path: path, key: key, now: now}
+
+	last, err := lastRecord(path)
+	if err != nil {
+		return nil, err
+	}
+	head, err := ReadHead(path + ".head")
+	if errors.Is(err, os.ErrNotExist) && last.Seq <= 1 {
+		head, err = Head{}, nil // registro nuevo (o caída al escribir el primero)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pub := key.Public().(ed25519.PublicKey)
+	if head.Seq != 0 && !ed25519.Verify(pub, headMsg(head.Hash), head.Sig) {
+		return nil, ErrTampered
+	}
+	recovered := last.Seq == head.Seq+1 && pending(last, head, pub)
+	if !recovered && (head.Seq != last.Seq || head.Hash != last.Hash) {
+		return nil, ErrTampered
+	}
+	l.last = last
+
+	if l.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); err != nil {
+		return nil, err
+	}
+	if recovered {
+		err = l.writeHead()
+		if err == nil {
+			err = l.write(Record{Time: now(), Event: EventRecovered,
+				Detail: fmt.Sprintf("registro %d sin cabecera firmada", last.Seq)})
+		}
+		if err != nil {
+			l.f.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// pending indica si r es un registro escrito justo después de la cabecera cuya cabecera no
+// llegó a escribirse (encadenado con el de la cabecera, con su hash y, si es un punto de
+// control, con su firma)
+func pending(r Record, head Head, pub ed25519.PublicKey) bool {
+	if h, err := r.hash(); err != nil || h != r.Hash || r.Prev != head.Hash {
+		return false
+	}
+	if r.Event == EventCheckpoint {
+		return ed25519.Verify(pub, checkpointMsg(r.Hash), r.Sig)
+	}
+	return r.Sig == nil
+}
+
+// lastRecord lee el último registro del fichero (Seq 0 si no existe o está vacío)
+// leyendo hacia atrás desde el final (el registro puede ser muy grande)
+func lastRecord(path string) (r Record, err error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	} else if err != nil {
+		return r, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return r, err
+	}
+
+	const chunk = 4 << 10
+	var tail []byte // final del fichero leído hasta ahora
+	for off := fi.Size(); off > 0; {
+		n := min(chunk, off)
+		off -= n
+		buf := make([]byte, n, n+int64(len(tail)))
+		if _, err = f.ReadAt(buf, off); err != nil {
+			return r, err
+		}
+		tail = append(buf, tail...)
+		line := bytes.TrimRight(tail, "\n")
+		if i := bytes.LastIndexByte(line, '\n'); i >= 0 || (off == 0 && len(line) > 0) {
+			err = json.Unmarshal(line[i+1:], &r)
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+// Append añade un registro (rellena Seq, Time, Prev y Hash) y, cada CheckpointEvery
+// registros, un punto de control firmado; un Log nil no hace nada
+func (l *Log) Append(r Record) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if r.Time.IsZero() {
+		r.Time = l.now()
+	}
+	if err := l.write(r); err != nil {
+		return err
+	}
+	if l.since++; l.since >= CheckpointEvery {
+		return l.checkpoint()
+	}
+	return nil
+}
+
+// checkpoint añade un punto de control firmado (con el bloqueo adquirido)
+func (l *Log) checkpoint() error {
+	l.since = 0
+	return l.write(Record{Time: l.now(), Event: EventCheckpoint})
+}
+
+// write encadena, escribe el registro y actualiza la cabecera firmada (con el bloqueo adquirido)
+func (l *Log) write(r Record) (err error) {
+	r.Seq, r.Prev, r.Hash, r.Sig = l.last.Seq+1, l.last.Hash, "", nil
+	r.Time = r.Time.UTC().Round(0) // sin reloj monótono (el JSON no lo conserva)
+	if r.Hash, err = r.hash(); err != nil {
+		return err
+	}
+	if r.Event == EventCheckpoint {
+		r.Sig = ed25519.Sign(l.key, checkpointMsg(r.Hash))
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err = l.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err = l.f.Sync(); err != nil { // el registro llega al disco antes que la cabecera
+		return err
+	}
+	l.last = r
+	return l.writeHead()
+}
+
+// writeHead firma y escribe la cabecera con el último registro (con el bloqueo adquirido)
+func (l *Log) writeHead() error {
+	head, err := json.Marshal(Head{Seq: l.last.Seq, Hash: l.last.Hash, Sig: ed25519.Sign(l.key, headMsg(l.last.Hash))})
+	if err != nil {
+		return err
+	}
+	return replaceFile(l.path+".head", head)
+}
+
+// Close añade un punto de control final y cierra el registro
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.checkpoint()
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// ReadHead lee una cabecera firmada
+func ReadHead(path string) (h Head, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return h, err
+	}
+	err = json.Unmarshal(data, &h)
+	return h, err
+}
+
+// replaceFile reemplaza un fichero con un temporal y rename (nunca queda a medias)
+func replaceFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no hace nada si el rename ha tenido éxito
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadKey obtiene la clave de firma del fichero path (la semilla de 32 bytes); si no existe
+// la genera y guarda también la clave pública en base64 en pubPath para verificar el registro
+func LoadKey(path, pubPath string) (ed25519.PrivateKey, error) {
+	seed, err := os.ReadFile(path)
+	if err == nil {
+		if len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("audit: clave de firma inválida en %s", path)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader) // primera ejecución
+	if err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(path, key.Seed(), 0600); err != nil {
+		return nil, err
+	}
+	return key, os.WriteFile(pubPath, []byte(base64.StdEncoding.EncodeToString(pub)+"\n"), 0644)
+}
+
+// LoadPublicKey lee la clave pública (base64) de verificación
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("audit: clave pública inválida en %s", path)
+	}
+	return pub, nil
+}
+
+// scan recorre los registros de un fichero, en orden
+func scan(path string, fn func(line int, r Record) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		var r Record
+		if err = json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return fmt.Errorf("línea %d: registro ilegible: %w", n, err)
+		}
+		if err = fn(n, r); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,127 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openTestLog abre un registro nuevo en un directorio temporal con una clave nueva
+func openTestLog(t *testing.T) (*Log, string, ed25519.PrivateKey) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, key, time.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, path, key
+}
+
+// crash escribe un registro como si el servidor cayera antes de actualizar la cabecera
+// (se deja la cabecera anterior y se cierra el fichero sin punto de control)
+func crash(t *testing.T, l *Log, path string, n int) {
+	t.Helper()
+	head, err := os.ReadFile(path + ".head")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := l.Append(Record{Event: "login", User: "ana"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if head == nil {
+		err = os.Remove(path + ".head")
+	} else {
+		err = os.WriteFile(path+".head", head, 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.f.Close()
+}
+
+// TestOpenRecovered comprueba que un registro sin cabecera (caída entre los dos) se acepta
+func TestOpenRecovered(t *testing.T) {
+	for _, before := range []int{0, 3} {
+		l, path, key := openTestLog(t)
+		for i := 0; i < before; i++ {
+			if err := l.Append(Record{Event: "access"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		crash(t, l, path, 1)
+
+		l, err := Open(path, key, time.Now)
+		if err != nil {
+			t.Fatalf("Open tras la caída (%d registros antes) = %v", before, err)
+		}
+		if l.last.Event != EventRecovered || l.last.Seq != uint64(before)+2 {
+			t.Fatalf("último registro = %+v, se esperaba %s", l.last, EventRecovered)
+		}
+		if err = l.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = Verify(path, key.Public().(ed25519.PublicKey)); err != nil {
+			t.Fatalf("Verify tras recuperar = %v", err)
+		}
+	}
+}
+
+// TestOpenTampered comprueba que no se acepta más de un registro sin cabecera ni uno que no
+// esté encadenado con el de la cabecera
+func TestOpenTampered(t *testing.T) {
+	l, path, key := openTestLog(t)
+	if err := l.Append(Record{Event: "access"}); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, l, path, 2)
+	if _, err := Open(path, key, time.Now); !errors.Is(err, ErrTampered) {
+		t.Fatalf("Open con dos registros de más = %v, se esperaba ErrTampered", err)
+	}
+
+	l, path, key = openTestLog(t)
+	if err := l.Append(Record{Event: "access"}); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, l, path, 1)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = []byte(strings.Replace(string(data), `"User":"ana"`, `"User":"eva"`, 1))
+	if err = os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Open(path, key, time.Now); !errors.Is(err, ErrTampered) {
+		t.Fatalf("Open con el registro de más modificado = %v, se esperaba ErrTampered", err)
+	}
+}
+
+// TestLastRecord comprueba la lectura hacia atrás con registros mayores que un bloque
+func TestLastRecord(t *testing.T) {
+	l, path, _ := openTestLog(t)
+	long := strings.Repeat("x", 10<<10)
+	for _, d := range []string{"a", long, "b", long} {
+		if err := l.Append(Record{Event: "access", Detail: d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := lastRecord(path)
+	if err != nil || r.Seq != 4 || r.Detail != long {
+		t.Fatalf("lastRecord = %d, %v", r.Seq, err)
+	}
+	if r, err = lastRecord(filepath.Join(t.TempDir(), "no")); err != nil || r.Seq != 0 {
+		t.Fatalf("lastRecord sin fichero = %d, %v", r.Seq, err)
+	}
+	l.f.Close()
+}
@@ -0,0 +1,96 @@
+/*
+Verificación y consulta del registro de auditoría
+*/
+package audit
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"time"
+)
+
+// Report es el resultado de una verificación correcta
+type Report struct {
+	Records     uint64    // registros verificados (incluidos los puntos de control)
+	Checkpoints int       // puntos de control con firma válida
+	First, Last time.Time // instantes del primer y del último registro
+}
+
+// Verify comprueba el registro en path: numeración sin huecos, encadenamiento de hashes,
+// firmas de los puntos de control y que el último registro es el de la cabecera firmada
+// (si no, falta el final o se ha añadido algo sin la clave del servidor)
+func Verify(path string, pub ed25519.PublicKey) (rep Report, err error) {
+	head, err := ReadHead(path + ".head")
+	if err != nil {
+		return rep, fmt.Errorf("cabecera: %w", err)
+	}
+	if !ed25519.Verify(pub, headMsg(head.Hash), head.Sig) {
+		return rep, fmt.Errorf("cabecera: firma inválida")
+	}
+
+	var last Record
+	err = scan(path, func(line int, r Record) error {
+		if r.Seq != last.Seq+1 {
+			return fmt.Errorf("línea %d: se esperaba el registro %d y aparece el %d", line, last.Seq+1, r.Seq)
+		}
+		if r.Prev != last.Hash {
+			return fmt.Errorf("línea %d (registro %d): no está encadenado con el anterior", line, r.Seq)
+		}
+		if h, err := r.hash(); err != nil || h != r.Hash {
+			return fmt.Errorf("línea %d (registro %d): el contenido no coincide con su hash", line, r.Seq)
+		}
+		if r.Event == EventCheckpoint {
+			if !ed25519.Verify(pub, checkpointMsg(r.Hash), r.Sig) {
+				return fmt.Errorf("línea %d (registro %d): firma del punto de control inválida", line, r.Seq)
+			}
+			rep.Checkpoints++
+		} else if r.Sig != nil {
+			return fmt.Errorf("línea %d (registro %d): firma en un registro que no es punto de control", line, r.Seq)
+		}
+		if rep.First.IsZero() {
+			rep.First = r.Time
+		}
+		rep.Records, rep.Last, last = r.Seq, r.Time, r
+		return nil
+	})
+	if err != nil {
+		return rep, err
+	}
+
+	switch {
+	case last.Seq < head.Seq:
+		return rep, fmt.Errorf("%w: truncado, faltan los registros %d a %d", ErrTampered, last.Seq+1, head.Seq)
+	case last.Seq > head.Seq:
+		return rep, fmt.Errorf("%w: registros %d a %d añadidos después de la cabecera", ErrTampered, head.Seq+1, last.Seq)
+	case last.Hash != head.Hash:
+		return rep, fmt.Errorf("%w: el registro %d no es el de la cabecera", ErrTampered, last.Seq)
+	}
+	return rep, nil
+}
+
+// Filter selecciona registros en Query (los campos vacíos no filtran)
+type Filter struct {
+	User  string
+	Event string
+	Since time.Time // desde (incluido)
+	Until time.Time // hasta (excluido)
+}
+
+// match indica si un registro pasa el filtro
+func (f Filter) match(r Record) bool {
+	return (f.User == "" || r.User == f.User) &&
+		(f.Event == "" || r.Event == f.Event) &&
+		(f.Since.IsZero() || !r.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || r.Time.Before(f.Until))
+}
+
+// Query llama a fn con cada registro de path que pasa el filtro, en orden
+// (no verifica el registro; para eso está Verify)
+func Query(path string, f Filter, fn func(Record) error) error {
+	return scan(path, func(_ int, r Record) error {
+		if !f.match(r) {
+			return nil
+		}
+		return fn(r)
+	})
+}
@@ -0,0 +1,120 @@
+/*
+Cliente: comandos de administración del intérprete y alta del primer administrador
+*/
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sdshttp/client"
+	"sdshttp/srv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// subcomandos de admin (para la ayuda y el autocompletado)
+var adminCommands = []string{"users", "disable", "enable", "logout", "delete"}
+
+func (sh *shell) admin(ctx context.Context, args []string) error {
+	if len(args) == 0 || (args[0] == "users") != (len(args) == 1) || len(args) > 2 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "users":
+		l, err := sh.c.AdminUsers(ctx)
+		if err != nil {
+			return err
+		}
+		sh.users = sh.users[:0]
+		for _, u := range l {
+			sh.users = append(sh.users, u.Name)
+			state := "activa"
+			if u.Disabled {
+				state = "deshabilitada"
+			}
+			fmt.Fprintf(sh.out, "%-16s %-6s %-14s %d sesiones, %d entradas, visto %s\n",
+				u.Name, u.Role, state, u.Sessions, u.Entries, u.Seen.Format("2006-01-02 15:04"))
+		}
+		return nil
+
+	case "disable", "enable":
+		if err := sh.c.AdminDisable(ctx, args[1], args[0] == "disable"); err != nil {
+			return err
+		}
+		fmt.Fprintf(sh.out, "cuenta de %s %s\n", args[1], map[string]string{"disable": "deshabilitada", "enable": "habilitada"}[args[0]])
+		return nil
+
+	case "logout":
+		if err := sh.c.AdminLogout(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Fprintf(sh.out, "sesiones de %s cerradas\n", args[1])
+		return nil
+
+	case "delete":
+		ok, err := sh.readLine(fmt.Sprintf("se borrarán la cuenta y todos los datos de %s; escribe su nombre para confirmar: ", args[1]))
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(ok) != args[1] {
+			return errors.New("cancelado")
+		}
+		if err = sh.c.AdminDelete(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Fprintf(sh.out, "usuario %s borrado\n", args[1])
+		return nil
+	}
+	return errUsage
+}
+
+// Bootstrap crea el primer administrador directamente en el almacén (con el servidor parado):
+// sdshttp admin-init <usuario> (si el usuario existe, lo promueve; si no, pide su contraseña)
+func Bootstrap(args []string) {
+	if len(args) != 1 {
+		fmt.Println("uso: sdshttp admin-init <usuario>")
+		return
+	}
+	in := bufio.NewScanner(os.Stdin)
+	read := func(prompt string) (string, error) {
+		fmt.Print(prompt)
+		if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+			pw, err := term.ReadPassword(fd)
+			fmt.Println()
+			return string(pw), err
+		}
+		if !in.Scan() {
+			return "", errors.New("entrada cerrada")
+		}
+		return in.Text(), nil
+	}
+
+	created, err := srv.Bootstrap(args[0], func() ([]byte, string, string, string, error) {
+		pw, err := read("contraseña: ")
+		if err != nil {
+			return nil, "", "", "", err
+		}
+		pw2, err := read("repite la contraseña: ")
+		if err != nil {
+			return nil, "", "", "", err
+		}
+		if pw == "" || pw != pw2 {
+			return nil, "", "", "", errors.New("las contraseñas no coinciden o están vacías")
+		}
+		pass, pub, pri, err := client.AccountKeys(pw, client.DefaultKeyAlg)
+		return pass, pub, pri, client.DefaultKeyAlg, err
+	})
+	switch {
+	case err != nil:
+		fmt.Println("error:", err)
+	case created:
+		fmt.Printf("administrador %s creado\n", args[0])
+	default:
+		fmt.Printf("%s es ahora administrador\n", args[0])
+	}
+}
@@ -1,113 +0,0 @@
-/*
-Cliente
-*/
-package cli
-
-import (
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/sha512"
-	"crypto/tls"
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
-	"net/url"
-	"os"
-	"sdshttp/srv"
-	"sdshttp/util"
-)
-
-// chk comprueba y sale si hay errores (ahorra escritura en programas sencillos)
-func chk(e error) {
-	if e != nil {
-		panic(e)
-	}
-}
-
-// Run gestiona el modo cliente
-func Run() {
-
-	/* creamos un cliente especial que no comprueba la validez de los certificados
-	esto es necesario por que usamos certificados autofirmados (para pruebas) */
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-	client := &http.Client{Transport: tr}
-
-	// hash con SHA512 de la contraseña
-	keyClient := sha512.Sum512([]byte("contraseña del cliente"))
-	keyLogin := keyClient[:32]  // una mitad para el login (256 bits)
-	keyData := keyClient[32:64] // la otra para los datos (256 bits)
-
-	// generamos un par de claves (privada, pública) para el servidor
-	pkClient, err := rsa.GenerateKey(rand.Reader, 1024)
-	chk(err)
-	pkClient.Precompute() // aceleramos su uso con un precálculo
-
-	pkJSON, err := json.Marshal(&pkClient) // codificamos con JSON
-	chk(err)
-
-	keyPub := pkClient.Public()           // extraemos la clave pública por separado
-	pubJSON, err := json.Marshal(&keyPub) // y codificamos con JSON
-	chk(err)
-
-	// ** ejemplo de registro
-	data := url.Values{}                      // estructura para contener los valores
-	data.Set("cmd", "register")               // comando (string)
-	data.Set("user", "usuario")               // usuario (string)
-	data.Set("pass", util.Encode64(keyLogin)) // "contraseña" a base64
-
-	// comprimimos y codificamos la clave pública
-	data.Set("pubkey", util.Encode64(util.Compress(pubJSON)))
-
-	// comprimimos, ciframos y codificamos la clave privada
-	data.Set("prikey", util.Encode64(util.Encrypt(util.Compress(pkJSON), keyData)))
-
-	r, err := client.PostForm("https://localhost:10443", data) // enviamos por POST
-	chk(err)
-	io.Copy(os.Stdout, r.Body) // mostramos el cuerpo de la respuesta (es un reader)
-	r.Body.Close()             // hay que cerrar el reader del body
-	fmt.Println()
-
-	// ** ejemplo de login
-	data = url.Values{}
-	data.Set("cmd", "login")                                  // comando (string)
-	data.Set("user", "usuario")                               // usuario (string)
-	data.Set("pass", util.Encode64(keyLogin))                 // contraseña (a base64 porque es []byte)
-	r, err = client.PostForm("https://localhost:10443", data) // enviamos por POST
-	chk(err)
-	resp := srv.Resp{}
-	json.NewDecoder(r.Body).Decode(&resp) // decodificamos la respuesta para utilizar sus campos más adelante
-	fmt.Println(resp)                     // imprimimos por pantalla
-	r.Body.Close()                        // hay que cerrar el reader del body
-
-	// ** ejemplo de data sin utilizar el token correcto
-	badToken := make([]byte, 16)
-	_, err = rand.Read(badToken)
-	chk(err)
-
-	data = url.Values{}
-	data.Set("cmd", "data")                    // comando (string)
-	data.Set("user", "usuario")                // usuario (string)
-	data.Set("pass", util.Encode64(keyLogin))  // contraseña (a base64 porque es []byte)
-	data.Set("token", util.Encode64(badToken)) // token incorrecto
-	r, err = client.PostForm("https://localhost:10443", data)
-	chk(err)
-	io.Copy(os.Stdout, r.Body) // mostramos el cuerpo de la respuesta (es un reader)
-	r.Body.Close()             // hay que cerrar el reader del body
-	fmt.Println()
-
-	// ** ejemplo de data con token correcto
-	data = url.Values{}
-	data.Set("cmd", "data")                      // comando (string)
-	data.Set("user", "usuario")                  // usuario (string)
-	data.Set("pass", util.Encode64(keyLogin))    // contraseña (a base64 porque es []byte)
-	data.Set("token", util.Encode64(resp.Token)) // token correcto
-	r, err = client.PostForm("https://localhost:10443", data)
-	chk(err)
-	io.Copy(os.Stdout, r.Body) // mostramos el cuerpo de la respuesta (es un reader)
-	r.Body.Close()             // hay que cerrar el reader del body
-	fmt.Println()
-
-}
@@ -0,0 +1,112 @@
+/*
+Cliente: demostración no interactiva de la API (con el paquete client)
+*/
+package cli
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"net/url"
+	"sdshttp/client"
+	"sdshttp/srv"
+	"sdshttp/tlsconf"
+	"sdshttp/util"
+	"time"
+)
+
+// chk comprueba y sale si hay errores (ahorra escritura en programas sencillos)
+func chk(e error) {
+	if e != nil {
+		panic(e)
+	}
+}
+
+// Demo ejecuta una secuencia fija de operaciones contra el servidor
+func Demo() {
+
+	// verificamos el servidor según las variables de entorno (por defecto con localhost.crt)
+	cfg, err := tlsconf.FromEnv().Config()
+	chk(err)
+	c := client.New(client.DefaultURL, client.WithTLSConfig(cfg), client.WithTimeout(30*time.Second))
+	ctx := context.Background()
+
+	// ** ejemplo de registro (el SDK deriva las claves de la contraseña y genera el par de claves)
+	show("register", c.Register(ctx, "usuario", "contraseña del cliente"))
+
+	// ** ejemplo de login
+	show("login", c.Login(ctx, "usuario", "contraseña del cliente", client.LoginOpts{}))
+
+	// el token está firmado por el servidor, pero su contenido (claims) es legible
+	claims, err := c.Claims()
+	chk(err)
+	fmt.Println("sesión", claims.Sid, "de", claims.Sub, "válida hasta", claims.Expires())
+
+	// ** ejemplo de data sin utilizar el token correcto
+	badToken := make([]byte, 16)
+	_, err = rand.Read(badToken)
+	chk(err)
+
+	token := c.Token
+	c.Token = []byte(util.Encode64(badToken)) // token incorrecto
+	_, err = c.GetData(ctx)
+	show("data", err)
+	c.Token = token
+
+	// ** ejemplo de data con token correcto
+	datos, err := c.GetData(ctx)
+	show("data", err)
+	fmt.Println(datos)
+
+	// ** ejemplo de un segundo login desde otro dispositivo (la primera sesión sigue abierta)
+	movil := client.New(c.BaseURL, client.WithHTTPClient(c.HTTP))
+	show("login", movil.Login(ctx, "usuario", "contraseña del cliente", client.LoginOpts{Device: "móvil"}))
+	claims2, err := movil.Claims()
+	chk(err)
+
+	// ** ejemplo de listado de sesiones
+	sessions, err := c.Sessions(ctx)
+	show("sessions", err)
+	for _, s := range sessions {
+		fmt.Printf("  %s [%s] desde %s, visto %s\n", s.ID, s.Device, s.IP, s.Seen.Format("15:04:05"))
+	}
+
+	// ** ejemplo de logout de la sesión del móvil desde la primera sesión
+	show("logout", c.LogoutSession(ctx, claims2.Sid))
+
+	// ** ejemplo de alta de TOTP: el servidor devuelve una URI otpauth:// con el secreto
+	raw, err := c.SetupTOTP(ctx)
+	show("totp-setup", err)
+	uri, err := url.Parse(raw)
+	chk(err)
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(uri.Query().Get("secret"))
+	chk(err)
+
+	// confirmamos con un primer código (lo calcularía la aplicación de autentificación)
+	recovery, err := c.ConfirmTOTP(ctx, srv.TOTPCode(secret, time.Now())) // códigos de recuperación (de un solo uso)
+	show("totp-confirm", err)
+
+	// a partir de ahora el login exige el código (o uno de recuperación)
+	otro := client.New(c.BaseURL, client.WithHTTPClient(c.HTTP))
+	show("login", otro.Login(ctx, "usuario", "contraseña del cliente", client.LoginOpts{}))
+	show("login", otro.Login(ctx, "usuario", "contraseña del cliente", client.LoginOpts{Recovery: recovery[0]}))
+
+	// ** ejemplo de logout de todas las sesiones
+	token = c.Token
+	show("logout-all", c.LogoutAll(ctx))
+
+	// ** ejemplo de data con el token ya revocado
+	c.Token = token
+	_, err = c.GetData(ctx)
+	show("data", err)
+}
+
+// show muestra el resultado de una operación
+func show(cmd string, err error) {
+	if err != nil {
+		fmt.Println(cmd, "-> false", err)
+		return
+	}
+	fmt.Println(cmd, "-> true")
+}
@@ -0,0 +1,109 @@
+/*
+Cliente: comandos de recuperación de la cuenta del intérprete
+*/
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sdshttp/client"
+	"strconv"
+	"strings"
+)
+
+// parseRecovery interpreta el esquema de recuperación: "1" (una clave) o "k/n" (k de n partes)
+func parseRecovery(s string) (n, k int, err error) {
+	if s == "1" {
+		return 1, 1, nil
+	}
+	ks, ns, ok := strings.Cut(s, "/")
+	if k, err = strconv.Atoi(ks); ok && err == nil {
+		n, err = strconv.Atoi(ns)
+	}
+	if !ok || err != nil || k < 1 || k > n || n > 255 {
+		return 0, 0, errors.New("esquema de recuperación inválido (1 o k/n, con 1 <= k <= n <= 255)")
+	}
+	return n, k, nil
+}
+
+// setupRecovery configura la recuperación y muestra las claves (sólo se pueden ver ahora)
+func (sh *shell) setupRecovery(ctx context.Context, n, k int) error {
+	keys, err := sh.c.SetupRecovery(ctx, n, k)
+	if err != nil {
+		return err
+	}
+	if n == 1 {
+		fmt.Fprintf(sh.out, "clave de recuperación (guárdala fuera de línea, no se volverá a mostrar):\n  %s\n", keys[0])
+		return nil
+	}
+	fmt.Fprintf(sh.out, "partes de recuperación (bastarán %d de %d; guárdalas por separado, no se volverán a mostrar):\n", k, n)
+	for i, key := range keys {
+		fmt.Fprintf(sh.out, "  %d: %s\n", i+1, key)
+	}
+	return nil
+}
+
+// askRecovery pregunta tras el registro si se quiere configurar la recuperación
+func (sh *shell) askRecovery(ctx context.Context) error {
+	s, err := sh.readLine("recuperación de la cuenta (vacío: ninguna, 1: una clave, k/n: k de n partes): ")
+	if err != nil || strings.TrimSpace(s) == "" {
+		return err
+	}
+	n, k, err := parseRecovery(strings.TrimSpace(s))
+	if err != nil {
+		return err
+	}
+	return sh.setupRecovery(ctx, n, k)
+}
+
+func (sh *shell) recovery(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	if sh.c.Token == nil {
+		return client.ErrNoSession
+	}
+	n, k := 1, 1
+	if len(args) == 1 {
+		var err error
+		if n, k, err = parseRecovery(args[0]); err != nil {
+			return err
+		}
+	}
+	return sh.setupRecovery(ctx, n, k)
+}
+
+func (sh *shell) recover(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	var keys []string
+	for {
+		key, err := sh.readPassword(fmt.Sprintf("clave o parte de recuperación %d (vacío para terminar): ", len(keys)+1))
+		if err != nil {
+			return err
+		}
+		if key = strings.TrimSpace(key); key == "" {
+			break
+		}
+		if err = client.CheckRecoveryKey(key); err != nil {
+			fmt.Fprintln(sh.out, "error:", err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return errors.New("no se ha indicado ninguna clave de recuperación")
+	}
+	pw, err := sh.newPassword("contraseña nueva: ")
+	if err != nil {
+		return err
+	}
+	if err = sh.c.Recover(ctx, args[0], keys, pw); err != nil {
+		return err
+	}
+	sh.keys, sh.entries, sh.shares, sh.users = nil, nil, nil, nil
+	fmt.Fprintln(sh.out, "contraseña restablecida (se han cerrado todas las sesiones; haz login con la nueva)")
+	return nil
+}
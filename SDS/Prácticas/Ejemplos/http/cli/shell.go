@@ -0,0 +1,446 @@
+/*
+Cliente: intérprete de comandos interactivo
+*/
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sdshttp/client"
+	"sdshttp/srv"
+	"sdshttp/tlsconf"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// command es un comando del intérprete
+type command struct {
+	name, args, help string
+	run              func(sh *shell, ctx context.Context, args []string) error
+}
+
+// comandos del intérprete (help, exit y quit se gestionan aparte)
+var commands = []command{
+	{"register", "<usuario> [x25519|ecdsa-p256|rsa|ed25519]", "da de alta un usuario (claves x25519 por defecto) y abre sesión", (*shell).register},
+	{"login", "<usuario> [dispositivo]", "abre una sesión", (*shell).login},
+	{"logout", "", "cierra la sesión actual", (*shell).logout},
+	{"whoami", "", "muestra el usuario y la sesión actual", (*shell).whoami},
+	{"get", "[clave]", "muestra los datos del usuario (o uno de ellos)", (*shell).get},
+	{"put", "<clave> <valor>", "guarda un dato del usuario", (*shell).put},
+	{"list", "", "lista las claves de los datos del usuario", (*shell).list},
+	{"sessions", "", "lista las sesiones abiertas del usuario", (*shell).sessions},
+	{"vault", "list|show|add|edit|rm [nombre], attach <nombre> <fichero>, get|detach <nombre> <adjunto> [fichero]", "gestiona las entradas cifradas de la bóveda y sus adjuntos", (*shell).vault},
+	{"share", "add <nombre> <usuario>|list|show|rm [id]", "comparte entradas de la bóveda", (*shell).share},
+	{"passwd", "", "cambia la contraseña (y cierra las demás sesiones)", (*shell).passwd},
+	{"recovery", "[1|k/n]", "configura la recuperación: una clave o k de n partes", (*shell).recovery},
+	{"recover", "<usuario>", "fija una contraseña nueva con la recuperación", (*shell).recover},
+	{"admin", "users|disable|enable|logout|delete [usuario]", "administra las cuentas (sólo administradores)", (*shell).admin},
+}
+
+// errUsage indica que los argumentos de un comando son incorrectos (exec muestra su uso)
+var errUsage = errors.New("uso incorrecto")
+
+// claves de los datos que gestiona el servidor (no se muestran en list)
+var reservedKeys = map[string]bool{"private": true, "public": true, "keyalg": true}
+
+// shell es el estado del intérprete
+type shell struct {
+	c       *client.Client
+	term    *term.Terminal // terminal con historial y autocompletado (nil si la entrada no es un terminal)
+	in      *bufio.Scanner // entrada por líneas cuando no hay terminal
+	out     io.Writer      // salida (el terminal si lo hay)
+	keys    []string       // claves de datos conocidas (para autocompletar)
+	entries []string       // entradas de la bóveda conocidas (para autocompletar)
+	shares  []string       // identificadores de entradas compartidas conocidos (para autocompletar)
+	users   []string       // usuarios conocidos por admin users (para autocompletar)
+}
+
+// Run gestiona el modo cliente: lee comandos hasta exit, quit o fin de la entrada
+func Run() {
+
+	// verificamos el servidor según las variables de entorno (por defecto con localhost.crt)
+	cfg, err := tlsconf.FromEnv().Config()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	sh := &shell{
+		c:   client.New(client.DefaultURL, client.WithTLSConfig(cfg), client.WithTimeout(30*time.Second)),
+		out: os.Stdout,
+	}
+
+	// si la entrada es un terminal lo ponemos en modo raw para tener historial,
+	// autocompletado y lectura de contraseñas sin eco
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		if old, err := term.MakeRaw(fd); err == nil {
+			defer term.Restore(fd, old)
+			sh.term = term.NewTerminal(struct {
+				io.Reader
+				io.Writer
+			}{os.Stdin, os.Stdout}, "> ")
+			sh.term.AutoCompleteCallback = sh.complete
+			sh.out = sh.term
+		}
+	}
+	if sh.term == nil {
+		sh.in = bufio.NewScanner(os.Stdin)
+	}
+
+	fmt.Fprintln(sh.out, "escribe help para ver los comandos")
+	for {
+		line, err := sh.readLine(sh.prompt())
+		if err != nil {
+			break // fin de la entrada (Ctrl-D)
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "exit" || args[0] == "quit" {
+			break
+		}
+		sh.exec(args)
+	}
+
+	if sh.c.Token != nil { // cerramos la sesión al salir (el token se pierde de todas formas)
+		sh.c.Logout(context.Background())
+	}
+}
+
+// prompt devuelve el indicador con el usuario de la sesión actual
+func (sh *shell) prompt() string {
+	if sh.c.User != "" {
+		return sh.c.User + "> "
+	}
+	return "> "
+}
+
+// readLine lee una línea (con historial si hay terminal)
+func (sh *shell) readLine(prompt string) (string, error) {
+	if sh.term != nil {
+		sh.term.SetPrompt(prompt)
+		return sh.term.ReadLine()
+	}
+	fmt.Fprint(sh.out, prompt)
+	if !sh.in.Scan() {
+		if err := sh.in.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return sh.in.Text(), nil
+}
+
+// readPassword lee una contraseña sin eco (si no hay terminal, como una línea normal)
+func (sh *shell) readPassword(prompt string) (string, error) {
+	if sh.term != nil {
+		return sh.term.ReadPassword(prompt)
+	}
+	return sh.readLine(prompt)
+}
+
+// newPassword pide una contraseña nueva dos veces
+func (sh *shell) newPassword(prompt string) (string, error) {
+	pw, err := sh.readPassword(prompt)
+	if err != nil {
+		return "", err
+	}
+	pw2, err := sh.readPassword("repite la contraseña: ")
+	if err != nil {
+		return "", err
+	}
+	if pw == "" {
+		return "", errors.New("la contraseña no puede estar vacía")
+	}
+	if pw != pw2 {
+		return "", errors.New("las contraseñas no coinciden")
+	}
+	return pw, nil
+}
+
+// exec ejecuta un comando y muestra su error, si lo hay (sin terminar el intérprete)
+func (sh *shell) exec(args []string) {
+	if args[0] == "help" {
+		sh.help()
+		return
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			err := cmd.run(sh, context.Background(), args[1:])
+			if err == errUsage {
+				fmt.Fprintf(sh.out, "uso: %s %s\n", cmd.name, cmd.args)
+			} else if err != nil {
+				fmt.Fprintln(sh.out, "error:", errMsg(err))
+			}
+			return
+		}
+	}
+	fmt.Fprintf(sh.out, "error: comando desconocido %q (escribe help)\n", args[0])
+}
+
+// errMsg devuelve el mensaje de un error para el usuario
+func errMsg(err error) string {
+	var ce *client.Error
+	if errors.As(err, &ce) {
+		return ce.Msg
+	}
+	return err.Error()
+}
+
+// help muestra los comandos disponibles
+func (sh *shell) help() {
+	for _, cmd := range commands {
+		fmt.Fprintf(sh.out, "  %-52s %s\n", cmd.name+" "+cmd.args, cmd.help)
+	}
+	fmt.Fprintf(sh.out, "  %-52s %s\n", "help", "muestra esta ayuda")
+	fmt.Fprintf(sh.out, "  %-52s %s\n", "exit", "sale (también quit o Ctrl-D)")
+}
+
+func (sh *shell) register(ctx context.Context, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+	alg := client.DefaultKeyAlg
+	if len(args) == 2 {
+		alg = args[1]
+	}
+	if !slices.Contains(client.KeyAlgs, alg) {
+		return fmt.Errorf("algoritmo de claves desconocido: %s", alg)
+	}
+	if alg == srv.KeyAlgEd25519 {
+		fmt.Fprintln(sh.out, "aviso: con claves ed25519 (sólo firma) no se le podrán compartir entradas")
+	}
+	client.WithKeyAlg(alg)(sh.c)
+
+	pw, err := sh.newPassword("contraseña: ")
+	if err != nil {
+		return err
+	}
+	if err = sh.c.Register(ctx, args[0], pw); err != nil {
+		return err
+	}
+	fmt.Fprintln(sh.out, "usuario registrado")
+	return sh.askRecovery(ctx)
+}
+
+func (sh *shell) login(ctx context.Context, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+	pw, err := sh.readPassword("contraseña: ")
+	if err != nil {
+		return err
+	}
+	var opts client.LoginOpts
+	if len(args) == 2 {
+		opts.Device = args[1]
+	}
+
+	err = sh.c.Login(ctx, args[0], pw, opts)
+	if errors.Is(err, client.ErrTOTPRequired) { // pedimos el segundo factor y repetimos
+		var code string
+		if code, err = sh.readLine("código TOTP (o de recuperación): "); err != nil {
+			return err
+		}
+		if code = strings.TrimSpace(code); strings.Contains(code, "-") { // XXXX-XXXX-...
+			opts.Recovery = code
+		} else {
+			opts.Code = code
+		}
+		err = sh.c.Login(ctx, args[0], pw, opts)
+	}
+	if err != nil {
+		return err
+	}
+	sh.keys, sh.entries, sh.shares, sh.users = nil, nil, nil, nil
+	fmt.Fprintln(sh.out, "sesión abierta")
+	return nil
+}
+
+func (sh *shell) logout(ctx context.Context, args []string) error {
+	if err := sh.c.Logout(ctx); err != nil {
+		return err
+	}
+	sh.keys, sh.entries, sh.shares, sh.users = nil, nil, nil, nil
+	fmt.Fprintln(sh.out, "sesión cerrada")
+	return nil
+}
+
+func (sh *shell) whoami(ctx context.Context, args []string) error {
+	c, err := sh.c.Claims()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "%s (sesión %s, válida hasta %s)\n", c.Sub, c.Sid, c.Expires().Format("15:04:05"))
+	return nil
+}
+
+func (sh *shell) get(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	data, err := sh.c.GetData(ctx)
+	if err != nil {
+		return err
+	}
+	sh.remember(data)
+
+	if len(args) == 1 {
+		v, ok := data[args[0]]
+		if !ok {
+			return fmt.Errorf("no existe la clave %q", args[0])
+		}
+		fmt.Fprintln(sh.out, v)
+		return nil
+	}
+	for _, k := range sh.keys {
+		fmt.Fprintf(sh.out, "%s = %s\n", k, data[k])
+	}
+	return nil
+}
+
+func (sh *shell) put(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	data, err := sh.c.PutData(ctx, map[string]string{args[0]: strings.Join(args[1:], " ")})
+	if err != nil {
+		return err
+	}
+	sh.remember(data)
+	fmt.Fprintln(sh.out, "dato guardado")
+	return nil
+}
+
+func (sh *shell) list(ctx context.Context, args []string) error {
+	data, err := sh.c.GetData(ctx)
+	if err != nil {
+		return err
+	}
+	sh.remember(data)
+	for _, k := range sh.keys {
+		fmt.Fprintln(sh.out, k)
+	}
+	return nil
+}
+
+func (sh *shell) sessions(ctx context.Context, args []string) error {
+	l, err := sh.c.Sessions(ctx)
+	if err != nil {
+		return err
+	}
+	cur, err := sh.c.Claims()
+	if err != nil {
+		return err
+	}
+	for _, s := range l {
+		mark := " "
+		if s.ID == cur.Sid {
+			mark = "*" // sesión actual
+		}
+		fmt.Fprintf(sh.out, "%s %s [%s] desde %s, visto %s\n", mark, s.ID, s.Device, s.IP, s.Seen.Format("15:04:05"))
+	}
+	return nil
+}
+
+func (sh *shell) passwd(ctx context.Context, args []string) error {
+	if sh.c.Token == nil {
+		return client.ErrNoSession
+	}
+	old, err := sh.readPassword("contraseña actual: ")
+	if err != nil {
+		return err
+	}
+	pw, err := sh.newPassword("contraseña nueva: ")
+	if err != nil {
+		return err
+	}
+	if err = sh.c.ChangePassword(ctx, old, pw); err != nil {
+		return err
+	}
+	fmt.Fprintln(sh.out, "contraseña cambiada (las demás sesiones se han cerrado)")
+	return nil
+}
+
+// remember guarda las claves de los datos del usuario (ordenadas, sin las reservadas)
+func (sh *shell) remember(data map[string]string) {
+	sh.keys = sh.keys[:0]
+	for k := range data {
+		if !reservedKeys[k] {
+			sh.keys = append(sh.keys, k)
+		}
+	}
+	sort.Strings(sh.keys)
+}
+
+// complete autocompleta con el tabulador el nombre del comando, en get y put la clave
+// y en vault y share el subcomando y el nombre o identificador de la entrada
+func (sh *shell) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	head := line[:pos]
+	fields := strings.Fields(head)
+	if strings.HasSuffix(head, " ") || len(fields) == 0 {
+		fields = append(fields, "") // empezamos una palabra nueva
+	}
+
+	var cands []string
+	switch {
+	case len(fields) == 1:
+		for _, cmd := range commands {
+			cands = append(cands, cmd.name)
+		}
+		cands = append(cands, "help", "exit", "quit")
+	case len(fields) == 2 && (fields[0] == "get" || fields[0] == "put"):
+		cands = sh.keys
+	case len(fields) == 2 && fields[0] == "vault":
+		cands = vaultCommands
+	case len(fields) == 3 && fields[0] == "vault" && fields[1] != "add" && fields[1] != "list":
+		cands = sh.entries
+	case len(fields) == 2 && fields[0] == "share":
+		cands = shareCommands
+	case len(fields) == 3 && fields[0] == "share" && fields[1] == "add":
+		cands = sh.entries
+	case len(fields) == 3 && fields[0] == "share" && (fields[1] == "show" || fields[1] == "rm"):
+		cands = sh.shares
+	case len(fields) == 2 && fields[0] == "admin":
+		cands = adminCommands
+	case len(fields) == 3 && fields[0] == "admin" && fields[1] != "users":
+		cands = sh.users
+	default:
+		return "", 0, false
+	}
+
+	word := fields[len(fields)-1]
+	var matches []string
+	for _, c := range cands {
+		if strings.HasPrefix(c, word) {
+			matches = append(matches, c)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+
+	completion := matches[0] // prefijo común de todas las coincidencias
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, completion) {
+			completion = completion[:len(completion)-1]
+		}
+	}
+	if len(matches) == 1 {
+		completion += " "
+	}
+	head = head[:len(head)-len(word)] + completion
+	return head + line[pos:], len(head), true
+}
@@ -0,0 +1,208 @@
+/*
+Cliente: comandos de la bóveda y de las entradas compartidas del intérprete
+*/
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sdshttp/client"
+	"sort"
+)
+
+// subcomandos de vault (para la ayuda y el autocompletado)
+var vaultCommands = []string{"list", "show", "add", "edit", "rm", "attach", "get", "detach"}
+
+// argumentos de cada subcomando de vault (con el propio subcomando)
+var vaultArgs = map[string]int{"list": 1, "show": 2, "add": 2, "edit": 2, "rm": 2, "attach": 3, "get": 4, "detach": 3}
+
+func (sh *shell) vault(ctx context.Context, args []string) error {
+	if len(args) == 0 || vaultArgs[args[0]] != len(args) {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "list":
+		l, err := sh.c.VaultList(ctx)
+		if err != nil {
+			return err
+		}
+		sh.entries = sh.entries[:0]
+		for _, e := range l {
+			sh.entries = append(sh.entries, e.Name)
+			fmt.Fprintf(sh.out, "%-20s v%d, modificada %s\n", e.Name, e.Version, e.Updated.Format("2006-01-02 15:04"))
+		}
+		return nil
+
+	case "show":
+		e, v, err := sh.c.VaultGet(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(sh.out, "sitio:   %s\nusuario: %s\nsecreto: %s\nnotas:   %s\n(v%d, creada %s)\n",
+			e.Site, e.Username, e.Secret, e.Notes, v.Version, v.Created.Format("2006-01-02 15:04"))
+		names := make([]string, 0, len(v.Attachments))
+		for att := range v.Attachments {
+			names = append(names, att)
+		}
+		sort.Strings(names)
+		for _, att := range names {
+			a := v.Attachments[att]
+			fmt.Fprintf(sh.out, "adjunto: %s (%d bytes cifrados, %s)\n", att, a.Size, a.Updated.Format("2006-01-02 15:04"))
+		}
+		return nil
+
+	case "add":
+		var e client.Entry
+		if err := sh.editEntry(&e, false); err != nil {
+			return err
+		}
+		if _, err := sh.c.VaultPut(ctx, args[1], e, 0); err != nil {
+			return err
+		}
+		sh.entries = append(sh.entries, args[1])
+		fmt.Fprintln(sh.out, "entrada creada")
+		return nil
+
+	case "edit":
+		e, v, err := sh.c.VaultGet(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		if err = sh.editEntry(&e, true); err != nil {
+			return err
+		}
+		if v, err = sh.c.VaultPut(ctx, args[1], e, v.Version); err != nil {
+			return err // p.ej. modificada desde otro dispositivo mientras tanto
+		}
+		fmt.Fprintf(sh.out, "entrada modificada (v%d)\n", v.Version)
+		return nil
+
+	case "rm":
+		if err := sh.c.VaultDelete(ctx, args[1], 0); err != nil {
+			return err
+		}
+		fmt.Fprintln(sh.out, "entrada borrada")
+		return nil
+
+	case "attach": // attach <entrada> <fichero>: el adjunto se llama como el fichero
+		f, err := os.Open(args[2])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		att := filepath.Base(args[2])
+		if _, err = sh.c.AttachmentPut(ctx, args[1], att, f); err != nil {
+			return err
+		}
+		fmt.Fprintf(sh.out, "adjunto %s guardado\n", att)
+		return nil
+
+	case "get": // get <entrada> <adjunto> <fichero>
+		f, err := os.OpenFile(args[3], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		n, err := sh.c.AttachmentGet(ctx, args[1], args[2], f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(args[3]) // lo escrito no es fiable
+			return err
+		}
+		fmt.Fprintf(sh.out, "adjunto guardado en %s (%d bytes)\n", args[3], n)
+		return nil
+
+	case "detach": // detach <entrada> <adjunto>
+		if err := sh.c.AttachmentDelete(ctx, args[1], args[2]); err != nil {
+			return err
+		}
+		fmt.Fprintln(sh.out, "adjunto borrado")
+		return nil
+	}
+	return errUsage
+}
+
+// editEntry pide los campos de una entrada (al editar, vacío mantiene el valor actual)
+func (sh *shell) editEntry(e *client.Entry, edit bool) error {
+	ask := func(label string, v *string, secret bool) error {
+		prompt := label + ": "
+		if edit {
+			prompt = label + " (vacío para mantenerlo): "
+		}
+		read := sh.readLine
+		if secret {
+			read = sh.readPassword
+		}
+		s, err := read(prompt)
+		if err == nil && (s != "" || !edit) {
+			*v = s
+		}
+		return err
+	}
+
+	if err := ask("sitio", &e.Site, false); err != nil {
+		return err
+	}
+	if err := ask("usuario", &e.Username, false); err != nil {
+		return err
+	}
+	if err := ask("secreto", &e.Secret, true); err != nil {
+		return err
+	}
+	return ask("notas", &e.Notes, false)
+}
+
+// subcomandos de share (para la ayuda y el autocompletado)
+var shareCommands = []string{"add", "list", "show", "rm"}
+
+func (sh *shell) share(ctx context.Context, args []string) error {
+	switch {
+	case len(args) == 3 && args[0] == "add":
+		s, err := sh.c.Share(ctx, args[1], args[2])
+		if err != nil {
+			return err
+		}
+		sh.shares = append(sh.shares, s.ID)
+		fmt.Fprintf(sh.out, "entrada compartida con %s (id %s)\n", s.To, s.ID)
+		return nil
+
+	case len(args) == 1 && args[0] == "list":
+		in, out, err := sh.c.Shares(ctx)
+		if err != nil {
+			return err
+		}
+		sh.shares = sh.shares[:0]
+		fmt.Fprintln(sh.out, "recibidas:")
+		for _, s := range in {
+			sh.shares = append(sh.shares, s.ID)
+			fmt.Fprintf(sh.out, "  %s  %s de %s (v%d, %s)\n", s.ID, s.Name, s.From, s.Version, s.Created.Format("2006-01-02 15:04"))
+		}
+		fmt.Fprintln(sh.out, "enviadas:")
+		for _, s := range out {
+			sh.shares = append(sh.shares, s.ID)
+			fmt.Fprintf(sh.out, "  %s  %s para %s (v%d, %s)\n", s.ID, s.Name, s.To, s.Version, s.Created.Format("2006-01-02 15:04"))
+		}
+		return nil
+
+	case len(args) == 2 && args[0] == "show":
+		e, s, err := sh.c.OpenShare(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(sh.out, "sitio:   %s\nusuario: %s\nsecreto: %s\nnotas:   %s\n(%s de %s, v%d)\n",
+			e.Site, e.Username, e.Secret, e.Notes, s.Name, s.From, s.Version)
+		return nil
+
+	case len(args) == 2 && args[0] == "rm":
+		if err := sh.c.Unshare(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Fprintln(sh.out, "entrada compartida borrada")
+		return nil
+	}
+	return errUsage
+}
@@ -0,0 +1,39 @@
+/*
+Operaciones de administración (requieren una sesión de administrador)
+*/
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sdshttp/srv"
+)
+
+// AdminUsers devuelve el resumen de todos los usuarios
+func (c *Client) AdminUsers(ctx context.Context) (l []srv.UserInfo, err error) {
+	_, err = c.do(ctx, http.MethodGet, "/v1/admin/users", nil, &l, true)
+	return
+}
+
+// AdminDisable deshabilita (cerrando sus sesiones) o habilita la cuenta de un usuario
+func (c *Client) AdminDisable(ctx context.Context, user string, disabled bool) error {
+	op := "/enable"
+	if disabled {
+		op = "/disable"
+	}
+	_, err := c.do(ctx, http.MethodPost, "/v1/admin/users/"+url.PathEscape(user)+op, nil, nil, true)
+	return err
+}
+
+// AdminLogout cierra todas las sesiones de un usuario
+func (c *Client) AdminLogout(ctx context.Context, user string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/v1/admin/users/"+url.PathEscape(user)+"/sessions", nil, nil, true)
+	return err
+}
+
+// AdminDelete borra la cuenta de un usuario con todos sus datos
+func (c *Client) AdminDelete(ctx context.Context, user string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/v1/admin/users/"+url.PathEscape(user), nil, nil, true)
+	return err
+}
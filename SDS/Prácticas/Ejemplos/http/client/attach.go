@@ -0,0 +1,139 @@
+/*
+Adjuntos de las entradas de la bóveda
+
+cada adjunto se comprime y se cifra en flujo (util.NewCompressSealWriter) con una clave
+aleatoria propia, cifrada a su vez con la clave de bóveda como las de las entradas;
+ni la subida ni la descarga cargan el fichero entero en memoria
+*/
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+	"sdshttp/srv"
+	"sdshttp/util"
+)
+
+// attachPath es la ruta de la API de un adjunto
+func attachPath(name, att string) string {
+	return "/v1/me/vault/" + url.PathEscape(name) + "/attachments/" + url.PathEscape(att)
+}
+
+// attachLimits son los límites de la descompresión de un adjunto: el contenido está
+// autentificado con una clave del usuario y se escribe en w sin pasar por memoria, así que
+// sólo se acota el tamaño (la razón rechazaría ficheros legítimos muy repetitivos)
+var attachLimits = util.Limits{MaxSize: 64 << 30}
+
+// attachAD son los datos asociados del contenido de un adjunto: lo ligan a su entrada y su
+// nombre (el servidor no puede intercambiar adjuntos entre entradas)
+func attachAD(name, att string) []byte {
+	return []byte("sdshttp attachment " + name + "\x00" + att)
+}
+
+// attachKeyAD son los datos asociados de la clave de un adjunto (igual que attachAD)
+func attachKeyAD(name, att string) []byte {
+	return []byte("sdshttp attachment key " + name + "\x00" + att)
+}
+
+// AttachmentPut cifra y sube como adjunto att de la entrada name lo que lee de r
+// (si ya existe, lo reemplaza)
+func (c *Client) AttachmentPut(ctx context.Context, name, att string, r io.Reader) (a srv.Attachment, err error) {
+	if c.keyData == nil {
+		return a, ErrNoSession
+	}
+	key := make([]byte, 32)
+	if _, err = rand.Read(key); err != nil {
+		return a, err
+	}
+	wrapped, err := sealData(vaultKey(c.keyData), key, attachKeyAD(name, att))
+	if err != nil {
+		return a, err
+	}
+
+	pr, pw := io.Pipe() // el cifrado escribe en la petición mientras se envía
+	go func() {
+		sw, err := util.NewCompressSealWriter(pw, c.codec, util.DefaultAlg, key, attachAD(name, att))
+		if err == nil {
+			if _, err = io.Copy(sw, r); err == nil {
+				err = sw.Close()
+			}
+		}
+		pw.CloseWithError(err) // nil cierra normalmente
+	}()
+	defer pr.Close() // si la petición falla, el cifrado deja de escribir
+
+	req, err := c.request(ctx, http.MethodPut, attachPath(name, att), pr, true)
+	if err != nil {
+		return a, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(srv.AttachmentKeyHeader, base64.StdEncoding.EncodeToString(wrapped))
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return a, err
+	}
+	defer resp.Body.Close()
+	_, err = decodeResp(resp, &a)
+	return a, err
+}
+
+// AttachmentGet descarga, descifra y escribe en w el adjunto att de la entrada name
+// (ErrTampered si el contenido se ha modificado; lo escrito hasta entonces no es fiable)
+func (c *Client) AttachmentGet(ctx context.Context, name, att string, w io.Writer) (n int64, err error) {
+	if c.keyData == nil {
+		return 0, ErrNoSession
+	}
+	req, err := c.request(ctx, http.MethodGet, attachPath(name, att), nil, true)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK { // los errores llegan en JSON
+		_, err = decodeResp(resp, nil)
+		return 0, err
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(resp.Header.Get(srv.AttachmentKeyHeader))
+	if err != nil {
+		return 0, ErrTampered
+	}
+	key, err := openData(vaultKey(c.keyData), wrapped, attachKeyAD(name, att))
+	if err != nil {
+		return 0, err
+	}
+	zr, err := util.NewOpenDecompressReader(resp.Body, key, attachAD(name, att), attachLimits)
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+	return io.Copy(w, zr)
+}
+
+// AttachmentDelete borra el adjunto att de la entrada name
+func (c *Client) AttachmentDelete(ctx context.Context, name, att string) error {
+	_, err := c.do(ctx, http.MethodDelete, attachPath(name, att), nil, nil, true)
+	return err
+}
+
+// rewrapAttachments cifra de nuevo con newVault las claves de los adjuntos de una entrada
+func rewrapAttachments(oldVault, newVault []byte, v srv.VaultEntry) (map[string][]byte, error) {
+	keys := make(map[string][]byte, len(v.Attachments))
+	for att, a := range v.Attachments {
+		key, err := openData(oldVault, a.Key, attachKeyAD(v.Name, att))
+		if err != nil {
+			return nil, err
+		}
+		if keys[att], err = sealData(newVault, key, attachKeyAD(v.Name, att)); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
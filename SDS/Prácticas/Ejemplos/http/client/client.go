@@ -0,0 +1,347 @@
+/*
+Cliente (SDK) para la API REST de sdshttp
+
+Ejemplo de uso:
+
+	cfg, err := tlsconf.FromEnv().Config()
+	c := client.New("https://localhost:10443", client.WithTLSConfig(cfg), client.WithTimeout(10*time.Second))
+	err := c.Login(ctx, "usuario", "contraseña", client.LoginOpts{})
+	datos, err := c.GetData(ctx)
+*/
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sdshttp/srv"
+	"sdshttp/util"
+	"strings"
+	"time"
+)
+
+// DefaultURL es la dirección por defecto del servidor
+const DefaultURL = "https://localhost:10443"
+
+// Client mantiene la configuración y la sesión con el servidor
+// (no es seguro usar la misma sesión desde varias gorutinas que hagan login/logout a la vez)
+type Client struct {
+	BaseURL string       // dirección del servidor (sin / al final)
+	HTTP    *http.Client // cliente HTTP subyacente
+
+	User    string     // usuario de la sesión actual
+	Token   []byte     // token de la sesión actual (lo guardan Register y Login)
+	keyData []byte     // clave para los datos cifrados en el cliente (derivada de la contraseña)
+	keyAlg  string     // algoritmo de las claves de las cuentas nuevas (DefaultKeyAlg si vacío)
+	codec   util.Codec // compresión de las entradas y adjuntos (util.DefaultCodec por defecto)
+}
+
+// Option modifica la configuración de un Client en New
+type Option func(*Client)
+
+// WithTLSConfig usa la configuración TLS indicada (certificados raíz, etc.)
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *Client) {
+		c.HTTP.Transport = &http.Transport{TLSClientConfig: cfg}
+	}
+}
+
+// WithTimeout limita la duración total de cada petición
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.HTTP.Timeout = d }
+}
+
+// WithHTTPClient usa un cliente HTTP ya configurado
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) { c.HTTP = h }
+}
+
+// WithKeyAlg elige el algoritmo de las claves de las cuentas que se registren (ver KeyAlgs)
+func WithKeyAlg(alg string) Option {
+	return func(c *Client) { c.keyAlg = alg }
+}
+
+// WithCodec elige la compresión de las entradas y adjuntos que se guarden (ver util.Codecs);
+// al leerlos, el códec se detecta solo
+func WithCodec(codec util.Codec) Option {
+	return func(c *Client) { c.codec = codec }
+}
+
+// New crea un cliente para el servidor en baseURL ("" para DefaultURL)
+func New(baseURL string, opts ...Option) *Client {
+	if baseURL == "" {
+		baseURL = DefaultURL
+	}
+	c := &Client{BaseURL: strings.TrimRight(baseURL, "/"), HTTP: &http.Client{Timeout: 30 * time.Second},
+		codec: util.DefaultCodec}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// Error es un error devuelto por el servidor (ver srv.APIError)
+// se puede comparar con errors.Is contra las variables Err* de este paquete
+type Error struct {
+	Status int    // código de estado HTTP
+	Code   string // código de error (srv.Resp.Code)
+	Msg    string // mensaje del servidor
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("%s (%s)", e.Msg, e.Code) }
+
+// Is compara por código de error
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// errores del servidor (para comparar con errors.Is)
+var (
+	ErrBadRequest         = fromAPI(srv.ErrBadRequest)
+	ErrUnauthorized       = fromAPI(srv.ErrUnauthorized)
+	ErrInvalidCredentials = fromAPI(srv.ErrBadCredentials)
+	ErrTOTPRequired       = fromAPI(srv.ErrTOTPRequired)
+	ErrTOTPInvalid        = fromAPI(srv.ErrTOTPInvalid)
+	ErrUserExists         = fromAPI(srv.ErrUserExists)
+	ErrTOTPEnabled        = fromAPI(srv.ErrTOTPEnabled)
+	ErrTOTPNotPending     = fromAPI(srv.ErrTOTPNotPending)
+	ErrSessionNotFound    = fromAPI(srv.ErrSessionNotFound)
+	ErrEntryNotFound      = fromAPI(srv.ErrEntryNotFound)
+	ErrEntryExists        = fromAPI(srv.ErrEntryExists)
+	ErrVersionConflict    = fromAPI(srv.ErrVersionConflict)
+	ErrVaultFull          = fromAPI(srv.ErrVaultFull)
+	ErrAttachmentNotFound = fromAPI(srv.ErrAttachmentNotFound)
+	ErrAttachmentTooLarge = fromAPI(srv.ErrAttachmentTooLarge)
+	ErrAttachmentsFull    = fromAPI(srv.ErrAttachmentsFull)
+	ErrUserNotFound       = fromAPI(srv.ErrUserNotFound)
+	ErrShareNotFound      = fromAPI(srv.ErrShareNotFound)
+	ErrSharesFull         = fromAPI(srv.ErrSharesFull)
+	ErrRateLimited        = fromAPI(srv.ErrRateLimited)
+	ErrLockedOut          = fromAPI(srv.ErrLockedOut)
+	ErrNoRecovery         = fromAPI(srv.ErrNoRecovery)
+	ErrForbidden          = fromAPI(srv.ErrForbidden)
+	ErrAccountDisabled    = fromAPI(srv.ErrAccountDisabled)
+	ErrInternal           = fromAPI(srv.ErrInternal)
+)
+
+// fromAPI construye el error del cliente equivalente a uno del servidor
+func fromAPI(e *srv.APIError) *Error {
+	return &Error{Status: e.Status, Code: e.Code, Msg: e.Msg}
+}
+
+// ErrNoSession indica que se ha llamado a un método que requiere sesión sin haber hecho login
+var ErrNoSession = errors.New("no hay sesión: haz login primero")
+
+// do hace una petición a la API y decodifica la respuesta; si out no es nil, decodifica en él Resp.Data
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}, auth bool) (*srv.Resp, error) {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := c.request(ctx, method, path, body, auth)
+	if err != nil {
+		return nil, err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	r, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close() // hay que cerrar el reader del body
+	return decodeResp(r, out)
+}
+
+// request prepara una petición a la API (con el token de la sesión si auth)
+func (c *Client) request(ctx context.Context, method, path string, body io.Reader, auth bool) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if auth {
+		if c.Token == nil {
+			return nil, ErrNoSession
+		}
+		req.Header.Set("Authorization", "Bearer "+string(c.Token))
+	}
+	return req, nil
+}
+
+// decodeResp lee la respuesta JSON del servidor y, si es correcta, decodifica sus datos en out
+func decodeResp(r *http.Response, out interface{}) (*srv.Resp, error) {
+	resp := &srv.Resp{}
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		return nil, fmt.Errorf("respuesta inválida del servidor (HTTP %d): %w", r.StatusCode, err)
+	}
+	if !resp.Ok {
+		return resp, &Error{Status: r.StatusCode, Code: resp.Code, Msg: resp.Msg}
+	}
+	if out != nil && resp.Data != nil {
+		if err := json.Unmarshal(resp.Data, out); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
+
+// LoginOpts son los datos opcionales de un login
+type LoginOpts struct {
+	Code     string // código TOTP (si está activado)
+	Recovery string // código de recuperación (en lugar del TOTP)
+	Device   string // etiqueta del dispositivo para el listado de sesiones
+}
+
+// Register da de alta al usuario: deriva las claves de la contraseña, genera su par de claves
+// y abre la primera sesión
+func (c *Client) Register(ctx context.Context, user, password string) error {
+	alg := c.keyAlg
+	if alg == "" {
+		alg = DefaultKeyAlg
+	}
+	keyLogin, keyData := DeriveKeys(password)
+	pub, pri, err := newKeyPair(keyData, alg)
+	if err != nil {
+		return err
+	}
+	in := map[string]interface{}{"user": user, "pass": keyLogin, "pubkey": pub, "prikey": pri, "keyalg": alg}
+	resp, err := c.do(ctx, http.MethodPost, "/v1/users", in, nil, false)
+	if err != nil {
+		return err
+	}
+	c.User, c.Token, c.keyData = user, resp.Token, keyData
+	return nil
+}
+
+// Login abre una sesión nueva (las anteriores del usuario siguen abiertas)
+func (c *Client) Login(ctx context.Context, user, password string, opts LoginOpts) error {
+	keyLogin, keyData := DeriveKeys(password)
+	in := map[string]interface{}{"user": user, "pass": keyLogin,
+		"code": opts.Code, "recovery": opts.Recovery, "device": opts.Device}
+	resp, err := c.do(ctx, http.MethodPost, "/v1/sessions", in, nil, false)
+	if err != nil {
+		return err
+	}
+	c.User, c.Token, c.keyData = user, resp.Token, keyData
+	return nil
+}
+
+// Claims devuelve el contenido del token de la sesión actual (sin verificar la firma)
+func (c *Client) Claims() (srv.Claims, error) {
+	if c.Token == nil {
+		return srv.Claims{}, ErrNoSession
+	}
+	return srv.ReadClaims(c.Token)
+}
+
+// Logout cierra la sesión actual y olvida el token
+func (c *Client) Logout(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodDelete, "/v1/sessions/current", nil, nil, true)
+	if err == nil || errors.Is(err, ErrUnauthorized) { // si ya no era válida, también la olvidamos
+		c.forget()
+	}
+	return err
+}
+
+// LogoutSession cierra otra sesión del usuario (por su identificador)
+func (c *Client) LogoutSession(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/v1/sessions/"+id, nil, nil, true)
+	return err
+}
+
+// LogoutAll cierra todas las sesiones del usuario (incluida la actual)
+func (c *Client) LogoutAll(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodDelete, "/v1/sessions", nil, nil, true)
+	if err == nil {
+		c.forget()
+	}
+	return err
+}
+
+// forget olvida la sesión actual
+func (c *Client) forget() {
+	c.User, c.Token, c.keyData = "", nil, nil
+}
+
+// Sessions devuelve las sesiones abiertas del usuario
+func (c *Client) Sessions(ctx context.Context) (l []srv.Session, err error) {
+	_, err = c.do(ctx, http.MethodGet, "/v1/sessions", nil, &l, true)
+	return
+}
+
+// GetData devuelve los datos del usuario
+func (c *Client) GetData(ctx context.Context) (data map[string]string, err error) {
+	_, err = c.do(ctx, http.MethodGet, "/v1/me/data", nil, &data, true)
+	return
+}
+
+// PutData añade o reemplaza datos del usuario y devuelve todos sus datos
+func (c *Client) PutData(ctx context.Context, data map[string]string) (all map[string]string, err error) {
+	_, err = c.do(ctx, http.MethodPut, "/v1/me/data", data, &all, true)
+	return
+}
+
+// ChangePassword cambia la contraseña del usuario de la sesión actual y cierra sus demás sesiones
+// (la clave privada y las claves de la bóveda se descifran y se vuelven a cifrar en el cliente
+// con la nueva clave de datos; si la bóveda cambia mientras tanto, devuelve ErrVersionConflict)
+func (c *Client) ChangePassword(ctx context.Context, old, password string) error {
+	if c.keyData == nil {
+		return ErrNoSession
+	}
+	oldLogin, oldData := DeriveKeys(old)
+	if subtle.ConstantTimeCompare(oldData, c.keyData) != 1 { // no coincide con la del login
+		return ErrInvalidCredentials
+	}
+	newLogin, newData := DeriveKeys(password)
+
+	data, err := c.GetData(ctx)
+	if err != nil {
+		return err
+	}
+	entries, err := c.vaultKeys(ctx)
+	if err != nil {
+		return err
+	}
+	r, err := c.recovery(ctx, oldData)
+	if err != nil {
+		return err
+	}
+
+	in, err := rewrap(oldData, newData, data["private"], entries, r)
+	if err != nil {
+		return err
+	}
+	in["pass"], in["new"] = oldLogin, newLogin
+	if _, err = c.do(ctx, http.MethodPut, "/v1/me/password", in, nil, true); err != nil {
+		return err
+	}
+	c.keyData = newData
+	return nil
+}
+
+// SetupTOTP inicia el alta del segundo factor y devuelve la URI otpauth:// con el secreto
+func (c *Client) SetupTOTP(ctx context.Context) (string, error) {
+	var out struct{ URI string }
+	_, err := c.do(ctx, http.MethodPost, "/v1/me/totp", nil, &out, true)
+	return out.URI, err
+}
+
+// ConfirmTOTP activa el segundo factor con un primer código y devuelve los códigos de recuperación
+func (c *Client) ConfirmTOTP(ctx context.Context, code string) ([]string, error) {
+	var out struct{ Recovery []string }
+	_, err := c.do(ctx, http.MethodPost, "/v1/me/totp/confirm", map[string]string{"code": code}, &out, true)
+	return out.Recovery, err
+}
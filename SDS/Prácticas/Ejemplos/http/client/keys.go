@@ -0,0 +1,282 @@
+/*
+Claves del cliente
+
+el par de claves de cada usuario puede ser de varios algoritmos (ver srv.KeyAlg...): la pública
+se sube en PKIX y la privada en PKCS#8 cifrada con keyData; las cuentas antiguas tienen una RSA
+serializada con JSON y comprimida (srv.KeyAlgRSAJSON), que se sigue pudiendo usar
+*/
+package client
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sdshttp/srv"
+	"sdshttp/util"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// DefaultKeyAlg es el algoritmo de las claves de las cuentas nuevas (ver WithKeyAlg)
+const DefaultKeyAlg = srv.KeyAlgX25519
+
+// KeyAlgs son los algoritmos que se pueden elegir para las claves de una cuenta nueva
+var KeyAlgs = []string{srv.KeyAlgX25519, srv.KeyAlgP256, srv.KeyAlgRSA, srv.KeyAlgEd25519}
+
+// errores de las claves
+var (
+	ErrKeyAlg       = errors.New("algoritmo de clave no soportado")
+	ErrSignOnlyKey  = errors.New("la clave del destinatario sólo sirve para firmar (ed25519): no se le puede compartir")
+	ErrWrappedShare = errors.New("clave de la entrada compartida malformada")
+	ErrTampered     = util.ErrAuth // datos cifrados modificados (o descifrados con otra clave)
+)
+
+// datos asociados del cifrado de cada tipo de dato (ver util.Seal): así un dato cifrado
+// no se puede hacer pasar por otro de distinto tipo
+var (
+	adPriKey   = []byte("sdshttp prikey")
+	adEntryKey = []byte("sdshttp entry key")
+	adEntry    = []byte("sdshttp entry")
+	adEscrow   = []byte("sdshttp recovery escrow")
+	adSecret   = []byte("sdshttp recovery secret")
+)
+
+// sealData cifra datos del usuario con AEAD (util.DefaultAlg)
+func sealData(key, data, ad []byte) ([]byte, error) {
+	return util.Seal(util.DefaultAlg, key, data, ad)
+}
+
+// openData descifra datos de sealData o, si son de versiones anteriores, cifrados con
+// util.Encrypt (éstos sin autentificar; se migran al volver a cifrarlos, p.ej. con passwd)
+func openData(key, data, ad []byte) ([]byte, error) {
+	plain, _, err := util.OpenLegacy(key, data, ad)
+	return plain, err
+}
+
+// rsaBits es el tamaño de las claves RSA nuevas
+const rsaBits = 3072
+
+// shareInfo separa las claves derivadas con ECDH para compartir de otros usos
+const shareInfo = "sdshttp share v1"
+
+// DeriveKeys obtiene de la contraseña las claves de login y de datos
+// (hash con SHA512: una mitad para el login y la otra para los datos, 256 bits cada una)
+func DeriveKeys(password string) (keyLogin, keyData []byte) {
+	keyClient := sha512.Sum512([]byte(password))
+	return keyClient[:32], keyClient[32:64]
+}
+
+// generateKey genera una clave privada del algoritmo indicado
+func generateKey(alg string) (crypto.PrivateKey, error) {
+	switch alg {
+	case srv.KeyAlgRSA:
+		k, err := rsa.GenerateKey(rand.Reader, rsaBits)
+		if err != nil {
+			return nil, err
+		}
+		k.Precompute()
+		return k, nil
+	case srv.KeyAlgP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case srv.KeyAlgEd25519:
+		_, k, err := ed25519.GenerateKey(rand.Reader)
+		return k, err
+	case srv.KeyAlgX25519:
+		return ecdh.X25519().GenerateKey(rand.Reader)
+	}
+	return nil, fmt.Errorf("%w: %q", ErrKeyAlg, alg)
+}
+
+// newKeyPair genera el par de claves del usuario y lo codifica para el registro:
+// la pública en PKIX y la privada en PKCS#8 cifrada con keyData (ambas en base64)
+func newKeyPair(keyData []byte, alg string) (pub, pri string, err error) {
+	k, err := generateKey(alg)
+	if err != nil {
+		return
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(k.(interface{ Public() crypto.PublicKey }).Public())
+	if err != nil {
+		return
+	}
+	priDER, err := x509.MarshalPKCS8PrivateKey(k)
+	if err != nil {
+		return
+	}
+
+	enc, err := sealData(keyData, priDER, adPriKey)
+	if err != nil {
+		return
+	}
+	pub, pri = util.Encode64(pubDER), util.Encode64(enc)
+	return
+}
+
+// AccountKeys genera los datos de registro de una cuenta nueva a partir de la contraseña:
+// keyLogin y el par de claves codificado (p.ej. para crear el primer administrador sin servidor)
+func AccountKeys(password, alg string) (keyLogin []byte, pub, pri string, err error) {
+	keyLogin, keyData := DeriveKeys(password)
+	pub, pri, err = newKeyPair(keyData, alg)
+	return
+}
+
+// parsePublicKey decodifica una clave pública tal como se sube en el registro
+func parsePublicKey(pub, alg string) (crypto.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(pub)
+	if err != nil {
+		return nil, err
+	}
+	if alg == srv.KeyAlgRSAJSON {
+		if raw, err = util.Decompress(raw); err != nil {
+			return nil, err
+		}
+		k := &rsa.PublicKey{}
+		if err = json.Unmarshal(raw, k); err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+	return x509.ParsePKIXPublicKey(raw)
+}
+
+// privateKey obtiene del servidor la clave privada del usuario y la descifra con keyData
+func (c *Client) privateKey(ctx context.Context) (crypto.PrivateKey, error) {
+	if c.keyData == nil {
+		return nil, ErrNoSession
+	}
+	data, err := c.GetData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(data["private"])
+	if err != nil {
+		return nil, err
+	}
+	der, err := openData(c.keyData, raw, adPriKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if data["keyalg"] == "" || data["keyalg"] == srv.KeyAlgRSAJSON { // formato antiguo
+		if der, err = util.Decompress(der); err != nil {
+			return nil, err
+		}
+		k := &rsa.PrivateKey{}
+		if err = json.Unmarshal(der, k); err != nil {
+			return nil, err
+		}
+		k.Precompute()
+		return k, nil
+	}
+	return x509.ParsePKCS8PrivateKey(der)
+}
+
+// wrapKey cifra la clave de una entrada para la clave pública de su destinatario:
+// RSA-OAEP con SHA-256, o ECDH con una clave efímera + HKDF-SHA256 + AES-GCM
+// (en este caso el resultado es la pública efímera, el nonce y el texto cifrado)
+func wrapKey(pub crypto.PublicKey, key []byte) ([]byte, error) {
+	var peer *ecdh.PublicKey
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.EncryptOAEP(sha256.New(), rand.Reader, k, key, nil)
+	case ed25519.PublicKey:
+		return nil, ErrSignOnlyKey
+	case *ecdsa.PublicKey:
+		var err error
+		if peer, err = k.ECDH(); err != nil {
+			return nil, err
+		}
+	case *ecdh.PublicKey:
+		peer = k
+	default:
+		return nil, ErrKeyAlg
+	}
+
+	eph, err := peer.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := eph.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	ephPub := eph.PublicKey().Bytes()
+	aead, err := shareAEAD(secret, ephPub, peer.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return append(append(ephPub, nonce...), aead.Seal(nil, nonce, key, ephPub)...), nil
+}
+
+// unwrapKey descifra con la clave privada del destinatario una clave cifrada con wrapKey
+func unwrapKey(pk crypto.PrivateKey, wrapped []byte) ([]byte, error) {
+	var priv *ecdh.PrivateKey
+	switch k := pk.(type) {
+	case *rsa.PrivateKey:
+		return rsa.DecryptOAEP(sha256.New(), rand.Reader, k, wrapped, nil)
+	case *ecdsa.PrivateKey:
+		var err error
+		if priv, err = k.ECDH(); err != nil {
+			return nil, err
+		}
+	case *ecdh.PrivateKey:
+		priv = k
+	default:
+		return nil, ErrKeyAlg
+	}
+
+	n := len(priv.PublicKey().Bytes()) // la efímera es de la misma curva
+	if len(wrapped) < n {
+		return nil, ErrWrappedShare
+	}
+	ephPub := wrapped[:n]
+	eph, err := priv.Curve().NewPublicKey(ephPub)
+	if err != nil {
+		return nil, ErrWrappedShare
+	}
+	secret, err := priv.ECDH(eph)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := shareAEAD(secret, ephPub, priv.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < n+aead.NonceSize() {
+		return nil, ErrWrappedShare
+	}
+	nonce, ct := wrapped[n:n+aead.NonceSize()], wrapped[n+aead.NonceSize():]
+	return aead.Open(nil, nonce, ct, ephPub)
+}
+
+// shareAEAD deriva del secreto ECDH (con las dos públicas como sal) la clave AES-GCM para compartir
+func shareAEAD(secret, ephPub, peerPub []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephPub...), peerPub...)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(shareInfo)), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,216 @@
+/*
+Recuperación de la cuenta
+
+el cliente genera un secreto aleatorio R y de él deriva (HKDF) una clave de autentificación,
+que el servidor guarda como una contraseña, y una clave con la que cifra keyData (Escrow);
+R se entrega al usuario como una clave imprimible o repartido en partes de Shamir (k de n)
+y el servidor sólo guarda R cifrado con keyData (para rehacer Escrow al cambiar la contraseña)
+*/
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"io"
+	"net/http"
+	"sdshttp/srv"
+	"sdshttp/util"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// ErrRecoveryKey indica una clave o parte de recuperación mal escrita (no cuadra la suma de control)
+var ErrRecoveryKey = errors.New("clave de recuperación inválida (revisa que esté bien escrita)")
+
+// recoveryKeys deriva de R la clave de autentificación y la que cifra keyData
+func recoveryKeys(r []byte) (auth, wrap []byte) {
+	derive := func(info string) []byte {
+		key := make([]byte, 32)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, r, nil, []byte(info)), key); err != nil {
+			panic(err) // no puede fallar para 32 bytes
+		}
+		return key
+	}
+	return derive("sdshttp recovery auth"), derive("sdshttp recovery wrap")
+}
+
+// recoveryIn cifra R y keyData para el servidor (Escrow y Secret)
+func recoveryIn(r, keyData []byte) (map[string]interface{}, error) {
+	_, wrap := recoveryKeys(r)
+	escrow, err := sealData(wrap, keyData, adEscrow)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := sealData(keyData, r, adSecret)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"escrow": escrow, "secret": secret}, nil
+}
+
+// encodeRecovery da formato imprimible a una parte: base32 en grupos de 4 con una suma de control
+func encodeRecovery(p []byte) string {
+	sum := sha256.Sum256(p)
+	s := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(append(p[:len(p):len(p)], sum[:2]...))
+	var b strings.Builder
+	for i := 0; i < len(s); i += 4 {
+		if i > 0 {
+			b.WriteByte('-')
+		}
+		b.WriteString(s[i:min(i+4, len(s))])
+	}
+	return b.String()
+}
+
+// decodeRecovery deshace encodeRecovery (admite minúsculas, espacios y guiones en cualquier sitio)
+func decodeRecovery(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(s))
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	raw, err := enc.DecodeString(s)
+	if err != nil || len(raw) < 3 || enc.EncodeToString(raw) != s { // sin caracteres de más
+		return nil, ErrRecoveryKey
+	}
+	p, check := raw[:len(raw)-2], raw[len(raw)-2:]
+	if sum := sha256.Sum256(p); !bytes.Equal(sum[:2], check) {
+		return nil, ErrRecoveryKey
+	}
+	return p, nil
+}
+
+// CheckRecoveryKey comprueba la suma de control de una clave o parte de recuperación
+// (para detectar errores al escribirla antes de intentar la recuperación)
+func CheckRecoveryKey(s string) error {
+	_, err := decodeRecovery(s)
+	return err
+}
+
+// SetupRecovery configura (o reemplaza) la recuperación de la cuenta y devuelve las n partes
+// imprimibles, de las que bastarán k para recuperarla (con n = k = 1, una única clave);
+// sólo se muestran ahora y el servidor no puede reconstruirlas
+func (c *Client) SetupRecovery(ctx context.Context, n, k int) ([]string, error) {
+	if c.keyData == nil {
+		return nil, ErrNoSession
+	}
+	r := make([]byte, 32)
+	if _, err := rand.Read(r); err != nil {
+		return nil, err
+	}
+	parts, err := util.Split(r, n, k)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := recoveryIn(r, c.keyData)
+	if err != nil {
+		return nil, err
+	}
+	in["auth"], _ = recoveryKeys(r)
+	if _, err = c.do(ctx, http.MethodPut, "/v1/me/recovery", in, nil, true); err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(parts))
+	for i, p := range parts {
+		keys[i] = encodeRecovery(p)
+	}
+	return keys, nil
+}
+
+// recovery obtiene R del servidor (cifrado con keyData); nil si no hay recuperación configurada
+func (c *Client) recovery(ctx context.Context, keyData []byte) ([]byte, error) {
+	var out struct{ Secret []byte }
+	_, err := c.do(ctx, http.MethodGet, "/v1/me/recovery", nil, &out, true)
+	if errors.Is(err, ErrNoRecovery) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return openData(keyData, out.Secret, adSecret)
+}
+
+// rewrap prepara el cambio de contraseña: la clave privada, las claves de las entradas
+// y de sus adjuntos (entries debe incluirlas) y los datos de recuperación (si r no es nil) cifrados con newData
+func rewrap(oldData, newData []byte, prikey string, entries []srv.VaultEntry, r []byte) (map[string]interface{}, error) {
+	raw, err := util.Decode64(prikey)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := openData(oldData, raw, adPriKey) // sea cual sea su formato (y si es antiguo, se migra)
+	if err != nil {
+		return nil, err
+	}
+	if pk, err = sealData(newData, pk, adPriKey); err != nil {
+		return nil, err
+	}
+	oldVault, newVault := vaultKey(oldData), vaultKey(newData)
+	vault := make(map[string]interface{}, len(entries))
+	for _, v := range entries {
+		key, err := openData(oldVault, v.Key, adEntryKey)
+		if err != nil {
+			return nil, err
+		}
+		if key, err = sealData(newVault, key, adEntryKey); err != nil {
+			return nil, err
+		}
+		atts, err := rewrapAttachments(oldVault, newVault, v)
+		if err != nil {
+			return nil, err
+		}
+		vault[v.Name] = map[string]interface{}{"key": key, "version": v.Version, "attachments": atts}
+	}
+	in := map[string]interface{}{"prikey": util.Encode64(pk), "vault": vault}
+	if r != nil {
+		if in["recovery"], err = recoveryIn(r, newData); err != nil {
+			return nil, err
+		}
+	}
+	return in, nil
+}
+
+// Recover fija una contraseña nueva a partir de la clave de recuperación o de k partes;
+// se cierran todas las sesiones del usuario y hay que hacer login con la contraseña nueva
+func (c *Client) Recover(ctx context.Context, user string, keys []string, password string) error {
+	parts := make([][]byte, len(keys))
+	for i, k := range keys {
+		p, err := decodeRecovery(k)
+		if err != nil {
+			return err
+		}
+		parts[i] = p
+	}
+	r, err := util.Combine(parts)
+	if err != nil {
+		return err
+	}
+	auth, wrap := recoveryKeys(r)
+
+	var out struct {
+		Escrow []byte
+		PriKey string
+		Vault  []srv.VaultEntry
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/v1/recovery", map[string]interface{}{"user": user, "auth": auth}, &out, false)
+	if err != nil {
+		return err
+	}
+	oldData, err := openData(wrap, out.Escrow, adEscrow)
+	if err != nil {
+		return err
+	}
+	newLogin, newData := DeriveKeys(password)
+	in, err := rewrap(oldData, newData, out.PriKey, out.Vault, r)
+	if err != nil {
+		return err
+	}
+	in["new"] = newLogin
+
+	c.forget()
+	c.Token = resp.Token // token de recuperación (sólo sirve para esta petición)
+	_, err = c.do(ctx, http.MethodPut, "/v1/recovery/password", in, nil, true)
+	c.Token = nil
+	return err
+}
@@ -0,0 +1,82 @@
+/*
+Entradas compartidas: la clave de la entrada se cifra con la clave pública del destinatario
+(RSA-OAEP con SHA-256, o ECDH + HKDF + AES-GCM con las claves de curva elíptica; ver wrapKey)
+y el destinatario la descifra con su clave privada
+*/
+package client
+
+import (
+	"context"
+	"crypto"
+	"net/http"
+	"net/url"
+	"sdshttp/srv"
+)
+
+// PublicKey obtiene del servidor la clave pública registrada de un usuario y su algoritmo
+// (*rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey o *ecdh.PublicKey)
+func (c *Client) PublicKey(ctx context.Context, user string) (pub crypto.PublicKey, alg string, err error) {
+	var out struct{ PubKey, KeyAlg string }
+	if _, err = c.do(ctx, http.MethodGet, "/v1/users/"+url.PathEscape(user)+"/pubkey", nil, &out, true); err != nil {
+		return nil, "", err
+	}
+	pub, err = parsePublicKey(out.PubKey, out.KeyAlg)
+	return pub, out.KeyAlg, err
+}
+
+// Share comparte con otro usuario la versión actual de una entrada de la bóveda
+func (c *Client) Share(ctx context.Context, name, to string) (sh srv.Share, err error) {
+	if c.keyData == nil {
+		return sh, ErrNoSession
+	}
+	var v srv.VaultEntry
+	if _, err = c.do(ctx, http.MethodGet, "/v1/me/vault/"+url.PathEscape(name), nil, &v, true); err != nil {
+		return
+	}
+	pub, _, err := c.PublicKey(ctx, to)
+	if err != nil {
+		return
+	}
+	key, err := openData(vaultKey(c.keyData), v.Key, adEntryKey)
+	if err != nil {
+		return
+	}
+	wrapped, err := wrapKey(pub, key)
+	if err != nil {
+		return
+	}
+	in := map[string]interface{}{"to": to, "name": name, "key": wrapped, "version": v.Version}
+	_, err = c.do(ctx, http.MethodPost, "/v1/shares", in, &sh, true)
+	return
+}
+
+// Shares devuelve las entradas compartidas recibidas (in) y enviadas (out), sin contenido
+func (c *Client) Shares(ctx context.Context) (in, out []srv.Share, err error) {
+	var l struct{ In, Out []srv.Share }
+	_, err = c.do(ctx, http.MethodGet, "/v1/shares", nil, &l, true)
+	return l.In, l.Out, err
+}
+
+// OpenShare obtiene y descifra una entrada compartida recibida
+func (c *Client) OpenShare(ctx context.Context, id string) (e Entry, sh srv.Share, err error) {
+	if _, err = c.do(ctx, http.MethodGet, "/v1/shares/"+url.PathEscape(id), nil, &sh, true); err != nil {
+		return
+	}
+	pk, err := c.privateKey(ctx)
+	if err != nil {
+		return
+	}
+	key, err := unwrapKey(pk, sh.Key)
+	if err != nil {
+		return
+	}
+	e, err = openBlob(sh.Blob, key)
+	return
+}
+
+// Unshare revoca una entrada compartida (emisor) o la descarta (destinatario)
+// (la revocación no impide que el destinatario conserve lo que ya haya descifrado)
+func (c *Client) Unshare(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/v1/shares/"+url.PathEscape(id), nil, nil, true)
+	return err
+}
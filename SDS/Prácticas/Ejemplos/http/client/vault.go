@@ -0,0 +1,149 @@
+/*
+Bóveda: entradas cifradas en el cliente
+
+cada entrada se cifra con una clave aleatoria propia, que a su vez se cifra con la clave
+de bóveda (derivada de keyData con HKDF); el servidor nunca ve ninguna de las dos
+*/
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"sdshttp/srv"
+	"sdshttp/util"
+	"strconv"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Entry es el contenido en claro de una entrada de la bóveda
+type Entry struct {
+	Site     string `json:"site,omitempty"`
+	Username string `json:"username,omitempty"`
+	Secret   string `json:"secret,omitempty"`
+	Notes    string `json:"notes,omitempty"`
+}
+
+// vaultKey deriva la clave de bóveda de la clave de datos
+func vaultKey(keyData []byte) []byte {
+	key := make([]byte, 32)
+	_, err := io.ReadFull(hkdf.New(sha256.New, keyData, nil, []byte("sdshttp vault v1")), key)
+	if err != nil {
+		panic(err) // no puede fallar para 32 bytes
+	}
+	return key
+}
+
+// sealEntry cifra una entrada con una clave nueva y devuelve el contenido y la clave cifrados
+func sealEntry(e Entry, keyData []byte, codec util.Codec) (blob, wrapped []byte, err error) {
+	plain, err := json.Marshal(e)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := make([]byte, 32)
+	if _, err = rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	if blob, err = sealBlob(plain, key, codec); err != nil {
+		return nil, nil, err
+	}
+	if wrapped, err = sealData(vaultKey(keyData), key, adEntryKey); err != nil {
+		return nil, nil, err
+	}
+	return blob, wrapped, nil
+}
+
+// openEntry descifra una entrada con la clave de datos
+func openEntry(v srv.VaultEntry, keyData []byte) (e Entry, err error) {
+	key, err := openData(vaultKey(keyData), v.Key, adEntryKey)
+	if err != nil {
+		return
+	}
+	return openBlob(v.Blob, key)
+}
+
+// sealBlob comprime con codec y cifra el contenido de una entrada con su clave
+func sealBlob(plain, key []byte, codec util.Codec) ([]byte, error) {
+	z, err := util.CompressWith(codec, plain)
+	if err != nil {
+		return nil, err
+	}
+	return sealData(key, z, adEntry)
+}
+
+// openBlob descifra, descomprime y decodifica el contenido de una entrada (inverso de sealBlob)
+func openBlob(blob, key []byte) (e Entry, err error) {
+	z, err := openData(key, blob, adEntry)
+	if err != nil {
+		return
+	}
+	plain, err := util.Decompress(z)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(plain, &e)
+	return
+}
+
+// VaultList devuelve las entradas de la bóveda (sólo nombre y metadatos)
+func (c *Client) VaultList(ctx context.Context) (l []srv.VaultEntry, err error) {
+	_, err = c.do(ctx, http.MethodGet, "/v1/me/vault", nil, &l, true)
+	return
+}
+
+// vaultKeys obtiene todas las entradas de la bóveda con su clave cifrada (la lista no las incluye)
+func (c *Client) vaultKeys(ctx context.Context) ([]srv.VaultEntry, error) {
+	list, err := c.VaultList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range list {
+		if _, err = c.do(ctx, http.MethodGet, "/v1/me/vault/"+url.PathEscape(e.Name), nil, &list[i], true); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// VaultGet obtiene y descifra una entrada de la bóveda (con sus metadatos, p.ej. la versión)
+func (c *Client) VaultGet(ctx context.Context, name string) (Entry, srv.VaultEntry, error) {
+	if c.keyData == nil {
+		return Entry{}, srv.VaultEntry{}, ErrNoSession
+	}
+	var v srv.VaultEntry
+	if _, err := c.do(ctx, http.MethodGet, "/v1/me/vault/"+url.PathEscape(name), nil, &v, true); err != nil {
+		return Entry{}, v, err
+	}
+	e, err := openEntry(v, c.keyData)
+	return e, v, err
+}
+
+// VaultPut cifra y guarda una entrada: version 0 para crear una nueva, o la versión leída
+// para modificarla (ErrVersionConflict si otro dispositivo la ha modificado antes)
+func (c *Client) VaultPut(ctx context.Context, name string, e Entry, version uint64) (v srv.VaultEntry, err error) {
+	if c.keyData == nil {
+		return v, ErrNoSession
+	}
+	blob, key, err := sealEntry(e, c.keyData, c.codec)
+	if err != nil {
+		return v, err
+	}
+	in := map[string]interface{}{"blob": blob, "key": key, "version": version}
+	_, err = c.do(ctx, http.MethodPut, "/v1/me/vault/"+url.PathEscape(name), in, &v, true)
+	return
+}
+
+// VaultDelete borra una entrada (si version no es 0, sólo si no ha cambiado)
+func (c *Client) VaultDelete(ctx context.Context, name string, version uint64) error {
+	path := "/v1/me/vault/" + url.PathEscape(name)
+	if version != 0 {
+		path += "?version=" + strconv.FormatUint(version, 10)
+	}
+	_, err := c.do(ctx, http.MethodDelete, path, nil, nil, true)
+	return err
+}
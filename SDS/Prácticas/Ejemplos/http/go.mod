@@ -1,5 +1,13 @@
 module sdshttp
 
-go 1.17
+go 1.22
 
-require golang.org/x/crypto v0.0.0-20220312131142-6068a2e6cfdc
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/klauspost/compress v1.18.0
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/crypto v0.0.0-20220312131142-6068a2e6cfdc
+	golang.org/x/term v0.18.0
+)
+
+require golang.org/x/sys v0.18.0 // indirect
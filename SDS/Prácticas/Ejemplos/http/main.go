@@ -6,23 +6,55 @@ Este programa demuestra una arquitectura cliente servidor sencilla utilizando HT
 
 Puede servir como inspiración, pero carece mucha de la funcionalidad necesaria para la práctica.
 Entre otras muchas, algunas limitaciones (por sencillez):
-- Se utiliza scrypt para gestionar las contraseñas en el servidor. Argon2 es mejor opción.
-- Se utiliza un token sencillo a modo de sesión/autentificación, se puede extender o hacer también con cookies (sobre HTTPS), con JWT, con firma digital, etc.
-- El cliente ni es interactivo ni muy útil, es una mera demostración.
+- Las contraseñas se guardan en el servidor con Argon2id (los hashes scrypt antiguos se migran en el siguiente login).
+- La sesión es un token firmado (Ed25519, al estilo de JWT/PASETO) que el servidor valida sin estado; las claves de firma sólo viven en memoria, por lo que reiniciar el servidor cierra todas las sesiones.
+- El cliente es un intérprete de comandos sencillo (escribir help para ver los comandos).
 
 compilación:
 go build
 
-arrancar el servidor:
-sdshttp srv
+arrancar el servidor (se para con Ctrl+C o SIGTERM, dejando terminar las peticiones en curso):
+sdshttp srv [-config sdshttp.json] [-addr :10443] [-cert localhost.crt] [-key localhost.key] ...
 
-arrancar el cliente:
+la configuración del servidor (dirección, certificado, tiempos máximos, tamaños máximos de
+cabeceras y cuerpos, duración de las sesiones, almacén, clave maestra y registro de auditoría)
+se lee del fichero JSON sdshttp.json si existe, de variables de entorno SDSHTTP_* y de opciones
+(sdshttp srv -h para verlas todas; ver srv/config.go), p.ej.:
+
+	{"addr": ":8443", "session_ttl": "30m", "store": "bolt",
+	 "master_key_file": "/etc/sdshttp/users.key", "audit_path": "/var/log/sdshttp/audit.log"}
+
+el servidor ofrece una API REST en /v1 (ver srv/api.go) con el token en la cabecera
+Authorization: Bearer, y mantiene por compatibilidad el endpoint POST / con el campo cmd
+
+las métricas (formato de Prometheus) se publican en /metrics sólo en un puerto de administración
+sin TLS, con "metrics_addr": "127.0.0.1:9100" (ver srv/metrics.go); sin él no se publican
+
+el almacén de usuarios se elige con la configuración o con variables de entorno:
+SDSHTTP_STORE=file|bolt|memory (file por defecto), SDSHTTP_STORE_PATH=ruta
+SDSHTTP_MASTER_KEY=clave en base64 (si no, se lee o se genera y guarda en el fichero
+"master_key_file", SDSHTTP_MASTER_KEY_FILE=ruta; users.key por defecto)
+
+arrancar el cliente (intérprete interactivo):
 sdshttp cli
 
+ejecutar la demostración no interactiva de la API:
+sdshttp demo
+
+crear el primer administrador (con el servidor parado; si el usuario ya existe, lo promueve):
+sdshttp admin-init <usuario>
+
+el servidor anota los eventos de seguridad en un registro de auditoría encadenado y firmado
+(audit.log, con su cabecera audit.log.head y, en el mismo directorio, la clave audit.key y la
+pública audit.pub; "audit_path" o SDSHTTP_AUDIT=ruta para cambiarlo u off para desactivarlo);
+para verificarlo y consultarlo:
+sdshttp audit verify [-log audit.log] [-pub audit.pub]
+sdshttp audit query [-user u] [-event login_failed] [-since 2024-05-01] [-until 2024-06-01] [-json]
+
 pd. Comando openssl para generar el par certificado/clave para localhost:
 (ver https://letsencrypt.org/docs/certificates-for-localhost/)
 
-	openssl req -x509 -out localhost.crt -keyout localhost.key \
+	openssl req -x509 -out localhost.crt -keyout localhost.key -days 3650 \
 	  -newkey rsa:2048 -nodes -sha256 \
 	  -subj '/CN=localhost' -extensions EXT -config <( \
 	   printf "[dn]\nCN=localhost\n[req]\ndistinguished_name = dn\n[EXT]\nsubjectAltName=DNS:localhost\nkeyUsage=digitalSignature\nextendedKeyUsage=serverAuth")
@@ -32,12 +64,18 @@ package main
 import (
 	"fmt"
 	"os"
+	"sdshttp/audit"
 	"sdshttp/cli"
 	"sdshttp/srv"
 )
 
 func main() {
 
+	if len(os.Args) > 1 && os.Args[1] == "audit" { // herramienta: sin el mensaje de bienvenida (la salida se puede procesar)
+		audit.Run(os.Args[2:])
+		return
+	}
+
 	fmt.Println("sdshttp :: un ejemplo de login mediante TLS/HTTP en Go.")
 	s := "Introduce srv para funcionalidad de servidor y cli para funcionalidad de cliente"
 
@@ -45,10 +83,15 @@ func main() {
 		switch os.Args[1] {
 		case "srv":
 			fmt.Println("Entrando en modo servidor...")
-			srv.Run()
+			srv.Run(os.Args[2:])
 		case "cli":
 			fmt.Println("Entrando en modo cliente...")
 			cli.Run()
+		case "demo":
+			fmt.Println("Entrando en modo demostración...")
+			cli.Demo()
+		case "admin-init":
+			cli.Bootstrap(os.Args[2:])
 		default:
 			fmt.Println("Parámetro '", os.Args[1], "' desconocido. ", s)
 		}
@@ -0,0 +1,365 @@
+/*
+Métricas en el formato de texto de Prometheus
+
+un Registry agrupa métricas (contadores, indicadores e histogramas, con etiquetas opcionales) y
+las escribe en el formato que lee Prometheus (ver Registry.Handler); los programas registran las
+suyas en Default al arrancar y las publican en /metrics, en su propio puerto o en uno aparte:
+
+	var peticiones = metrics.Default.Counter("app_requests_total", "Peticiones atendidas", "code")
+	...
+	peticiones.Inc("200")
+
+las etiquetas se dan en el orden de su declaración; sus valores no deben depender de datos del
+usuario sin acotar (cada combinación distinta es una serie nueva que se guarda para siempre)
+*/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets son los límites por defecto de los histogramas de duración (en segundos)
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default es el registro de las métricas del programa
+var Default = NewRegistry()
+
+// ContentType es el tipo MIME del formato de texto de Prometheus
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metric es una métrica registrada (cualquier tipo)
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry es un conjunto de métricas con nombres distintos (seguro para uso concurrente)
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// NewRegistry crea un registro vacío
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// register añade una métrica (un nombre repetido es un error de programación)
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[m.name()]; ok {
+		panic("metrics: métrica registrada dos veces: " + m.name())
+	}
+	r.metrics[m.name()] = m
+}
+
+// WriteTo escribe todas las métricas, ordenadas por nombre, en el formato de texto
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	l := make([]metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		l = append(l, m)
+	}
+	r.mu.Unlock()
+	sort.Slice(l, func(i, j int) bool { return l[i].name() < l[j].name() })
+
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range l {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// countWriter cuenta los bytes escritos (para WriteTo)
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Handler devuelve el handler HTTP que publica las métricas (para /metrics)
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.WriteTo(w)
+	})
+}
+
+// Server devuelve un servidor HTTP (sin TLS) que sólo publica las métricas en /metrics, para
+// escuchar en un puerto de administración aparte (p.ej. 127.0.0.1:9100); quien lo llama
+// lo arranca con ListenAndServe y lo para con Shutdown
+func (r *Registry) Server(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.Handler())
+	return &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout: 30 * time.Second, IdleTimeout: time.Minute}
+}
+
+// desc es lo común a todas las métricas: nombre, ayuda, tipo y etiquetas
+type desc struct {
+	n, help, typ string
+	labels       []string
+}
+
+func (d *desc) name() string { return d.n }
+
+// header escribe las líneas HELP y TYPE
+func (d *desc) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.n, escapeHelp(d.help), d.n, d.typ)
+}
+
+// key une los valores de las etiquetas (comprobando que son tantos como las etiquetas)
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s espera %d etiquetas y recibe %d", d.n, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs formatea las etiquetas de una serie ({a="x",b="y"}), con extra al final (p.ej. le)
+func (d *desc) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	if len(d.labels) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, d.labels[i]+`="`+escapeLabel(v)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// sortedKeys devuelve las claves de las series ordenadas (la salida es estable)
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter es un contador que sólo aumenta (p.ej. peticiones atendidas)
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Counter registra un contador con las etiquetas indicadas
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name, help, "counter", labels}, values: make(map[string]float64)}
+	if len(labels) == 0 {
+		c.values[""] = 0 // sin etiquetas se publica desde el principio
+	}
+	r.register(c)
+	return c
+}
+
+// Inc suma 1 a la serie de los valores de etiquetas dados
+func (c *Counter) Inc(labels ...string) { c.Add(1, labels...) }
+
+// Add suma v (no negativo) a la serie de los valores de etiquetas dados
+func (c *Counter) Add(v float64, labels ...string) {
+	if v < 0 {
+		panic("metrics: un contador no puede disminuir: " + c.n)
+	}
+	k := c.key(labels)
+	c.mu.Lock()
+	c.values[k] += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.header(w)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.n, c.labelPairs(k), formatFloat(c.values[k]))
+	}
+}
+
+// Gauge es un indicador que sube y baja (p.ej. conexiones abiertas)
+type Gauge struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Gauge registra un indicador con las etiquetas indicadas
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{desc: desc{name, help, "gauge", labels}, values: make(map[string]float64)}
+	if len(labels) == 0 {
+		g.values[""] = 0
+	}
+	r.register(g)
+	return g
+}
+
+// Set fija el valor de la serie de los valores de etiquetas dados
+func (g *Gauge) Set(v float64, labels ...string) {
+	k := g.key(labels)
+	g.mu.Lock()
+	g.values[k] = v
+	g.mu.Unlock()
+}
+
+// Add suma v (que puede ser negativo) a la serie de los valores de etiquetas dados
+func (g *Gauge) Add(v float64, labels ...string) {
+	k := g.key(labels)
+	g.mu.Lock()
+	g.values[k] += v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.header(w)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, k := range sortedKeys(g.values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.n, g.labelPairs(k), formatFloat(g.values[k]))
+	}
+}
+
+// gaugeFunc es un indicador cuyo valor se calcula al publicarlo
+type gaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+// GaugeFunc registra un indicador sin etiquetas cuyo valor devuelve fn en cada lectura
+// de las métricas (fn se llama desde el handler: debe ser rápida y segura para uso concurrente)
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(&gaugeFunc{desc{name, help, "gauge", nil}, fn})
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%s %s\n", g.n, formatFloat(g.fn()))
+}
+
+// Histogram cuenta observaciones (p.ej. duraciones) en intervalos acumulados
+type Histogram struct {
+	desc
+	buckets []float64 // límites superiores, ordenados (+Inf va aparte)
+	mu      sync.Mutex
+	series  map[string]*histSeries
+}
+
+// histSeries son los contadores de una serie de un histograma
+type histSeries struct {
+	counts []uint64 // por intervalo (no acumulados; el último es +Inf)
+	sum    float64
+	count  uint64
+}
+
+// Histogram registra un histograma con los límites dados (DefaultBuckets si es nil)
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	h := &Histogram{desc: desc{name, help, "histogram", labels}, buckets: b, series: make(map[string]*histSeries)}
+	r.register(h)
+	return h
+}
+
+// Observe anota v en la serie de los valores de etiquetas dados
+func (h *Histogram) Observe(v float64, labels ...string) {
+	k := h.key(labels)
+	i := sort.SearchFloat64s(h.buckets, v) // primer límite >= v
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[k]
+	if !ok {
+		s = &histSeries{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[k] = s
+	}
+	s.counts[i]++
+	s.sum += v
+	s.count++
+}
+
+// ObserveSince anota los segundos transcurridos desde t (para medir duraciones)
+func (h *Histogram) ObserveSince(t time.Time, labels ...string) {
+	h.Observe(time.Since(t).Seconds(), labels...)
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.header(w)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, k := range sortedKeys(h.series) {
+		s := h.series[k]
+		var acc uint64
+		for i, le := range h.buckets {
+			acc += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.n, h.labelPairs(k, "le", formatFloat(le)), acc)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.n, h.labelPairs(k, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.n, h.labelPairs(k), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.n, h.labelPairs(k), s.count)
+	}
+}
+
+// RegisterRuntime registra indicadores del proceso de Go (gorutinas y memoria)
+func RegisterRuntime(r *Registry) {
+	r.GaugeFunc("go_goroutines", "Gorutinas en ejecución", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	r.GaugeFunc("go_memstats_alloc_bytes", "Memoria del montículo en uso (bytes)", func() float64 {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return float64(m.Alloc)
+	})
+	r.GaugeFunc("go_memstats_sys_bytes", "Memoria obtenida del sistema (bytes)", func() float64 {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return float64(m.Sys)
+	})
+}
+
+// formatFloat escribe un valor como lo espera Prometheus
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapes del formato de texto (la ayuda no escapa las comillas, las etiquetas sí)
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
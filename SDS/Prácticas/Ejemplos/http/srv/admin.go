@@ -0,0 +1,252 @@
+/*
+Roles, control de acceso centralizado y operaciones de administración
+
+cada ruta de la API y cada comando del endpoint antiguo declara el acceso que exige
+(ver routes y legacyAccess) y se comprueba en un único sitio, guard, antes de llamar al handler;
+una ruta o un comando sin acceso declarado hace fallar el arranque del servidor
+*/
+package srv
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// roles de los usuarios (User.Role; vacío equivale a RoleUser)
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// errores del control de acceso y de la administración
+var (
+	ErrForbidden       = &APIError{http.StatusForbidden, "forbidden", "No tiene permiso para esta operación"}
+	ErrAccountDisabled = &APIError{http.StatusForbidden, "account_disabled", "Cuenta deshabilitada"}
+	ErrSelfAdmin       = &APIError{http.StatusConflict, "self_admin", "Un administrador no puede deshabilitarse ni borrarse a sí mismo"}
+	ErrAdminExists     = errors.New("ya hay un administrador: el primero sólo se puede crear con el almacén sin administradores")
+)
+
+// access es el acceso que exige una operación (el valor cero no es válido: obliga a declararlo)
+type access int
+
+const (
+	accessPublic  access = iota + 1 // sin sesión (registro, login, inicio de la recuperación)
+	accessRecover                   // token de recuperación (contraseña nueva tras recuperar la cuenta)
+	accessUser                      // sesión de cualquier usuario habilitado
+	accessAdmin                     // sesión de un administrador
+)
+
+// guard comprueba el acceso a una operación con el token de la petición y devuelve
+// el usuario y los claims del token (vacíos en las operaciones públicas)
+func (s *server) guard(a access, token []byte, ip, resource string) (User, Claims, error) {
+	switch a {
+	case accessPublic:
+		return User{}, Claims{}, nil
+	case accessRecover:
+		c, err := s.authorizeRecover(token)
+		if err != nil {
+			s.record("token_rejected", c.Sub, ip, c.Sid, err, resource)
+		}
+		return User{}, c, err
+	case accessUser, accessAdmin:
+		u, c, err := s.authorize(token, ip, resource)
+		if err == nil && a == accessAdmin && u.Role != RoleAdmin {
+			err = ErrForbidden
+			s.record("forbidden", c.Sub, ip, c.Sid, err, resource)
+		}
+		return u, c, err
+	}
+	panic(fmt.Sprintf("acceso no declarado para %s", resource))
+}
+
+// checkAccess comprueba al arrancar que una operación tiene el acceso declarado
+func checkAccess(a access, resource string) {
+	if a < accessPublic || a > accessAdmin {
+		panic(fmt.Sprintf("acceso no declarado para %s", resource))
+	}
+}
+
+// UserInfo es el resumen de un usuario para los administradores (sin datos ni claves)
+type UserInfo struct {
+	Name        string
+	Role        string
+	Disabled    bool
+	Seen        time.Time // último login
+	Sessions    int       // sesiones abiertas
+	Failures    int       // fallos de login consecutivos
+	LockedUntil time.Time `json:",omitempty"`
+	TOTP        bool      // segundo factor activado
+	Recovery    bool      // recuperación configurada
+	Entries     int       // entradas de la bóveda
+}
+
+// role devuelve el rol del usuario (RoleUser si no tiene)
+func (u User) role() string {
+	if u.Role == "" {
+		return RoleUser
+	}
+	return u.Role
+}
+
+// listUsers devuelve el resumen de todos los usuarios, por nombre
+func (s *server) listUsers() ([]UserInfo, error) {
+	users, err := s.users.List()
+	if err != nil {
+		return nil, err
+	}
+	l := make([]UserInfo, 0, len(users))
+	for _, u := range users {
+		l = append(l, UserInfo{Name: u.Name, Role: u.role(), Disabled: u.Disabled, Seen: u.Seen,
+			Sessions: len(u.Sessions), Failures: u.Failures, LockedUntil: u.LockedUntil,
+			TOTP: u.TOTP != nil && u.TOTP.Enabled, Recovery: u.Recovery != nil, Entries: len(u.Vault)})
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Name < l[j].Name })
+	return l, nil
+}
+
+// setDisabled deshabilita (cerrando todas sus sesiones) o habilita la cuenta de un usuario
+func (s *server) setDisabled(c Claims, name string, disabled bool) (err error) {
+	event := "admin_enable"
+	if disabled {
+		event = "admin_disable"
+	}
+	defer func() { s.record(event, c.Sub, "", c.Sid, err, name) }()
+	if name == c.Sub {
+		return ErrSelfAdmin
+	}
+
+	var closed map[string]Session
+	_, err = s.modify(name, func(u *User) error {
+		closed = nil
+		u.Disabled = disabled
+		if disabled {
+			closed, u.Sessions = u.Sessions, nil
+		}
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) {
+		return ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+	s.revoke(closed)
+	return nil
+}
+
+// forceLogout cierra todas las sesiones de un usuario y devuelve cuántas eran
+func (s *server) forceLogout(c Claims, name string) (n int, err error) {
+	defer func() { s.record("admin_logout", c.Sub, "", c.Sid, err, name) }()
+	var closed map[string]Session
+	_, err = s.modify(name, func(u *User) error {
+		closed, u.Sessions = u.Sessions, nil
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) {
+		return 0, ErrUserNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	s.revoke(closed)
+	return len(closed), nil
+}
+
+// deleteUser borra la cuenta de un usuario con todos sus datos, cierra sus sesiones
+// y retira las entradas que había compartido o le habían compartido
+func (s *server) deleteUser(c Claims, name string) (err error) {
+	defer func() { s.record("admin_delete", c.Sub, "", c.Sid, err, name) }()
+	if name == c.Sub {
+		return ErrSelfAdmin
+	}
+
+	unlock := s.locks.lock(name)
+	u, err := s.users.Get(name)
+	if err == nil {
+		err = s.users.Delete(name)
+	}
+	unlock()
+	if errors.Is(err, ErrNotFound) {
+		return ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+	s.revoke(u.Sessions)
+	s.deleteBlobs(attachmentsOf(u.Vault)...)
+
+	// las entradas compartidas están también en el otro usuario (no se pueden dejar huérfanas)
+	others := make(map[string][]string)
+	for id, sh := range u.SharesOut {
+		others[sh.To] = append(others[sh.To], id)
+	}
+	for id, sh := range u.SharesIn {
+		others[sh.From] = append(others[sh.From], id)
+	}
+	for other, ids := range others {
+		_, err = s.modify(other, func(o *User) error {
+			for _, id := range ids {
+				delete(o.SharesIn, id)
+				delete(o.SharesOut, id)
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// revoke invalida los tokens de unas sesiones cerradas
+func (s *server) revoke(closed map[string]Session) {
+	for _, sess := range closed {
+		s.tokens.Revoke(sess.ID, sess.Expires)
+	}
+}
+
+// Bootstrap crea el primer administrador en el almacén configurado (con el servidor parado):
+// si el usuario ya existe lo convierte en administrador; si no, lo crea con los datos de
+// registro que genera newAccount (keyLogin y el par de claves con su algoritmo, como en el registro del cliente)
+func Bootstrap(name string, newAccount func() (pass []byte, pub, pri, alg string, err error)) (created bool, err error) {
+	cfg, err := LoadConfig(nil) // el almacén del fichero de configuración o el entorno
+	if err != nil {
+		return false, err
+	}
+	users, err := openStore(cfg)
+	if err != nil {
+		return false, err
+	}
+	defer users.Close()
+
+	all, err := users.List()
+	if err != nil {
+		return false, err
+	}
+	for _, u := range all {
+		if u.Role == RoleAdmin {
+			return false, ErrAdminExists
+		}
+	}
+
+	u, err := users.Get(name)
+	if err == nil { // usuario existente: lo promovemos
+		u.Role, u.Disabled = RoleAdmin, false
+		return false, users.Update(u)
+	} else if !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+
+	pass, pub, pri, alg, err := newAccount()
+	if err != nil {
+		return false, err
+	}
+	if err = checkPublicKey(alg, pub); err != nil {
+		return false, err
+	}
+	u = User{Name: name, Role: RoleAdmin, Data: map[string]string{"public": pub, "private": pri, "keyalg": alg}}
+	if u.PassHash, err = hashPassword(pass); err != nil {
+		return false, err
+	}
+	return true, users.Put(u)
+}
@@ -0,0 +1,207 @@
+package srv
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sdshttp/util"
+	"strings"
+	"testing"
+)
+
+// adminServer crea un servidor con dos usuarios, ana (administradora) y eva, y devuelve sus tokens
+func adminServer(t *testing.T) (s *server, admin, user []byte) {
+	t.Helper()
+	store, _ := openTestStore(t, "memory", "")
+	s = newTestServer(t, store)
+	admin, err := s.register(registerIn{User: "ana", Pass: []byte("clave"), PubKey: "pub", PriKey: "pri"}, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, err = s.register(registerIn{User: "eva", Pass: []byte("clave"), PubKey: "pub", PriKey: "pri"}, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.modify("ana", func(u *User) error {
+		u.Role = RoleAdmin
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, admin, user
+}
+
+// apiCall hace una petición a la API REST con el token indicado y devuelve el estado y la respuesta
+func apiCall(t *testing.T, mux http.Handler, method, path string, token []byte) (int, Resp) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = "10.0.0.2:1234"
+	if token != nil {
+		req.Header.Set("Authorization", "Bearer "+string(token))
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	var r Resp
+	if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return rec.Code, r
+}
+
+// legacyCall envía un comando al endpoint antiguo con el token indicado
+func legacyCall(t *testing.T, mux http.Handler, form url.Values, token []byte) Resp {
+	t.Helper()
+	form.Set("token", util.Encode64(token))
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = "10.0.0.2:1234"
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	var r Resp
+	if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+		t.Fatalf("cmd %s: %v", form.Get("cmd"), err)
+	}
+	return r
+}
+
+// TestAdminForbidden comprueba que un usuario normal recibe ErrForbidden en todas las
+// operaciones de administración, por la API REST y por el endpoint antiguo
+func TestAdminForbidden(t *testing.T) {
+	s, _, user := adminServer(t)
+	mux := s.routes()
+
+	n := 0
+	for _, r := range s.apiRoutes() {
+		if r.access != accessAdmin {
+			continue
+		}
+		n++
+		method, path, _ := strings.Cut(r.pattern, " ")
+		path = strings.Replace(path, "{name}", "ana", 1)
+		if code, resp := apiCall(t, mux, method, path, user); code != http.StatusForbidden || resp.Code != ErrForbidden.Code {
+			t.Errorf("%s: %d %s, se esperaba %s", r.pattern, code, resp.Code, ErrForbidden.Code)
+		}
+	}
+	if n == 0 {
+		t.Fatal("no hay rutas de administración")
+	}
+
+	n = 0
+	for cmd, a := range legacyAccess {
+		if a != accessAdmin {
+			continue
+		}
+		n++
+		if resp := legacyCall(t, mux, url.Values{"cmd": {cmd}, "name": {"ana"}}, user); resp.Ok || resp.Code != ErrForbidden.Code {
+			t.Errorf("cmd %s: %+v, se esperaba %s", cmd, resp, ErrForbidden.Code)
+		}
+	}
+	if n == 0 {
+		t.Fatal("no hay comandos de administración")
+	}
+
+	// nada ha cambiado
+	u, err := s.users.Get("ana")
+	if err != nil || u.Disabled || len(u.Sessions) != 1 {
+		t.Fatalf("ana tras los intentos: %+v, %v", u, err)
+	}
+}
+
+// TestAdminDisable comprueba que deshabilitar una cuenta cierra sus sesiones e impide el login
+// y completar una recuperación ya iniciada, y que al habilitarla se puede volver a entrar
+func TestAdminDisable(t *testing.T) {
+	s, admin, user := adminServer(t)
+	mux := s.routes()
+	ac, err := ReadClaims(admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// eva inicia una recuperación antes de que se deshabilite la cuenta
+	verifier, err := hashPassword([]byte("clave de recuperación"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.modify("eva", func(u *User) error {
+		u.Recovery = &Recovery{Verifier: verifier, Escrow: []byte("e"), Secret: []byte("s")}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	recToken, _, err := s.startRecovery(recoverIn{User: "eva", Auth: []byte("clave de recuperación")}, "10.0.0.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := s.authorizeRecover(recToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code, resp := apiCall(t, mux, http.MethodPost, "/v1/admin/users/eva/disable", admin); code != http.StatusOK {
+		t.Fatalf("deshabilitar: %d %s", code, resp.Code)
+	}
+	if code, resp := apiCall(t, mux, http.MethodGet, "/v1/me/data", user); code != http.StatusUnauthorized {
+		t.Fatalf("token de eva tras deshabilitar: %d %s, se esperaba 401", code, resp.Code)
+	}
+	if u, _ := s.users.Get("eva"); len(u.Sessions) != 0 {
+		t.Fatalf("sesiones de eva tras deshabilitar: %d", len(u.Sessions))
+	}
+	if _, err = s.login(loginIn{User: "eva", Pass: []byte("clave")}, "10.0.0.3"); !errors.Is(err, ErrAccountDisabled) {
+		t.Fatalf("login tras deshabilitar = %v, se esperaba ErrAccountDisabled", err)
+	}
+	before, err := s.users.Get("eva")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reset := passwordIn{New: []byte("nueva"), PriKey: "pri", Recovery: &recoveryIn{Escrow: []byte("e2"), Secret: []byte("s2")}}
+	if _, err = s.resetPassword(rc, reset); !errors.Is(err, ErrAccountDisabled) {
+		t.Fatalf("recuperación tras deshabilitar = %v, se esperaba ErrAccountDisabled", err)
+	}
+	if u, _ := s.users.Get("eva"); u.PassHash != before.PassHash || u.Recovery.Used != "" {
+		t.Fatal("la recuperación ha cambiado la contraseña de una cuenta deshabilitada")
+	}
+
+	if err = s.setDisabled(ac, "eva", false); err != nil {
+		t.Fatal(err)
+	}
+	token, err := s.login(loginIn{User: "eva", Pass: []byte("clave")}, "10.0.0.3")
+	if err != nil {
+		t.Fatalf("login tras habilitar: %v", err)
+	}
+	if code, resp := apiCall(t, mux, http.MethodGet, "/v1/me/data", token); code != http.StatusOK {
+		t.Fatalf("token nuevo de eva: %d %s", code, resp.Code)
+	}
+	if _, err = s.resetPassword(rc, reset); err != nil {
+		t.Fatalf("recuperación tras habilitar: %v", err)
+	}
+}
+
+// TestAdminSelf comprueba que un administrador no puede deshabilitar ni borrar su propia cuenta
+func TestAdminSelf(t *testing.T) {
+	s, admin, _ := adminServer(t)
+	mux := s.routes()
+
+	for _, op := range []struct{ method, path string }{
+		{http.MethodPost, "/v1/admin/users/ana/disable"},
+		{http.MethodDelete, "/v1/admin/users/ana"},
+	} {
+		if code, resp := apiCall(t, mux, op.method, op.path, admin); code != http.StatusConflict || resp.Code != ErrSelfAdmin.Code {
+			t.Errorf("%s %s: %d %s, se esperaba %s", op.method, op.path, code, resp.Code, ErrSelfAdmin.Code)
+		}
+	}
+	for _, cmd := range []string{"admin-disable", "admin-delete"} {
+		if resp := legacyCall(t, mux, url.Values{"cmd": {cmd}, "name": {"ana"}}, admin); resp.Ok || resp.Code != ErrSelfAdmin.Code {
+			t.Errorf("cmd %s: %+v, se esperaba %s", cmd, resp, ErrSelfAdmin.Code)
+		}
+	}
+
+	u, err := s.users.Get("ana")
+	if err != nil || u.Disabled || len(u.Sessions) != 1 {
+		t.Fatalf("ana tras los intentos: %+v, %v", u, err)
+	}
+	if code, resp := apiCall(t, mux, http.MethodGet, "/v1/admin/users", admin); code != http.StatusOK {
+		t.Fatalf("listado tras los intentos: %d %s", code, resp.Code)
+	}
+}
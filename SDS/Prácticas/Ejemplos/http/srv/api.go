@@ -0,0 +1,503 @@
+/*
+API REST (v1): rutas por recurso, cuerpos JSON, códigos de estado HTTP
+y token de sesión en la cabecera Authorization: Bearer <token>
+*/
+package srv
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// authedFunc es un handler que recibe ya comprobado el acceso: el usuario y los claims del token
+// (vacíos en las rutas públicas; en las de recuperación, sólo los claims)
+type authedFunc func(w http.ResponseWriter, req *http.Request, u User, c Claims)
+
+// route es una ruta de la API con el acceso que exige
+type route struct {
+	pattern string
+	access  access
+	h       authedFunc
+}
+
+// routes devuelve el enrutador con la API REST y el endpoint antiguo
+func (s *server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, r := range s.apiRoutes() {
+		checkAccess(r.access, r.pattern)
+		mux.HandleFunc(r.pattern, s.instrument(r.pattern, s.recoverer(s.guarded(r))))
+	}
+	mux.HandleFunc("/{$}", s.instrument("", s.recoverer(s.handler))) // endpoint antiguo (POST / con cmd; el acceso se comprueba en legacyAccess)
+	return mux
+}
+
+// apiRoutes devuelve las rutas de la API REST con el acceso que exige cada una
+func (s *server) apiRoutes() []route {
+	return []route{
+		{"POST /v1/users", accessPublic, s.apiRegister},                              // registro
+		{"POST /v1/sessions", accessPublic, s.apiLogin},                              // login
+		{"GET /v1/sessions", accessUser, s.apiSessions},                              // sesiones abiertas
+		{"DELETE /v1/sessions", accessUser, s.apiLogoutAll},                          // cerrar todas
+		{"DELETE /v1/sessions/{id}", accessUser, s.apiLogout},                        // cerrar una ("current" para la actual)
+		{"GET /v1/me/data", accessUser, s.apiGetData},                                // datos del usuario
+		{"PUT /v1/me/data", accessUser, s.apiPutData},                                // añadir o modificar datos
+		{"PUT /v1/me/password", accessUser, s.apiPassword},                           // cambio de contraseña
+		{"GET /v1/me/vault", accessUser, s.apiVaultList},                             // entradas de la bóveda
+		{"GET /v1/me/vault/{name}", accessUser, s.apiVaultGet},                       // una entrada
+		{"PUT /v1/me/vault/{name}", accessUser, s.apiVaultPut},                       // crear o modificar
+		{"DELETE /v1/me/vault/{name}", accessUser, s.apiVaultDel},                    // borrar (?version=N opcional)
+		{"PUT /v1/me/vault/{name}/attachments/{att}", accessUser, s.apiAttachPut},    // subir un adjunto (cuerpo binario)
+		{"GET /v1/me/vault/{name}/attachments/{att}", accessUser, s.apiAttachGet},    // descargar un adjunto
+		{"DELETE /v1/me/vault/{name}/attachments/{att}", accessUser, s.apiAttachDel}, // borrar un adjunto
+		{"GET /v1/users/{name}/pubkey", accessUser, s.apiPubKey},                     // clave pública de un usuario
+		{"POST /v1/shares", accessUser, s.apiShare},                                  // compartir una entrada
+		{"GET /v1/shares", accessUser, s.apiShares},                                  // compartidas recibidas y enviadas
+		{"GET /v1/shares/{id}", accessUser, s.apiShareGet},                           // una compartida recibida
+		{"DELETE /v1/shares/{id}", accessUser, s.apiUnshare},                         // revocar o descartar
+		{"GET /v1/me/recovery", accessUser, s.apiRecoveryGet},                        // datos de recuperación (cifrados)
+		{"PUT /v1/me/recovery", accessUser, s.apiRecoverySetup},                      // configurar la recuperación
+		{"POST /v1/recovery", accessPublic, s.apiRecover},                            // iniciar una recuperación
+		{"PUT /v1/recovery/password", accessRecover, s.apiRecoverPassword},           // contraseña nueva (token de recuperación)
+		{"POST /v1/me/totp", accessUser, s.apiTOTPSetup},                             // alta de TOTP
+		{"POST /v1/me/totp/confirm", accessUser, s.apiTOTPConfirm},                   // confirmación de TOTP
+		{"GET /v1/admin/users", accessAdmin, s.apiAdminUsers},                        // listado de usuarios
+		{"POST /v1/admin/users/{name}/disable", accessAdmin, s.apiAdminDisable},      // deshabilitar una cuenta
+		{"POST /v1/admin/users/{name}/enable", accessAdmin, s.apiAdminEnable},        // habilitarla de nuevo
+		{"DELETE /v1/admin/users/{name}/sessions", accessAdmin, s.apiAdminLogout},    // cerrar todas sus sesiones
+		{"DELETE /v1/admin/users/{name}", accessAdmin, s.apiAdminDelete},             // borrar la cuenta y sus datos
+	}
+}
+
+// guarded envuelve el handler de una ruta con la comprobación de su acceso
+func (s *server) guarded(r route) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ip, resource := remoteIP(req), req.Method+" "+req.URL.Path
+		var token []byte
+		if r.access != accessPublic {
+			t, ok := bearer(req)
+			if !ok {
+				s.record("token_rejected", "", ip, "", ErrUnauthorized, resource)
+				writeError(w, ErrUnauthorized)
+				return
+			}
+			token = []byte(t)
+		}
+		u, c, err := s.guard(r.access, token, ip, resource)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		r.h(w, req, u, c)
+	}
+}
+
+// recoverer responde con un error interno si un handler entra en pánico
+// (net/http también lo recupera, pero cortando la conexión sin respuesta); va dentro de
+// instrument para que la petición cuente en las métricas con su 500
+func (s *server) recoverer(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			} else if v == http.ErrAbortHandler { // corta la respuesta a propósito
+				panic(v)
+			}
+			resource := req.Method + " " + req.URL.Path
+			log.Printf("pánico en %s: %v\n%s", resource, v, debug.Stack())
+			panicsTotal.Inc()
+			s.record("panic", "", remoteIP(req), "", ErrInternal, resource)
+			writeError(w, ErrInternal)
+		}()
+		h(w, req)
+	}
+}
+
+// bearer extrae el token de la cabecera Authorization
+func bearer(req *http.Request) (string, bool) {
+	h := req.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(h[7:]), true
+}
+
+// decodeJSON lee el cuerpo JSON de una petición (con tamaño limitado y sin campos desconocidos)
+func (s *server) decodeJSON(w http.ResponseWriter, req *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, s.maxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return ErrBadRequest
+	}
+	return nil
+}
+
+// writeJSON escribe una respuesta correcta con su código de estado y, opcionalmente, datos
+func writeJSON(w http.ResponseWriter, status int, msg string, token []byte, data interface{}) {
+	r := Resp{Ok: true, Msg: msg, Token: token}
+	if data != nil {
+		raw, err := json.Marshal(data)
+		chk(err)
+		r.Data = raw
+	}
+	rJSON, err := json.Marshal(&r)
+	chk(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(rJSON)
+}
+
+// writeError escribe una respuesta de error con el código de estado que le corresponde
+func writeError(w http.ResponseWriter, err error) {
+	ae := apiError(err)
+	rJSON, err := json.Marshal(&Resp{Ok: false, Msg: ae.Msg, Code: ae.Code})
+	chk(err)
+	if ae.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(ae.Status)
+	w.Write(rJSON)
+}
+
+func (s *server) apiRegister(w http.ResponseWriter, req *http.Request, _ User, _ Claims) {
+	var in registerIn
+	if err := s.decodeJSON(w, req, &in); err != nil {
+		writeError(w, err)
+		return
+	}
+	token, err := s.register(in, remoteIP(req))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, "Usuario registrado", token, nil)
+}
+
+func (s *server) apiLogin(w http.ResponseWriter, req *http.Request, _ User, _ Claims) {
+	var in loginIn
+	if err := s.decodeJSON(w, req, &in); err != nil {
+		writeError(w, err)
+		return
+	}
+	token, err := s.login(in, remoteIP(req))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, "Credenciales válidas", token, nil)
+}
+
+func (s *server) apiSessions(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	writeJSON(w, http.StatusOK, "", nil, sortedSessions(u))
+}
+
+func (s *server) apiLogout(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	sid := req.PathValue("id")
+	if sid == "current" {
+		sid = c.Sid
+	}
+	if err := s.logout(c, sid); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, "Sesión cerrada", nil, nil)
+}
+
+func (s *server) apiLogoutAll(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	n, err := s.logoutAll(c)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, fmt.Sprintf("%d sesiones cerradas", n), nil, nil)
+}
+
+func (s *server) apiGetData(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	writeJSON(w, http.StatusOK, "", nil, u.Data)
+}
+
+func (s *server) apiPutData(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	var in map[string]string
+	if err := s.decodeJSON(w, req, &in); err != nil {
+		writeError(w, err)
+		return
+	}
+	data, err := s.putData(c, in)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, "Datos guardados", nil, data)
+}
+
+func (s *server) apiPassword(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	var in passwordIn
+	if err := s.decodeJSON(w, req, &in); err != nil {
+		writeError(w, err)
+		return
+	}
+	n, err := s.changePassword(c, in)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, fmt.Sprintf("Contraseña cambiada, %d sesiones cerradas", n), nil, nil)
+}
+
+func (s *server) apiVaultList(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	writeJSON(w, http.StatusOK, "", nil, vaultList(u))
+}
+
+func (s *server) apiVaultGet(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	e, err := vaultGet(u, req.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, "", nil, e)
+}
+
+func (s *server) apiVaultPut(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	var in vaultIn
+	if err := s.decodeJSON(w, req, &in); err != nil {
+		writeError(w, err)
+		return
+	}
+	e, created, err := s.vaultPut(c, req.PathValue("name"), in)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	e.Blob, e.Key = nil, nil // el cliente ya los tiene
+	if created {
+		writeJSON(w, http.StatusCreated, "Entrada creada", nil, e)
+		return
+	}
+	writeJSON(w, http.StatusOK, "Entrada modificada", nil, e)
+}
+
+func (s *server) apiVaultDel(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	var version uint64
+	if v := req.URL.Query().Get("version"); v != "" {
+		var err error
+		if version, err = strconv.ParseUint(v, 10, 64); err != nil {
+			writeError(w, ErrBadRequest)
+			return
+		}
+	}
+	if err := s.vaultDelete(c, req.PathValue("name"), version); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, "Entrada borrada", nil, nil)
+}
+
+func (s *server) apiAttachPut(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	key, err := base64.StdEncoding.DecodeString(req.Header.Get(AttachmentKeyHeader))
+	if err != nil {
+		writeError(w, ErrBadRequest)
+		return
+	}
+	body := http.MaxBytesReader(w, req.Body, maxAttachment)
+	a, err := s.attachPut(c, req.PathValue("name"), req.PathValue("att"), key, body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	a.Key = nil // el cliente ya la tiene
+	writeJSON(w, http.StatusOK, "Adjunto guardado", nil, a)
+}
+
+func (s *server) apiAttachGet(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	a, r, err := s.attachGet(u, req.PathValue("name"), req.PathValue("att"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer r.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+	w.Header().Set(AttachmentKeyHeader, base64.StdEncoding.EncodeToString(a.Key))
+	w.WriteHeader(http.StatusOK)
+	if _, err = io.Copy(w, r); err != nil {
+		log.Println("error enviando adjunto:", err) // la respuesta ya ha empezado
+	}
+}
+
+func (s *server) apiAttachDel(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	if err := s.attachDelete(c, req.PathValue("name"), req.PathValue("att")); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, "Adjunto borrado", nil, nil)
+}
+
+func (s *server) apiPubKey(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	pub, alg, err := s.publicKey(req.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, "", nil, map[string]string{"pubkey": pub, "keyalg": alg})
+}
+
+func (s *server) apiShare(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	var in shareIn
+	if err := s.decodeJSON(w, req, &in); err != nil {
+		writeError(w, err)
+		return
+	}
+	sh, err := s.share(c, in)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, "Entrada compartida", nil, sh)
+}
+
+func (s *server) apiShares(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	in, out := sharesList(u)
+	writeJSON(w, http.StatusOK, "", nil, map[string][]Share{"in": in, "out": out})
+}
+
+func (s *server) apiShareGet(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	sh, err := shareGet(u, req.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, "", nil, sh)
+}
+
+func (s *server) apiUnshare(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	if err := s.unshare(c, req.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, "Entrada compartida borrada", nil, nil)
+}
+
+func (s *server) apiRecoveryGet(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	r, err := recoveryGet(u)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, "", nil, r)
+}
+
+func (s *server) apiRecoverySetup(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	var in recoveryIn
+	if err := s.decodeJSON(w, req, &in); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.setupRecovery(c, in); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, "Recuperación configurada", nil, nil)
+}
+
+func (s *server) apiRecover(w http.ResponseWriter, req *http.Request, _ User, _ Claims) {
+	var in recoverIn
+	if err := s.decodeJSON(w, req, &in); err != nil {
+		writeError(w, err)
+		return
+	}
+	token, out, err := s.startRecovery(in, remoteIP(req))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, "Clave de recuperación válida", token, out)
+}
+
+func (s *server) apiRecoverPassword(w http.ResponseWriter, req *http.Request, _ User, c Claims) {
+	var in passwordIn
+	if err := s.decodeJSON(w, req, &in); err != nil {
+		writeError(w, err)
+		return
+	}
+	n, err := s.resetPassword(c, in)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, fmt.Sprintf("Contraseña restablecida, %d sesiones cerradas", n), nil, nil)
+}
+
+func (s *server) apiTOTPSetup(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	uri, err := s.totpSetup(c)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, uri, nil, map[string]string{"uri": uri})
+}
+
+func (s *server) apiTOTPConfirm(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	var in struct {
+		Code string `json:"code"`
+	}
+	if err := s.decodeJSON(w, req, &in); err != nil {
+		writeError(w, err)
+		return
+	}
+	codes, err := s.totpConfirm(c, in.Code)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, "TOTP activado", nil, map[string][]string{"recovery": codes})
+}
+
+func (s *server) apiAdminUsers(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	l, err := s.listUsers()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, "", nil, l)
+}
+
+func (s *server) apiAdminDisable(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	if err := s.setDisabled(c, req.PathValue("name"), true); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, "Cuenta deshabilitada", nil, nil)
+}
+
+func (s *server) apiAdminEnable(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	if err := s.setDisabled(c, req.PathValue("name"), false); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, "Cuenta habilitada", nil, nil)
+}
+
+func (s *server) apiAdminLogout(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	n, err := s.forceLogout(c, req.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, fmt.Sprintf("%d sesiones cerradas", n), nil, nil)
+}
+
+func (s *server) apiAdminDelete(w http.ResponseWriter, req *http.Request, u User, c Claims) {
+	if err := s.deleteUser(c, req.PathValue("name")); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, "Usuario borrado", nil, nil)
+}
@@ -0,0 +1,193 @@
+/*
+Adjuntos de las entradas de la bóveda
+
+el cliente cifra cada adjunto en flujo (comprimido y por trozos, ver util.NewCompressSealWriter)
+con una clave propia que envía cifrada con su clave de bóveda; el servidor guarda los metadatos
+y la clave en la entrada y pasa el contenido al BlobStore sin tenerlo entero en memoria
+(los adjuntos no se comparten con las entradas: ver share.go)
+*/
+package srv
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// límites de los adjuntos
+const (
+	maxAttachment     = 256 << 20 // tamaño máximo del contenido cifrado de un adjunto
+	maxAttachments    = 16        // adjuntos por entrada
+	maxAttachmentName = 128       // longitud máxima del nombre de un adjunto
+)
+
+// AttachmentKeyHeader es la cabecera con la clave cifrada del adjunto (base64) en la subida y la descarga,
+// ya que el cuerpo es el contenido cifrado tal cual
+const AttachmentKeyHeader = "X-Attachment-Key"
+
+// errores de los adjuntos
+var (
+	ErrAttachmentNotFound = &APIError{http.StatusNotFound, "attachment_not_found", "Adjunto inexistente"}
+	ErrAttachmentTooLarge = &APIError{http.StatusRequestEntityTooLarge, "attachment_too_large", "Adjunto demasiado grande"}
+	ErrAttachmentsFull    = &APIError{http.StatusRequestEntityTooLarge, "attachments_full", "Demasiados adjuntos en la entrada"}
+)
+
+// Attachment son los metadatos de un adjunto de una entrada
+type Attachment struct {
+	Size    int64     // tamaño del contenido cifrado
+	Key     []byte    `json:",omitempty"` // clave del adjunto cifrada con la clave de bóveda del cliente (opaca)
+	Blob    string    // identificador del contenido en el BlobStore
+	Updated time.Time // subida del contenido actual
+}
+
+// validAttachmentName comprueba el nombre de un adjunto (va en la ruta de la API)
+func validAttachmentName(name string) bool {
+	return name != "" && len(name) <= maxAttachmentName && validText(name, maxAttachmentName) &&
+		validEntryName(name)
+}
+
+// attachPut guarda (o reemplaza) el adjunto att de la entrada name leyendo su contenido
+// cifrado de body, sin cargarlo entero en memoria
+func (s *server) attachPut(c Claims, name, att string, key []byte, body io.Reader) (a Attachment, err error) {
+	if !validEntryName(name) || !validAttachmentName(att) || !validBytes(key, maxWrapped) {
+		return a, ErrBadRequest
+	}
+	u, err := s.users.Get(c.Sub) // comprobación previa para no recibir el contenido en balde
+	if err != nil {
+		return a, err
+	}
+	if e, ok := u.Vault[name]; !ok {
+		return a, ErrEntryNotFound
+	} else if _, ok = e.Attachments[att]; !ok && len(e.Attachments) >= maxAttachments {
+		return a, ErrAttachmentsFull
+	}
+
+	a = Attachment{Key: key, Blob: randomID(16)}
+	if a.Size, err = s.writeBlob(a.Blob, body); err != nil {
+		return a, err
+	}
+
+	var old Attachment
+	_, err = s.modify(c.Sub, func(u *User) error {
+		e, ok := u.Vault[name]
+		if !ok {
+			return ErrEntryNotFound
+		}
+		old, ok = e.Attachments[att]
+		if !ok && len(e.Attachments) >= maxAttachments {
+			return ErrAttachmentsFull
+		}
+		a.Updated = s.now()
+		if e.Attachments == nil {
+			e.Attachments = make(map[string]Attachment)
+		}
+		e.Attachments[att] = a
+		u.Vault[name] = e
+		return nil
+	})
+	if err != nil {
+		s.blobs.Delete(a.Blob)
+		return a, err
+	}
+	s.deleteBlobs(old) // el contenido anterior (si lo había)
+	return a, nil
+}
+
+// writeBlob copia body en un contenido nuevo del BlobStore y devuelve su tamaño
+// (si falla o es demasiado grande, no queda nada guardado)
+func (s *server) writeBlob(id string, body io.Reader) (n int64, err error) {
+	w, err := s.blobs.Create(id)
+	if err != nil {
+		return 0, err
+	}
+	n, err = io.Copy(w, io.LimitReader(body, maxAttachment+1))
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) || (err == nil && n > maxAttachment) {
+		err = ErrAttachmentTooLarge
+	}
+	if err != nil {
+		s.blobs.Delete(id)
+		return 0, err
+	}
+	return n, nil
+}
+
+// attachGet devuelve los metadatos de un adjunto y su contenido (hay que cerrarlo)
+func (s *server) attachGet(u User, name, att string) (Attachment, io.ReadCloser, error) {
+	e, err := vaultGet(u, name)
+	if err != nil {
+		return Attachment{}, nil, err
+	}
+	a, ok := e.Attachments[att]
+	if !ok {
+		return a, nil, ErrAttachmentNotFound
+	}
+	r, err := s.blobs.Open(a.Blob)
+	if errors.Is(err, ErrBlobNotFound) {
+		err = ErrAttachmentNotFound
+	}
+	return a, r, err
+}
+
+// attachDelete borra un adjunto de una entrada
+func (s *server) attachDelete(c Claims, name, att string) error {
+	var old Attachment
+	_, err := s.modify(c.Sub, func(u *User) error {
+		e, ok := u.Vault[name]
+		if !ok {
+			return ErrEntryNotFound
+		}
+		if old, ok = e.Attachments[att]; !ok {
+			return ErrAttachmentNotFound
+		}
+		delete(e.Attachments, att)
+		u.Vault[name] = e
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.deleteBlobs(old)
+	return nil
+}
+
+// deleteBlobs borra el contenido de unos adjuntos que ya no están en ninguna entrada
+// (un fallo sólo deja un fichero huérfano: se anota y se sigue)
+func (s *server) deleteBlobs(l ...Attachment) {
+	for _, a := range l {
+		if a.Blob == "" {
+			continue
+		}
+		if err := s.blobs.Delete(a.Blob); err != nil {
+			apiError(err) // lo anota en el log
+		}
+	}
+}
+
+// attachmentsMeta copia los adjuntos sin sus claves (para los listados)
+func attachmentsMeta(m map[string]Attachment) map[string]Attachment {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(map[string]Attachment, len(m))
+	for name, a := range m {
+		a.Key = nil
+		out[name] = a
+	}
+	return out
+}
+
+// attachmentsOf devuelve los adjuntos de unas entradas (para borrar su contenido)
+func attachmentsOf(vault map[string]VaultEntry) []Attachment {
+	var l []Attachment
+	for _, e := range vault {
+		for _, a := range e.Attachments {
+			l = append(l, a)
+		}
+	}
+	return l
+}
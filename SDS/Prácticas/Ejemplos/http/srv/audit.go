@@ -0,0 +1,47 @@
+/*
+Eventos de seguridad en el registro de auditoría (ver el paquete audit)
+*/
+package srv
+
+import (
+	"errors"
+	"log"
+	"sdshttp/audit"
+	"time"
+)
+
+// ruta del registro de auditoría (audit_path en la configuración; off para desactivarlo)
+const auditEnv = "SDSHTTP_AUDIT"
+
+// record anota un evento en el registro de auditoría con su resultado
+// (ok o el código del error; un fallo al escribir se informa pero no interrumpe la petición)
+func (s *server) record(event, user, ip, sid string, err error, detail string) {
+	result := "ok"
+	if err != nil {
+		result = ErrInternal.Code
+		var ae *APIError
+		if errors.As(err, &ae) {
+			result = ae.Code
+		}
+	}
+	r := audit.Record{Event: event, User: user, IP: ip, Sid: sid, Result: result, Detail: detail}
+	if err := s.events.Append(r); err != nil {
+		log.Println("auditoría:", err)
+	}
+}
+
+// openAudit abre el registro de auditoría en path, con sus claves en el mismo directorio
+// (nil si está desactivado con off)
+func openAudit(path string) (*audit.Log, error) {
+	if path == "off" {
+		return nil, nil
+	}
+	if path == "" {
+		path = audit.DefaultPath
+	}
+	key, err := audit.LoadKey(audit.KeyPaths(path))
+	if err != nil {
+		return nil, err
+	}
+	return audit.Open(path, key, time.Now)
+}
@@ -0,0 +1,142 @@
+/*
+Almacenamiento del contenido de los adjuntos (ver attach.go)
+
+el contenido llega ya cifrado por el cliente y se guarda tal cual, en flujo: cada adjunto es
+un fichero con un identificador aleatorio (nunca se sobrescribe: reemplazar un adjunto es crear
+otro y borrar el anterior cuando la entrada ya apunta al nuevo)
+*/
+package srv
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrBlobNotFound indica un contenido inexistente en el BlobStore
+var ErrBlobNotFound = errors.New("contenido de adjunto inexistente")
+
+// BlobStore es la interfaz de los almacenes de contenido de adjuntos
+// (las implementaciones deben poder usarse desde varias gorutinas a la vez)
+type BlobStore interface {
+	Create(id string) (io.WriteCloser, error) // crea un contenido nuevo (visible al cerrar el escritor)
+	Open(id string) (io.ReadCloser, error)    // lee un contenido (ErrBlobNotFound si no existe)
+	Delete(id string) error                   // borra un contenido (no es un error que no exista)
+}
+
+// OpenBlobs abre el almacén de adjuntos que corresponde a un almacén de usuarios:
+// en memoria para "memory" y, si no, el directorio path
+func OpenBlobs(kind, path string) (BlobStore, error) {
+	if kind == "memory" {
+		return NewMemBlobs(), nil
+	}
+	return NewDirBlobs(path)
+}
+
+// dirBlobs guarda cada contenido en un fichero del directorio dir
+type dirBlobs struct {
+	dir string
+}
+
+// NewDirBlobs abre (o crea) un almacén de adjuntos en el directorio dir
+func NewDirBlobs(dir string) (BlobStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &dirBlobs{dir: dir}, nil
+}
+
+// path devuelve la ruta del contenido id (los identificadores son base64url: no salen de dir)
+func (b *dirBlobs) path(id string) string { return filepath.Join(b.dir, filepath.Base(id)) }
+
+func (b *dirBlobs) Create(id string) (io.WriteCloser, error) {
+	tmp, err := os.CreateTemp(b.dir, id+".tmp*")
+	if err != nil {
+		return nil, err
+	}
+	return &dirBlobWriter{File: tmp, path: b.path(id)}, nil
+}
+
+// dirBlobWriter escribe en un temporal y lo renombra al cerrar (un fallo no deja nada a medias)
+type dirBlobWriter struct {
+	*os.File
+	path string
+}
+
+func (w *dirBlobWriter) Close() error {
+	err := w.Sync()
+	if cerr := w.File.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(w.Name(), w.path)
+	}
+	if err != nil {
+		os.Remove(w.Name())
+	}
+	return err
+}
+
+func (b *dirBlobs) Open(id string) (io.ReadCloser, error) {
+	f, err := os.Open(b.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return f, err
+}
+
+func (b *dirBlobs) Delete(id string) error {
+	if err := os.Remove(b.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// memBlobs guarda los contenidos en memoria (para el almacén de usuarios en memoria)
+type memBlobs struct {
+	mu    sync.RWMutex
+	blobs map[string][]byte
+}
+
+// NewMemBlobs crea un almacén de adjuntos en memoria vacío
+func NewMemBlobs() BlobStore {
+	return &memBlobs{blobs: make(map[string][]byte)}
+}
+
+func (b *memBlobs) Create(id string) (io.WriteCloser, error) {
+	return &memBlobWriter{store: b, id: id}, nil
+}
+
+// memBlobWriter acumula el contenido y lo guarda al cerrar
+type memBlobWriter struct {
+	bytes.Buffer
+	store *memBlobs
+	id    string
+}
+
+func (w *memBlobWriter) Close() error {
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+	w.store.blobs[w.id] = w.Bytes()
+	return nil
+}
+
+func (b *memBlobs) Open(id string) (io.ReadCloser, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	data, ok := b.blobs[id]
+	if !ok {
+		return nil, ErrBlobNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (b *memBlobs) Delete(id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.blobs, id)
+	return nil
+}
@@ -0,0 +1,272 @@
+/*
+Configuración del servidor
+
+cada opción se toma, de menor a mayor prioridad, del valor por defecto, del fichero de
+configuración (JSON, sdshttp.json si existe o el indicado con -config o SDSHTTP_CONFIG),
+de su variable de entorno y de su opción en la línea de órdenes:
+
+	sdshttp srv -addr :8443 -session-ttl 30m -store bolt
+
+las duraciones se escriben como en Go (p.ej. "90s", "5m", "1h30m"), también en el fichero
+*/
+package srv
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sdshttp/audit"
+	"strconv"
+	"time"
+)
+
+// variables de entorno y fichero de configuración
+const (
+	configEnv  = "SDSHTTP_CONFIG" // fichero de configuración
+	configFile = "sdshttp.json"   // fichero por defecto (opcional)
+)
+
+// Duration es una duración que en JSON se escribe como texto ("30s", "5m"...)
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	*d = Duration(v)
+	return err
+}
+
+// Config es la configuración del servidor (ver DefaultConfig)
+type Config struct {
+	Addr              string   `json:"addr"`                // dirección de escucha
+	CertFile          string   `json:"cert"`                // certificado TLS
+	KeyFile           string   `json:"key"`                 // clave privada del certificado
+	ReadTimeout       Duration `json:"read_timeout"`        // lectura de la petición completa (con el cuerpo)
+	ReadHeaderTimeout Duration `json:"read_header_timeout"` // lectura de las cabeceras
+	WriteTimeout      Duration `json:"write_timeout"`       // escritura de la respuesta
+	IdleTimeout       Duration `json:"idle_timeout"`        // conexiones keep-alive sin peticiones
+	ShutdownTimeout   Duration `json:"shutdown_timeout"`    // espera a las peticiones en curso al parar
+	MaxHeaderBytes    int      `json:"max_header_bytes"`    // tamaño máximo de las cabeceras
+	MaxBodyBytes      int64    `json:"max_body_bytes"`      // tamaño máximo de los cuerpos JSON y formularios (no de los adjuntos)
+	SessionTTL        Duration `json:"session_ttl"`         // duración de las sesiones (de sus tokens)
+	Store             string   `json:"store"`               // tipo de almacén: file, bolt o memory
+	StorePath         string   `json:"store_path"`          // ruta del almacén (users.dat o users.db si vacía)
+	MasterKeyFile     string   `json:"master_key_file"`     // clave maestra de los almacenes en disco (se crea si no existe)
+	AuditPath         string   `json:"audit_path"`          // registro de auditoría, con sus claves al lado (off: desactivado)
+	MetricsAddr       string   `json:"metrics_addr"`        // puerto de administración con /metrics (vacío: sin métricas)
+}
+
+// DefaultConfig es la configuración sin fichero, variables ni opciones
+// (los tiempos de lectura y escritura dejan margen para subir y bajar adjuntos grandes)
+var DefaultConfig = Config{
+	Addr:              ":10443",
+	CertFile:          "localhost.crt",
+	KeyFile:           "localhost.key",
+	ReadTimeout:       Duration(5 * time.Minute),
+	ReadHeaderTimeout: Duration(10 * time.Second),
+	WriteTimeout:      Duration(5 * time.Minute),
+	IdleTimeout:       Duration(2 * time.Minute),
+	ShutdownTimeout:   Duration(30 * time.Second),
+	MaxHeaderBytes:    64 << 10,
+	MaxBodyBytes:      1 << 20,
+	SessionTTL:        Duration(60 * time.Minute),
+	Store:             "file",
+	MasterKeyFile:     keyFile,
+	AuditPath:         audit.DefaultPath,
+}
+
+// option es una opción de la configuración con su variable de entorno y su opción de línea
+type option struct {
+	flag, env, usage string
+	value            flag.Value
+}
+
+// options devuelve las opciones que modifican c
+func (c *Config) options() []option {
+	return []option{
+		{"addr", "SDSHTTP_ADDR", "dirección de escucha", stringValue{&c.Addr}},
+		{"cert", "SDSHTTP_CERT", "certificado TLS", stringValue{&c.CertFile}},
+		{"key", "SDSHTTP_KEY", "clave privada del certificado TLS", stringValue{&c.KeyFile}},
+		{"read-timeout", "SDSHTTP_READ_TIMEOUT", "tiempo máximo de lectura de una petición", durationValue{&c.ReadTimeout}},
+		{"read-header-timeout", "SDSHTTP_READ_HEADER_TIMEOUT", "tiempo máximo de lectura de las cabeceras", durationValue{&c.ReadHeaderTimeout}},
+		{"write-timeout", "SDSHTTP_WRITE_TIMEOUT", "tiempo máximo de escritura de una respuesta", durationValue{&c.WriteTimeout}},
+		{"idle-timeout", "SDSHTTP_IDLE_TIMEOUT", "tiempo máximo de una conexión inactiva", durationValue{&c.IdleTimeout}},
+		{"shutdown-timeout", "SDSHTTP_SHUTDOWN_TIMEOUT", "espera a las peticiones en curso al parar", durationValue{&c.ShutdownTimeout}},
+		{"max-header", "SDSHTTP_MAX_HEADER", "tamaño máximo de las cabeceras (bytes)", intValue{&c.MaxHeaderBytes}},
+		{"max-body", "SDSHTTP_MAX_BODY", "tamaño máximo de los cuerpos JSON y formularios (bytes)", int64Value{&c.MaxBodyBytes}},
+		{"session-ttl", "SDSHTTP_SESSION_TTL", "duración de las sesiones", durationValue{&c.SessionTTL}},
+		{"store", storeEnv, "tipo de almacén: file, bolt o memory", stringValue{&c.Store}},
+		{"store-path", pathEnv, "ruta del almacén (users.dat o users.db por defecto)", stringValue{&c.StorePath}},
+		{"master-key-file", "SDSHTTP_MASTER_KEY_FILE", "fichero de la clave maestra (si no se da en " + keyEnv + ")", stringValue{&c.MasterKeyFile}},
+		{"audit-path", auditEnv, "registro de auditoría, con audit.key y audit.pub en su directorio (off para desactivarlo)", stringValue{&c.AuditPath}},
+		{"metrics-addr", "SDSHTTP_METRICS_ADDR", "dirección del puerto de administración con /metrics, sin TLS (vacía: no se publican)", stringValue{&c.MetricsAddr}},
+	}
+}
+
+// LoadConfig obtiene la configuración del servidor a partir de los argumentos de la línea de
+// órdenes (tras "srv"); sin argumentos, la del fichero y las variables de entorno
+func LoadConfig(args []string) (Config, error) {
+	c := DefaultConfig
+	fs := flag.NewFlagSet("srv", flag.ContinueOnError)
+	path := fs.String("config", "", "fichero de configuración JSON (también "+configEnv+"; "+configFile+" si existe)")
+	for _, o := range c.options() {
+		fs.Var(o.value, o.flag, o.usage+" (también "+o.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+	if fs.NArg() > 0 {
+		return c, fmt.Errorf("argumento inesperado: %q", fs.Arg(0))
+	}
+
+	// el orden de prioridad obliga a aplicar fichero y entorno antes que las opciones ya leídas:
+	// se guardan las que se han dado y se vuelven a aplicar al final
+	given := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = f.Value.String() })
+
+	c = DefaultConfig
+	if *path == "" {
+		*path = os.Getenv(configEnv)
+	}
+	if err := c.loadFile(*path); err != nil {
+		return c, err
+	}
+	opts := c.options()
+	for _, o := range opts {
+		if v := os.Getenv(o.env); v != "" {
+			if err := o.value.Set(v); err != nil {
+				return c, fmt.Errorf("%s: %w", o.env, err)
+			}
+		}
+	}
+	for _, o := range opts {
+		if v, ok := given[o.flag]; ok {
+			o.value.Set(v) // ya comprobado al leer las opciones
+		}
+	}
+	return c, c.check()
+}
+
+// loadFile aplica el fichero de configuración (si path está vacío, configFile sólo si existe)
+func (c *Config) loadFile(path string) error {
+	optional := path == ""
+	if optional {
+		path = configFile
+	}
+	f, err := os.Open(path)
+	if optional && errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields() // una opción mal escrita no debe pasar desapercibida
+	if err = dec.Decode(c); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// check comprueba que la configuración tiene sentido
+func (c *Config) check() error {
+	switch {
+	case c.Store != "file" && c.Store != "bolt" && c.Store != "memory":
+		return errors.New("tipo de almacén desconocido: " + c.Store)
+	case c.Addr == "" || c.CertFile == "" || c.KeyFile == "":
+		return errors.New("faltan la dirección de escucha o el certificado")
+	case c.MasterKeyFile == "" || c.AuditPath == "":
+		return errors.New("faltan el fichero de la clave maestra o el registro de auditoría (off para desactivarlo)")
+	case c.MetricsAddr != "" && c.MetricsAddr == c.Addr:
+		return errors.New("el puerto de administración no puede ser el de la API")
+	case c.ReadTimeout < 0 || c.ReadHeaderTimeout < 0 || c.WriteTimeout < 0 || c.IdleTimeout < 0 || c.ShutdownTimeout < 0:
+		return errors.New("los tiempos máximos no pueden ser negativos")
+	case c.MaxHeaderBytes <= 0 || c.MaxBodyBytes <= 0:
+		return errors.New("los tamaños máximos deben ser positivos")
+	case c.SessionTTL < Duration(time.Minute):
+		return errors.New("las sesiones deben durar al menos un minuto")
+	}
+	return nil
+}
+
+// storePath devuelve la ruta del almacén (la de por defecto según su tipo si no se indica)
+func (c *Config) storePath() string {
+	if c.StorePath != "" {
+		return c.StorePath
+	}
+	if c.Store == "bolt" {
+		return usersDB
+	}
+	return usersFile
+}
+
+// valores de las opciones (flag.Value sobre los campos de Config)
+type (
+	stringValue   struct{ p *string }
+	intValue      struct{ p *int }
+	int64Value    struct{ p *int64 }
+	durationValue struct{ p *Duration }
+)
+
+func (v stringValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return *v.p
+}
+
+func (v stringValue) Set(s string) error { *v.p = s; return nil }
+
+func (v intValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.Itoa(*v.p)
+}
+
+func (v intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err == nil {
+		*v.p = n
+	}
+	return err
+}
+
+func (v int64Value) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.FormatInt(*v.p, 10)
+}
+
+func (v int64Value) Set(s string) error {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err == nil {
+		*v.p = n
+	}
+	return err
+}
+
+func (v durationValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return time.Duration(*v.p).String()
+}
+
+func (v durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err == nil {
+		*v.p = Duration(d)
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package srv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sdshttp/util"
+	"strings"
+	"testing"
+)
+
+// fuzzServer crea un servidor con dos usuarios (ana, administradora, y eva) y devuelve el
+// token de ana (las entradas al azar llegan así también a las rutas con sesión)
+func fuzzServer(t *testing.T) (*server, []byte) {
+	s, admin, _ := adminServer(t)
+	return s, admin
+}
+
+// fuzzMux es el enrutador de routes sin recoverer ni métricas: un pánico en un handler llega a la
+// prueba y la hace fallar en lugar de convertirse en un error interno
+func fuzzMux(s *server) *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, r := range s.apiRoutes() {
+		mux.HandleFunc(r.pattern, s.guarded(r))
+	}
+	mux.HandleFunc("/{$}", s.handler)
+	return mux
+}
+
+// serveFuzz atiende una petición (con fuzzMux: un pánico hace fallar la prueba) y comprueba
+// que la respuesta no es un error interno
+func serveFuzz(t *testing.T, s *server, req *http.Request) {
+	t.Helper()
+	req.RemoteAddr = "10.0.0.2:1234"
+	rec := httptest.NewRecorder()
+	fuzzMux(s).ServeHTTP(rec, req)
+
+	if rec.Code == http.StatusInternalServerError {
+		t.Fatalf("%s %s: error interno: %s", req.Method, req.URL, rec.Body)
+	}
+	var r Resp
+	if json.Unmarshal(rec.Body.Bytes(), &r) == nil && r.Code == ErrInternal.Code {
+		t.Fatalf("%s %s: error interno: %s", req.Method, req.URL, rec.Body)
+	}
+}
+
+// FuzzLegacyForm envía formularios al azar al endpoint antiguo (con el token de ana si auth)
+func FuzzLegacyForm(f *testing.F) {
+	for _, seed := range []string{
+		"cmd=register&user=luis&pass=Y2xhdmU%3D&pubkey=pub&prikey=pri",
+		"cmd=login&user=ana&pass=Y2xhdmU%3D",
+		"cmd=login&user=ana&pass=no-es-base64",
+		"cmd=data&user=ana",
+		"cmd=vault-put&name=web&blob=AAAA&key=AAAA&version=x",
+		"cmd=share&to=eva&name=web&key=AAAA",
+		"cmd=admin-users",
+		"cmd=admin-disable&name=eva",
+		"cmd=totp-confirm&code=000000",
+		"cmd=recover&user=ana&auth=AAAA",
+		"cmd=desconocido",
+		"%zz",
+	} {
+		f.Add(seed, true)
+		f.Add(seed, false)
+	}
+
+	f.Fuzz(func(t *testing.T, body string, auth bool) {
+		s, token := fuzzServer(t)
+		if auth {
+			body += "&token=" + url.QueryEscape(util.Encode64(token))
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		serveFuzz(t, s, req)
+	})
+}
+
+// rutas de la API para FuzzAPIBody ({x} se sustituye por el segmento al azar)
+var fuzzRoutes = []string{
+	"POST /v1/users",
+	"POST /v1/sessions",
+	"GET /v1/sessions",
+	"DELETE /v1/sessions/{x}",
+	"GET /v1/me/data",
+	"PUT /v1/me/data",
+	"PUT /v1/me/password",
+	"GET /v1/me/vault/{x}",
+	"PUT /v1/me/vault/{x}",
+	"DELETE /v1/me/vault/{x}",
+	"PUT /v1/me/vault/web/attachments/{x}",
+	"GET /v1/users/{x}/pubkey",
+	"POST /v1/shares",
+	"GET /v1/shares/{x}",
+	"DELETE /v1/shares/{x}",
+	"PUT /v1/me/recovery",
+	"POST /v1/recovery",
+	"PUT /v1/recovery/password",
+	"POST /v1/me/totp",
+	"POST /v1/me/totp/confirm",
+	"POST /v1/admin/users/{x}/disable",
+	"DELETE /v1/admin/users/{x}",
+}
+
+// FuzzAPIBody envía cuerpos y segmentos de ruta al azar a las rutas de la API REST
+func FuzzAPIBody(f *testing.F) {
+	f.Add(uint8(0), "", []byte(`{"user":"luis","pass":"Y2xhdmU=","pubkey":"pub","prikey":"pri"}`), false)
+	f.Add(uint8(1), "", []byte(`{"user":"ana","pass":"Y2xhdmU="}`), false)
+	f.Add(uint8(5), "", []byte(`{"k":"v"}`), true)
+	f.Add(uint8(8), "web", []byte(`{"blob":"AAAA","key":"AAAA","version":1}`), true)
+	f.Add(uint8(9), "web?version=-1", []byte{}, true)
+	f.Add(uint8(12), "", []byte(`{"to":"eva","name":"web","key":"AAAA"}`), true)
+	f.Add(uint8(19), "", []byte(`{"code":"123456"}`), true)
+	f.Add(uint8(20), "eva", []byte{}, true)
+	f.Add(uint8(6), "", []byte(`{"pass":"Y2xhdmU=","new":"","vault":{"web":{}}}`), true)
+	f.Add(uint8(2), "", []byte("{"), true)
+
+	f.Fuzz(func(t *testing.T, route uint8, seg string, body []byte, auth bool) {
+		s, token := fuzzServer(t)
+		method, path, _ := strings.Cut(fuzzRoutes[int(route)%len(fuzzRoutes)], " ")
+		u, err := url.Parse(strings.Replace(path, "{x}", seg, 1))
+		if err != nil {
+			t.Skip("ruta inválida") // no llegaría al servidor
+		}
+		req := httptest.NewRequest(method, "/", strings.NewReader(string(body)))
+		req.URL, req.RequestURI = u, u.RequestURI()
+		req.Header.Set("Content-Type", "application/json")
+		if auth {
+			req.Header.Set("Authorization", "Bearer "+string(token))
+		}
+		serveFuzz(t, s, req)
+	})
+}
@@ -0,0 +1,74 @@
+/*
+Algoritmos de las claves públicas de los usuarios
+
+el servidor no usa las claves, pero guarda con cada una su identificador de algoritmo
+(User.Data["keyalg"]) para que quien la obtenga sepa cómo usarla, y comprueba al registrarla
+que es una clave PKIX válida de ese algoritmo
+*/
+package srv
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+)
+
+// identificadores de algoritmo de las claves de los usuarios
+const (
+	KeyAlgRSAJSON = "rsa-json"   // formato antiguo: RSA serializada con JSON y comprimida (claves sin keyalg)
+	KeyAlgRSA     = "rsa"        // RSA de 3072 bits o más (cifrado RSA-OAEP con SHA-256, firma RSA-PSS)
+	KeyAlgP256    = "ecdsa-p256" // ECDSA P-256 (firma; cifrado con ECDH P-256 + HKDF + AES-GCM)
+	KeyAlgEd25519 = "ed25519"    // Ed25519 (sólo firma)
+	KeyAlgX25519  = "x25519"     // X25519 (sólo cifrado, con ECDH + HKDF + AES-GCM)
+)
+
+// tamaño mínimo de las claves RSA nuevas
+const minRSABits = 3072
+
+// ErrBadKey indica una clave pública que no es válida para el algoritmo indicado
+var ErrBadKey = &APIError{http.StatusBadRequest, "bad_key", "Clave pública inválida para el algoritmo indicado"}
+
+// checkPublicKey comprueba que pub (PKIX en base64) es una clave válida del algoritmo alg
+// (las claves en el formato antiguo sólo se aceptan sin algoritmo, y no se comprueban)
+func checkPublicKey(alg, pub string) error {
+	if alg == "" || alg == KeyAlgRSAJSON {
+		return nil
+	}
+	der, err := base64.StdEncoding.DecodeString(pub)
+	if err != nil {
+		return ErrBadKey
+	}
+	k, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return ErrBadKey
+	}
+
+	ok := false
+	switch k := k.(type) {
+	case *rsa.PublicKey:
+		ok = alg == KeyAlgRSA && k.N.BitLen() >= minRSABits
+	case *ecdsa.PublicKey:
+		ok = alg == KeyAlgP256 && k.Curve == elliptic.P256()
+	case ed25519.PublicKey:
+		ok = alg == KeyAlgEd25519
+	case *ecdh.PublicKey:
+		ok = alg == KeyAlgX25519 && k.Curve() == ecdh.X25519()
+	}
+	if !ok {
+		return ErrBadKey
+	}
+	return nil
+}
+
+// keyAlg devuelve el algoritmo de la clave de un usuario (las antiguas no lo tienen)
+func keyAlg(u User) string {
+	if alg := u.Data["keyalg"]; alg != "" {
+		return alg
+	}
+	return KeyAlgRSAJSON
+}
@@ -0,0 +1,447 @@
+/*
+Endpoint antiguo: POST / con el comando en el campo cmd del formulario
+(se mantiene por compatibilidad; siempre responde 200 con Resp y el error en Resp.Code)
+*/
+package srv
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sdshttp/util"
+	"strconv"
+)
+
+func (s *server) handler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")           // cabecera estándar
+	req.Body = http.MaxBytesReader(w, req.Body, s.maxBody) // limitamos el tamaño del formulario
+	if err := req.ParseForm(); err != nil {                // es necesario parsear el formulario
+		responseErr(w, ErrBadRequest)
+		return
+	}
+	f := &form{req: req}
+
+	cmd := req.Form.Get("cmd") // comprobamos comando desde el cliente
+	a, ok := legacyAccess[cmd]
+	if !ok {
+		responseErr(w, ErrUnknownCommand)
+		return
+	}
+	u, c, err := s.formSession(req, a)
+	if err != nil {
+		responseErr(w, err)
+		return
+	}
+
+	switch cmd {
+	case "register": // ** registro
+		in := registerIn{
+			User:   req.Form.Get("user"),   // nombre
+			Pass:   f.bytes("pass"),        // contraseña (keyLogin)
+			PubKey: req.Form.Get("pubkey"), // clave pública
+			PriKey: req.Form.Get("prikey"), // clave privada
+			KeyAlg: req.Form.Get("keyalg"), // algoritmo de las claves (vacío: formato antiguo)
+			Device: req.Form.Get("device"), // dispositivo
+		}
+		if f.err != nil {
+			responseErr(w, f.err)
+			return
+		}
+		token, err := s.register(in, remoteIP(req))
+		if err != nil {
+			responseErr(w, err)
+			return
+		}
+		response(w, true, "Usuario registrado", token)
+
+	case "login": // ** login
+		in := loginIn{
+			User:     req.Form.Get("user"),
+			Pass:     f.bytes("pass"), // obtenemos la contraseña (keyLogin)
+			Code:     req.Form.Get("code"),
+			Recovery: req.Form.Get("recovery"),
+			Device:   req.Form.Get("device"),
+		}
+		if f.err != nil {
+			responseErr(w, f.err)
+			return
+		}
+		token, err := s.login(in, remoteIP(req))
+		if err != nil {
+			responseErr(w, err)
+			return
+		}
+		response(w, true, "Credenciales válidas", token)
+
+	case "data": // ** obtener datos de usuario
+		datos, err := json.Marshal(&u.Data) //
+		chk(err)
+		response(w, true, string(datos), nil)
+
+	case "sessions": // ** listar las sesiones abiertas
+		datos, err := json.Marshal(sortedSessions(u))
+		chk(err)
+		response(w, true, string(datos), nil)
+
+	case "logout": // ** cerrar la sesión actual (u otra del mismo usuario, indicada en session)
+		if err := s.logout(c, req.Form.Get("session")); err != nil {
+			responseErr(w, err)
+			return
+		}
+		response(w, true, "Sesión cerrada", nil)
+
+	case "logout-all": // ** cerrar todas las sesiones del usuario (incluida la actual)
+		n, err := s.logoutAll(c)
+		if err != nil {
+			responseErr(w, err)
+			return
+		}
+		response(w, true, fmt.Sprintf("%d sesiones cerradas", n), nil)
+
+	case "passwd": // ** cambio de contraseña (con la clave privada y la bóveda cifradas de nuevo por el cliente)
+		in := passwordIn{
+			Pass:   f.bytes("pass"), // keyLogin actual
+			New:    f.bytes("new"),  // keyLogin nueva
+			PriKey: req.Form.Get("prikey"),
+		}
+		err = f.err
+		if err == nil {
+			err = passwordForm(req, &in)
+		}
+		var n int
+		if err == nil {
+			n, err = s.changePassword(c, in)
+		}
+		if err != nil {
+			responseErr(w, err)
+			return
+		}
+		response(w, true, fmt.Sprintf("Contraseña cambiada, %d sesiones cerradas", n), nil)
+
+	case "vault-list": // ** entradas de la bóveda (sólo metadatos)
+		datos, err := json.Marshal(vaultList(u))
+		chk(err)
+		response(w, true, string(datos), nil)
+
+	case "vault-get": // ** una entrada de la bóveda (cifrada)
+		e, err := vaultGet(u, req.Form.Get("name"))
+		if err != nil {
+			responseErr(w, err)
+			return
+		}
+		datos, err := json.Marshal(e)
+		chk(err)
+		response(w, true, string(datos), nil)
+
+	case "vault-put": // ** crear (version vacía o 0) o modificar una entrada de la bóveda
+		in := vaultIn{
+			Blob:    f.bytes("blob"), // contenido cifrado
+			Key:     f.bytes("key"),  // clave de la entrada cifrada
+			Version: f.version(),
+		}
+		if f.err != nil {
+			responseErr(w, f.err)
+			return
+		}
+		e, _, err := s.vaultPut(c, req.Form.Get("name"), in)
+		if err != nil {
+			responseErr(w, err)
+			return
+		}
+		response(w, true, strconv.FormatUint(e.Version, 10), nil) // nueva versión
+
+	case "vault-delete": // ** borrar una entrada de la bóveda
+		version := f.version()
+		if f.err != nil {
+			responseErr(w, f.err)
+			return
+		}
+		if err := s.vaultDelete(c, req.Form.Get("name"), version); err != nil {
+			responseErr(w, err)
+			return
+		}
+		response(w, true, "Entrada borrada", nil)
+
+	case "pubkey": // ** clave pública de otro usuario (para compartir; sin el algoritmo, ver pubkey-info)
+		pub, _, err := s.publicKey(req.Form.Get("name"))
+		if err != nil {
+			responseErr(w, err)
+			return
+		}
+		response(w, true, pub, nil)
+
+	case "pubkey-info": // ** clave pública de otro usuario con su algoritmo (JSON)
+		pub, alg, err := s.publicKey(req.Form.Get("name"))
+		if err != nil {
+			responseErr(w, err)
+			return
+		}
+		datos, err := json.Marshal(map[string]string{"pubkey": pub, "keyalg": alg})
+		chk(err)
+		response(w, true, string(datos), nil)
+
+	case "share": // ** compartir una entrada de la bóveda
+		in := shareIn{
+			To:      req.Form.Get("to"),
+			Name:    req.Form.Get("name"),
+			Key:     f.bytes("key"), // clave de la entrada cifrada para el destinatario
+			Version: f.version(),
+		}
+		if f.err != nil {
+			responseErr(w, f.err)
+			return
+		}
+		sh, err := s.share(c, in)
+		if err != nil {
+			responseErr(w, err)
+			return
+		}
+		response(w, true, sh.ID, nil)
+
+	case "shares": // ** entradas compartidas recibidas y enviadas
+		in, out := sharesList(u)
+		datos, err := json.Marshal(map[string][]Share{"in": in, "out": out})
+		chk(err)
+		response(w, true, string(datos), nil)
+
+	case "share-get": // ** una entrada compartida recibida (cifrada)
+		sh, err := shareGet(u, req.Form.Get("id"))
+		if err != nil {
+			responseErr(w, err)
+			return
+		}
+		datos, err := json.Marshal(sh)
+		chk(err)
+		response(w, true, string(datos), nil)
+
+	case "unshare": // ** revocar (emisor) o descartar (destinatario) una entrada compartida
+		if err := s.unshare(c, req.Form.Get("id")); err != nil {
+			responseErr(w, err)
+			return
+		}
+		response(w, true, "Entrada compartida borrada", nil)
+
+	case "recovery-get": // ** datos de recuperación (cifrados, para cambiar la contraseña)
+		r, err := recoveryGet(u)
+		if err != nil {
+			responseErr(w, err)
+			return
+		}
+		datos, err := json.Marshal(r)
+		chk(err)
+		response(w, true, string(datos), nil)
+
+	case "recovery-setup": // ** configurar la recuperación de la cuenta
+		in := recoveryIn{
+			Auth:   f.bytes("auth"),
+			Escrow: f.bytes("escrow"),
+			Secret: f.bytes("secret"),
+		}
+		err = f.err
+		if err == nil {
+			err = s.setupRecovery(c, in)
+		}
+		if err != nil {
+			responseErr(w, err)
+			return
+		}
+		response(w, true, "Recuperación configurada", nil)
+
+	case "recover": // ** iniciar una recuperación: devuelve un token de recuperación y los datos cifrados
+		in := recoverIn{
+			User: req.Form.Get("user"),
+			Auth: f.bytes("auth"),
+		}
+		if f.err != nil {
+			responseErr(w, f.err)
+			return
+		}
+		token, out, err := s.startRecovery(in, remoteIP(req))
+		if err != nil {
+			responseErr(w, err)
+			return
+		}
+		datos, err := json.Marshal(out)
+		chk(err)
+		response(w, true, string(datos), token)
+
+	case "recover-password": // ** contraseña nueva con el token de recuperación (mismos campos que passwd, sin pass)
+		in := passwordIn{
+			New:    f.bytes("new"),
+			PriKey: req.Form.Get("prikey"),
+		}
+		err = f.err
+		if err == nil {
+			err = passwordForm(req, &in)
+		}
+		var n int
+		if err == nil {
+			n, err = s.resetPassword(c, in)
+		}
+		if err != nil {
+			responseErr(w, err)
+			return
+		}
+		response(w, true, fmt.Sprintf("Contraseña restablecida, %d sesiones cerradas", n), nil)
+
+	case "totp-setup": // ** alta del segundo factor: genera un secreto pendiente de confirmar
+		uri, err := s.totpSetup(c)
+		if err != nil {
+			responseErr(w, err)
+			return
+		}
+		response(w, true, uri, nil) // otpauth://... para la aplicación de autentificación
+
+	case "totp-confirm": // ** confirmación del segundo factor con un primer código
+		codes, err := s.totpConfirm(c, req.Form.Get("code"))
+		if err != nil {
+			responseErr(w, err)
+			return
+		}
+
+		datos, err := json.Marshal(codes) // códigos de recuperación (sólo se muestran ahora)
+		chk(err)
+		response(w, true, string(datos), nil)
+
+	case "admin-users": // ** (administradores) listado de usuarios
+		l, err := s.listUsers()
+		if err != nil {
+			responseErr(w, err)
+			return
+		}
+		datos, err := json.Marshal(l)
+		chk(err)
+		response(w, true, string(datos), nil)
+
+	case "admin-disable", "admin-enable": // ** (administradores) deshabilitar o habilitar la cuenta name
+		if err := s.setDisabled(c, req.Form.Get("name"), cmd == "admin-disable"); err != nil {
+			responseErr(w, err)
+			return
+		}
+		response(w, true, "Cuenta modificada", nil)
+
+	case "admin-logout": // ** (administradores) cerrar todas las sesiones de name
+		n, err := s.forceLogout(c, req.Form.Get("name"))
+		if err != nil {
+			responseErr(w, err)
+			return
+		}
+		response(w, true, fmt.Sprintf("%d sesiones cerradas", n), nil)
+
+	case "admin-delete": // ** (administradores) borrar la cuenta name y sus datos
+		if err := s.deleteUser(c, req.Form.Get("name")); err != nil {
+			responseErr(w, err)
+			return
+		}
+		response(w, true, "Usuario borrado", nil)
+
+	default: // no debería ocurrir: un comando en legacyAccess sin su caso
+		responseErr(w, ErrUnknownCommand)
+	}
+
+}
+
+// legacyAccess es el acceso que exige cada comando del endpoint antiguo
+// (un comando que no esté aquí es desconocido aunque tenga su caso en handler)
+var legacyAccess = map[string]access{
+	"register":         accessPublic,
+	"login":            accessPublic,
+	"recover":          accessPublic,
+	"recover-password": accessRecover,
+	"data":             accessUser,
+	"sessions":         accessUser,
+	"logout":           accessUser,
+	"logout-all":       accessUser,
+	"passwd":           accessUser,
+	"vault-list":       accessUser,
+	"vault-get":        accessUser,
+	"vault-put":        accessUser,
+	"vault-delete":     accessUser,
+	"pubkey":           accessUser,
+	"pubkey-info":      accessUser,
+	"share":            accessUser,
+	"shares":           accessUser,
+	"share-get":        accessUser,
+	"unshare":          accessUser,
+	"recovery-get":     accessUser,
+	"recovery-setup":   accessUser,
+	"totp-setup":       accessUser,
+	"totp-confirm":     accessUser,
+	"admin-users":      accessAdmin,
+	"admin-disable":    accessAdmin,
+	"admin-enable":     accessAdmin,
+	"admin-logout":     accessAdmin,
+	"admin-delete":     accessAdmin,
+}
+
+func init() {
+	for cmd, a := range legacyAccess {
+		checkAccess(a, "cmd "+cmd)
+	}
+}
+
+// formSession comprueba el acceso a un comando del endpoint antiguo: token en base64 en el campo
+// token y, si se indica, el usuario en el campo user (debe coincidir con el del token)
+func (s *server) formSession(req *http.Request, a access) (User, Claims, error) {
+	token, err := util.Decode64(req.Form.Get("token"))
+	if err != nil {
+		token = nil // un token malformado es como no enviarlo (guard lo rechaza y lo registra)
+	}
+	u, c, err := s.guard(a, token, remoteIP(req), "cmd "+req.Form.Get("cmd"))
+	if err == nil && a != accessPublic {
+		if name := req.Form.Get("user"); name != "" && name != c.Sub {
+			return User{}, c, ErrUnauthorized // token de otro usuario
+		}
+	}
+	return u, c, err
+}
+
+// form lee los campos del formulario del endpoint antiguo que no son texto y guarda el primer
+// error (un campo malformado hace fallar la petición con ErrBadRequest)
+type form struct {
+	req *http.Request
+	err error
+}
+
+// bytes decodifica un campo en base64 (vacío si no se envía)
+func (f *form) bytes(name string) []byte {
+	b, err := util.Decode64(f.req.Form.Get(name))
+	if err != nil && f.err == nil {
+		f.err = ErrBadRequest
+	}
+	return b
+}
+
+// version lee el número de versión de una entrada (0 si no se envía)
+func (f *form) version() uint64 {
+	v := f.req.Form.Get("version")
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil && f.err == nil {
+		f.err = ErrBadRequest
+	}
+	return n
+}
+
+// passwordForm lee los campos JSON opcionales de un cambio de contraseña del endpoint antiguo:
+// vault (nombre -> {key, version}) y recovery ({escrow, secret})
+func passwordForm(req *http.Request, in *passwordIn) error {
+	if v := req.Form.Get("vault"); v != "" && json.Unmarshal([]byte(v), &in.Vault) != nil {
+		return ErrBadRequest
+	}
+	if v := req.Form.Get("recovery"); v != "" && json.Unmarshal([]byte(v), &in.Recovery) != nil {
+		return ErrBadRequest
+	}
+	return nil
+}
+
+// responseErr escribe una respuesta de error del endpoint antiguo
+func responseErr(w http.ResponseWriter, err error) {
+	ae := apiError(err)
+	r := Resp{Ok: false, Msg: ae.Msg, Code: ae.Code}
+	rJSON, err := json.Marshal(&r)
+	chk(err)
+	w.Write(rJSON)
+}
@@ -0,0 +1,204 @@
+/*
+Protección contra fuerza bruta en el login
+*/
+package srv
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// límites de intentos de login (cubetas de tokens por usuario y por IP)
+const (
+	userBurst = 5               // intentos seguidos por usuario
+	userEvery = 1 * time.Minute // se recupera un intento por usuario cada...
+	ipBurst   = 20              // intentos seguidos por IP
+	ipEvery   = 6 * time.Second // se recupera un intento por IP cada...
+
+	// cubetas como máximo: cualquiera puede intentar el login con nombres inventados, y cada
+	// uno crea una cubeta; al llegar al máximo se descartan las llenas y, si no basta, las
+	// que llevan más tiempo sin usarse
+	maxBuckets = 100000
+)
+
+// espera tras fallos consecutivos: 1s, 2s, 4s... y bloqueo temporal a partir de lockoutAfter fallos
+const (
+	backoffBase  = time.Second
+	backoffMax   = 30 * time.Second
+	lockoutAfter = 10
+	lockoutTime  = 15 * time.Minute
+)
+
+// bucket es una cubeta de tokens: se gasta uno por intento y se rellenan con el tiempo
+type bucket struct {
+	Tokens float64   // tokens disponibles
+	Last   time.Time // última actualización
+}
+
+// limiter limita la frecuencia de intentos por clave ("user:nombre", "ip:dirección")
+type limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+	path    string // fichero donde se conserva el estado entre reinicios ("" en memoria)
+	dirty   bool   // hay cambios sin guardar
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// newLimiter crea el limitador; si path no está vacío, carga su estado de ese fichero
+// y lo guarda en él periódicamente (hasta llamar a Close)
+func newLimiter(path string, now func() time.Time) (*limiter, error) {
+	l := &limiter{buckets: make(map[string]*bucket), now: now, path: path}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		} else if err == nil {
+			if err = json.Unmarshal(data, &l.buckets); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	l.stop, l.done = make(chan struct{}), make(chan struct{})
+	go func() { // purga (y guardado) periódico
+		defer close(l.done)
+		t := time.NewTicker(5 * time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				l.save()
+			case <-l.stop:
+				return
+			}
+		}
+	}()
+	return l, nil
+}
+
+// rate devuelve la capacidad y el periodo de recarga según el tipo de clave
+func rate(key string) (burst float64, every time.Duration) {
+	if len(key) > 3 && key[:3] == "ip:" {
+		return ipBurst, ipEvery
+	}
+	return userBurst, userEvery
+}
+
+// Allow gasta un intento de cada clave; devuelve false (sin gastar ninguno) si alguna está agotada
+func (l *limiter) Allow(keys ...string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if len(l.buckets)+len(keys) > maxBuckets {
+		l.evict(now)
+	}
+	for _, k := range keys { // primero rellenamos y comprobamos todas
+		b, ok := l.buckets[k]
+		burst, every := rate(k)
+		if !ok {
+			b = &bucket{Tokens: burst, Last: now}
+			l.buckets[k] = b
+		}
+		b.Tokens += float64(now.Sub(b.Last)) / float64(every)
+		if b.Tokens > burst {
+			b.Tokens = burst
+		}
+		b.Last = now
+		if b.Tokens < 1 {
+			return false
+		}
+	}
+	for _, k := range keys {
+		l.buckets[k].Tokens--
+	}
+	l.dirty = true
+	return true
+}
+
+// evict deja sitio para nuevas cubetas: descarta las llenas y, si siguen siendo más del 90%
+// del máximo, las de uso más antiguo (hay que llamarla con l.mu bloqueado)
+func (l *limiter) evict(now time.Time) {
+	l.purge(now)
+	if len(l.buckets) <= maxBuckets*9/10 {
+		return
+	}
+	keys := make([]string, 0, len(l.buckets))
+	for k := range l.buckets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return l.buckets[keys[i]].Last.Before(l.buckets[keys[j]].Last) })
+	for _, k := range keys[:len(keys)-maxBuckets*9/10] {
+		delete(l.buckets, k)
+	}
+}
+
+// purge descarta las cubetas ya llenas (equivalen a no tener; con l.mu bloqueado)
+func (l *limiter) purge(now time.Time) {
+	for k, b := range l.buckets {
+		burst, every := rate(k)
+		if b.Tokens+float64(now.Sub(b.Last))/float64(every) >= burst {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// save descarta las cubetas ya llenas (equivalen a no tener) y escribe el estado si ha cambiado
+func (l *limiter) save() error {
+	l.mu.Lock()
+	if !l.dirty {
+		l.mu.Unlock()
+		return nil
+	}
+	l.purge(l.now())
+	data, err := json.Marshal(l.buckets)
+	l.dirty = false
+	l.mu.Unlock()
+
+	if err != nil || l.path == "" {
+		return err
+	}
+	return writeFileAtomic(l.path, data)
+}
+
+// Close detiene el guardado periódico y guarda el estado final
+func (l *limiter) Close() error {
+	close(l.stop)
+	<-l.done
+	return l.save()
+}
+
+// backoff devuelve cuánto tiempo queda bloqueado el login tras n fallos consecutivos
+func backoff(n int) time.Duration {
+	switch {
+	case n < 1:
+		return 0
+	case n >= lockoutAfter:
+		return lockoutTime
+	}
+	d := backoffBase << (n - 1) // exponencial: 1s, 2s, 4s...
+	if d > backoffMax {
+		d = backoffMax
+	}
+	return d
+}
+
+// loginFailed registra un fallo de login en el usuario y lo bloquea durante el tiempo que corresponda
+func loginFailed(u *User, now time.Time) {
+	u.Failures++
+	u.LastFailure = now
+	u.LockedUntil = now.Add(backoff(u.Failures))
+}
+
+// loginOk reinicia el contador de fallos tras un login correcto
+func loginOk(u *User) {
+	u.Failures = 0
+	u.LockedUntil = time.Time{}
+}
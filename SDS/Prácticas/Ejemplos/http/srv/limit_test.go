@@ -0,0 +1,35 @@
+package srv
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestLimiterCap comprueba que los nombres inventados no hacen crecer el limitador sin
+// límite y que no se descartan las cubetas en uso
+func TestLimiterCap(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l, err := newLimiter("", func() time.Time { return now })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for i := 0; i < userBurst; i++ { // agotamos la cubeta de un usuario
+		l.Allow("user:ana")
+	}
+	for i := 0; i < maxBuckets+maxBuckets/2; i++ {
+		if i%1000 == 0 {
+			now = now.Add(time.Millisecond)
+			l.Allow("user:ana") // sigue en uso (y agotada)
+		}
+		l.Allow(fmt.Sprint("user:inventado", i))
+		if len(l.buckets) > maxBuckets {
+			t.Fatalf("%d cubetas tras %d nombres", len(l.buckets), i+1)
+		}
+	}
+	if l.Allow("user:ana") {
+		t.Fatal("la cubeta agotada de un usuario en uso se ha descartado")
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Control de concurrencia por usuario
+*/
+package srv
+
+import (
+	"errors"
+	"sync"
+)
+
+// keyLocks es un conjunto de mutex indexados por clave (nombre de usuario)
+// que se crean bajo demanda y se liberan cuando nadie los usa
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+// keyLock es el mutex de una clave junto con el número de gorutinas que lo usan o esperan
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock bloquea la clave key y devuelve la función para desbloquearla
+func (k *keyLocks) lock(key string) (unlock func()) {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyLock)
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock() // esperamos fuera del mutex global para no bloquear al resto de usuarios
+
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// modify aplica fn sobre el usuario name como una única operación de lectura-modificación-escritura:
+// bloquea al usuario y reintenta si el almacén detecta una modificación concurrente (ErrConflict),
+// lo que puede ocurrir si otro proceso comparte el mismo almacén.
+// Si fn devuelve un error no se escribe nada y se devuelve ese error.
+func (s *server) modify(name string, fn func(u *User) error) (User, error) {
+	unlock := s.locks.lock(name)
+	defer unlock()
+
+	for {
+		u, err := s.users.Get(name)
+		if err != nil {
+			return User{}, err
+		}
+		if err = fn(&u); err != nil {
+			return u, err
+		}
+		if err = s.users.Update(u); !errors.Is(err, ErrConflict) {
+			return u, err
+		}
+	}
+}
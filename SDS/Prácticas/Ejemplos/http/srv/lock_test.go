@@ -0,0 +1,99 @@
+package srv
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentModify lanza cientos de clientes a la vez sobre los mismos usuarios de cada almacén
+// (registro, login, datos, bóveda y logout) y comprueba que no se pierde ninguna escritura
+// (ejecutar con -race)
+func TestConcurrentModify(t *testing.T) {
+	const (
+		users   = 40
+		perUser = userBurst // logins por usuario (sin llegar al límite de intentos)
+	)
+	for _, k := range storeKinds {
+		t.Run(k.kind, func(t *testing.T) {
+			store, _ := openTestStore(t, k.kind, k.file)
+			s := newTestServer(t, store)
+			name := func(i int) string { return fmt.Sprintf("u%02d", i) }
+
+			parallel(t, users, func(i int) error {
+				in := registerIn{User: name(i), Pass: []byte("clave de " + name(i)), PubKey: "pub", PriKey: "pri"}
+				_, err := s.register(in, fmt.Sprintf("10.1.0.%d", i))
+				return err
+			})
+			parallel(t, users*perUser, func(w int) error {
+				return stressClient(s, name(w%users), w/users, fmt.Sprintf("10.2.%d.%d", w/256, w%256))
+			})
+
+			loggedOut := (perUser + 1) / 2 // los clientes pares cierran su sesión
+			for i := 0; i < users; i++ {
+				u, err := store.Get(name(i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := len(u.Sessions), 1+perUser-loggedOut; got != want {
+					t.Errorf("%s: %d sesiones, se esperaban %d", u.Name, got, want)
+				}
+				if got, want := len(u.Data), len(reservedData)+perUser; got != want {
+					t.Errorf("%s: %d datos, se esperaban %d", u.Name, got, want)
+				}
+				if got := len(u.Vault); got != perUser {
+					t.Errorf("%s: %d entradas, se esperaban %d", u.Name, got, perUser)
+				}
+			}
+		})
+	}
+}
+
+// parallel ejecuta fn(0)...fn(n-1) a la vez y falla con el primer error
+func parallel(t *testing.T, n int, fn func(i int) error) {
+	t.Helper()
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- fn(i)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// stressClient es un cliente que hace login, usa su sesión para escribir un dato y una entrada
+// propios y, si j es par, cierra la sesión (su token deja de valer)
+func stressClient(s *server, name string, j int, ip string) error {
+	token, err := s.login(loginIn{User: name, Pass: []byte("clave de " + name)}, ip)
+	if err != nil {
+		return fmt.Errorf("login(%s): %w", name, err)
+	}
+	_, c, err := s.authorize(token, ip, "prueba")
+	if err != nil {
+		return fmt.Errorf("authorize(%s): %w", name, err)
+	}
+	if _, err = s.putData(c, map[string]string{fmt.Sprint("k", j): "v"}); err != nil {
+		return fmt.Errorf("putData(%s): %w", name, err)
+	}
+	if _, _, err = s.vaultPut(c, fmt.Sprint("e", j), vaultIn{Blob: []byte{1}, Key: []byte{2}}); err != nil {
+		return fmt.Errorf("vaultPut(%s): %w", name, err)
+	}
+	if j%2 == 0 {
+		if err = s.logout(c, ""); err != nil {
+			return fmt.Errorf("logout(%s): %w", name, err)
+		}
+		if _, _, err = s.authorize(token, ip, "prueba"); err != ErrUnauthorized {
+			return fmt.Errorf("%s: token aceptado tras logout (%v)", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+/*
+Métricas del servidor (formato de Prometheus, ver sdshttp/metrics)
+
+se publican en /metrics sólo si se configura metrics_addr, en un puerto de administración aparte
+sin TLS (pensado para escuchar en localhost o en la red interna; nunca en el puerto de la API);
+son agregados (ningún nombre de usuario ni dato de la petición va en las etiquetas)
+*/
+package srv
+
+import (
+	"math"
+	"net/http"
+	"sdshttp/metrics"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// métricas del servidor (en el registro del programa)
+var (
+	requestsTotal = metrics.Default.Counter("sdshttp_requests_total",
+		"Peticiones atendidas por comando y código de estado", "command", "code")
+	requestSeconds = metrics.Default.Histogram("sdshttp_request_duration_seconds",
+		"Duración de las peticiones por comando (segundos)", nil, "command")
+	loginsTotal = metrics.Default.Counter("sdshttp_logins_total",
+		"Intentos de login por resultado (ok o failed)", "result")
+	hashSeconds = metrics.Default.Histogram("sdshttp_password_hash_seconds",
+		"Tiempo de cálculo de los hashes de contraseña por algoritmo (segundos)", nil, "algorithm")
+	panicsTotal = metrics.Default.Counter("sdshttp_panics_total",
+		"Pánicos recuperados en los handlers")
+)
+
+// statsEvery es lo que se reutiliza un recuento de usuarios y sesiones (recorrer el almacén
+// es caro y cada lectura de las métricas pide los dos indicadores)
+const statsEvery = 5 * time.Second
+
+// userStats guarda el último recuento de usuarios y sesiones sin expirar
+type userStats struct {
+	mu       sync.Mutex
+	at       time.Time // instante del recuento (cero: ninguno)
+	users    float64   // NaN si falló la lectura del almacén
+	sessions float64
+}
+
+// registerMetrics registra las métricas que dependen del estado del servidor
+// (se calculan al leerlas, con un recuento del almacén de usuarios de hace menos de statsEvery)
+func (s *server) registerMetrics() {
+	metrics.RegisterRuntime(metrics.Default)
+	metrics.Default.GaugeFunc("sdshttp_users", "Usuarios registrados", func() float64 {
+		users, _ := s.userStats()
+		return users
+	})
+	metrics.Default.GaugeFunc("sdshttp_active_sessions", "Sesiones sin expirar", func() float64 {
+		_, sessions := s.userStats()
+		return sessions
+	})
+}
+
+// userStats cuenta los usuarios y sus sesiones sin expirar en una sola pasada por el almacén,
+// o devuelve el recuento anterior si es de hace menos de statsEvery (NaN si falla el almacén)
+func (s *server) userStats() (users, sessions float64) {
+	st := &s.stats
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := s.now()
+	if !st.at.IsZero() && now.Sub(st.at) < statsEvery {
+		return st.users, st.sessions
+	}
+	st.at, st.users, st.sessions = now, math.NaN(), math.NaN()
+	l, err := s.users.List()
+	if err != nil {
+		return st.users, st.sessions
+	}
+	n := 0
+	for _, u := range l {
+		for _, sess := range u.Sessions {
+			if now.Before(sess.Expires) {
+				n++
+			}
+		}
+	}
+	st.users, st.sessions = float64(len(l)), float64(n)
+	return st.users, st.sessions
+}
+
+// statusRecorder guarda el código de estado de una respuesta
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap da acceso al ResponseWriter original (para http.ResponseController)
+func (r *statusRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+// instrument cuenta las peticiones de un handler y mide su duración con la etiqueta command
+// (vacía en el endpoint antiguo: se toma del comando del formulario, ver legacyCommand)
+func (s *server) instrument(command string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, req)
+		cmd := command
+		if cmd == "" {
+			cmd = legacyCommand(req)
+		}
+		requestsTotal.Inc(cmd, strconv.Itoa(rec.status))
+		requestSeconds.ObserveSince(start, cmd)
+	}
+}
+
+// legacyCommand es la etiqueta de una petición al endpoint antiguo (los comandos
+// desconocidos van juntos para no crear una serie por cada valor que envíe un cliente)
+func legacyCommand(req *http.Request) string {
+	cmd := req.Form.Get("cmd")
+	if _, ok := legacyAccess[cmd]; !ok {
+		return "legacy"
+	}
+	return "legacy " + cmd
+}
@@ -0,0 +1,77 @@
+package srv
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sdshttp/metrics"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestInstrumentPanic comprueba que una petición que entra en pánico cuenta en las métricas con su 500
+func TestInstrumentPanic(t *testing.T) {
+	store, _ := openTestStore(t, "memory", "")
+	s := newTestServer(t, store)
+	log.SetOutput(io.Discard) // el pánico se registra con su traza
+	defer log.SetOutput(os.Stderr)
+
+	h := s.instrument("GET /prueba-panico", s.recoverer(func(http.ResponseWriter, *http.Request) {
+		panic("prueba")
+	}))
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/prueba-panico", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("código = %d, se esperaba 500", rec.Code)
+	}
+
+	var out bytes.Buffer
+	if _, err := metrics.Default.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	if want := `sdshttp_requests_total{command="GET /prueba-panico",code="500"} 1`; !strings.Contains(out.String(), want) {
+		t.Fatalf("falta %s en las métricas:\n%s", want, out.String())
+	}
+}
+
+// listCounter cuenta las llamadas a List de un almacén
+type listCounter struct {
+	UserStore
+	lists int
+}
+
+func (c *listCounter) List() ([]User, error) {
+	c.lists++
+	return c.UserStore.List()
+}
+
+// TestUserStats comprueba que los dos indicadores salen de un único recorrido del almacén,
+// reutilizado durante statsEvery
+func TestUserStats(t *testing.T) {
+	store, _ := openTestStore(t, "memory", "")
+	lc := &listCounter{UserStore: store}
+	s := newTestServer(t, lc)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	for _, name := range []string{"ana", "eva"} {
+		if _, err := s.register(registerIn{User: name, Pass: []byte("clave"), PubKey: "pub", PriKey: "pri"}, "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := func(users, sessions float64, lists int) {
+		t.Helper()
+		u, n := s.userStats()
+		if u != users || n != sessions || lc.lists != lists {
+			t.Fatalf("userStats = %v, %v con %d List; se esperaba %v, %v con %d", u, n, lc.lists, users, sessions, lists)
+		}
+	}
+	stats(2, 2, 1)
+	stats(2, 2, 1) // el mismo recuento
+	now = now.Add(statsEvery)
+	stats(2, 2, 2)
+}
@@ -0,0 +1,458 @@
+/*
+Operaciones del servidor, independientes del transporte
+(las usan tanto la API REST como el endpoint antiguo con el campo cmd)
+*/
+package srv
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// APIError es un error de la API con su código HTTP y un código legible por máquina
+// (el mensaje es el que se ha devuelto siempre al cliente en Resp.Msg)
+type APIError struct {
+	Status int    // código de estado HTTP
+	Code   string // código de error (Resp.Code)
+	Msg    string // mensaje para el usuario
+}
+
+func (e *APIError) Error() string { return e.Msg }
+
+// errores de la API
+var (
+	ErrBadRequest      = &APIError{http.StatusBadRequest, "bad_request", "Petición inválida"}
+	ErrUnauthorized    = &APIError{http.StatusUnauthorized, "unauthorized", "No autentificado"}
+	ErrBadCredentials  = &APIError{http.StatusUnauthorized, "invalid_credentials", "Credenciales inválidas"}
+	ErrTOTPRequired    = &APIError{http.StatusUnauthorized, "totp_required", "Código TOTP requerido"}
+	ErrTOTPInvalid     = &APIError{http.StatusUnauthorized, "totp_invalid", "Código TOTP inválido"}
+	ErrUserExists      = &APIError{http.StatusConflict, "user_exists", "Usuario ya registrado"}
+	ErrTOTPEnabled     = &APIError{http.StatusConflict, "totp_enabled", "TOTP ya activado"}
+	ErrTOTPNotPending  = &APIError{http.StatusConflict, "totp_not_pending", "No hay ningún alta de TOTP pendiente"}
+	ErrSessionNotFound = &APIError{http.StatusNotFound, "session_not_found", "Sesión inexistente"}
+	ErrRateLimited     = &APIError{http.StatusTooManyRequests, "rate_limited", "Demasiados intentos, inténtelo más tarde"}
+	ErrLockedOut       = &APIError{http.StatusTooManyRequests, "locked_out", "Demasiados intentos fallidos, inténtelo más tarde"}
+	ErrUnknownCommand  = &APIError{http.StatusNotFound, "unknown_command", "Comando no implementado"}
+	ErrInternal        = &APIError{http.StatusInternalServerError, "internal", "Error interno"}
+)
+
+// apiError convierte cualquier error de las operaciones en un APIError
+// (los errores inesperados se registran y se ocultan al cliente)
+func apiError(err error) *APIError {
+	var ae *APIError
+	if errors.As(err, &ae) {
+		return ae
+	}
+	log.Println("error interno:", err)
+	return ErrInternal
+}
+
+// claves de User.Data que gestiona el servidor y no se pueden modificar con putData
+var reservedData = map[string]bool{"private": true, "public": true, "keyalg": true}
+
+// registerIn son los datos de un registro
+type registerIn struct {
+	User   string `json:"user"`
+	Pass   []byte `json:"pass"`   // keyLogin (base64 en JSON)
+	PubKey string `json:"pubkey"` // clave pública (PKIX, o JSON comprimido en el formato antiguo; base64)
+	PriKey string `json:"prikey"` // clave privada cifrada con keyData (base64)
+	KeyAlg string `json:"keyalg"` // algoritmo de las claves (ver KeyAlgRSA...; vacío en el formato antiguo)
+	Device string `json:"device"` // etiqueta del dispositivo (opcional)
+}
+
+// loginIn son los datos de un login
+type loginIn struct {
+	User     string `json:"user"`
+	Pass     []byte `json:"pass"`     // keyLogin (base64 en JSON)
+	Code     string `json:"code"`     // código TOTP (si está activado)
+	Recovery string `json:"recovery"` // código de recuperación (en lugar del TOTP)
+	Device   string `json:"device"`   // etiqueta del dispositivo (opcional)
+}
+
+// passwordIn son los datos de un cambio de contraseña
+type passwordIn struct {
+	Pass     []byte              `json:"pass"`     // keyLogin actual
+	New      []byte              `json:"new"`      // keyLogin nueva
+	PriKey   string              `json:"prikey"`   // clave privada cifrada de nuevo con la nueva keyData
+	Vault    map[string]rewrapIn `json:"vault"`    // claves de todas las entradas de la bóveda, cifradas de nuevo
+	Recovery *recoveryIn         `json:"recovery"` // datos de recuperación cifrados de nuevo (si está configurada)
+}
+
+// rewrapIn es la clave de una entrada de la bóveda cifrada de nuevo por el cliente
+type rewrapIn struct {
+	Key         []byte            `json:"key"`
+	Version     uint64            `json:"version"`               // versión de la entrada que leyó el cliente
+	Attachments map[string][]byte `json:"attachments,omitempty"` // claves de todos sus adjuntos, cifradas de nuevo
+}
+
+// register da de alta un usuario y abre su primera sesión
+func (s *server) register(in registerIn, ip string) (token []byte, err error) {
+	defer func() { s.record("register", in.User, ip, "", err, "") }()
+	if !validUserName(in.User) || !validBytes(in.Pass, maxPass) || !validDevice(in.Device) ||
+		in.PubKey == "" || in.PriKey == "" || len(in.PubKey) > maxUserKey || len(in.PriKey) > maxUserKey ||
+		!validText(in.KeyAlg, maxKeyAlg) {
+		in.User = truncate(in.User, maxUserName) // para el registro de auditoría
+		return nil, ErrBadRequest
+	}
+	if err = checkPublicKey(in.KeyAlg, in.PubKey); err != nil {
+		return nil, err
+	}
+	if in.KeyAlg == "" {
+		in.KeyAlg = KeyAlgRSAJSON
+	}
+
+	u := User{}
+	u.Name = in.User                 // nombre
+	u.Data = make(map[string]string) // reservamos mapa de datos de usuario
+	u.Data["private"] = in.PriKey    // clave privada
+	u.Data["public"] = in.PubKey     // clave pública
+	u.Data["keyalg"] = in.KeyAlg     // algoritmo de las claves
+
+	// "hasheamos" la contraseña con Argon2id (la sal va incluida en el hash codificado)
+	if u.PassHash, err = hashPassword(in.Pass); err != nil {
+		return nil, err
+	}
+
+	token, c, err := s.tokens.Issue(u.Name, scopeData) // token firmado para la primera sesión
+	if err != nil {
+		return nil, err
+	}
+	u.Seen = s.now() // asignamos tiempo de login
+	addSession(&u, newSession(c, in.Device, ip), u.Seen)
+
+	if err = s.users.Put(u); errors.Is(err, ErrExists) { // falla si el usuario ya existe
+		return nil, ErrUserExists
+	}
+	return token, err
+}
+
+// login comprueba las credenciales (y el segundo factor) y abre una sesión nueva
+// (las sesiones anteriores siguen abiertas)
+func (s *server) login(in loginIn, ip string) (token []byte, err error) {
+	defer func() {
+		event, result := "login", "ok"
+		if err != nil {
+			event, result = "login_failed", "failed"
+		}
+		loginsTotal.Inc(result)
+		s.record(event, in.User, ip, "", err, in.Device)
+	}()
+
+	// limitamos la frecuencia de intentos por usuario y por IP
+	if in.User == "" || !validText(in.User, maxUserName) || !validBytes(in.Pass, maxPass) ||
+		!validText(in.Code, maxCode) || !validText(in.Recovery, maxCode) || !validDevice(in.Device) {
+		in.User, in.Device = truncate(in.User, maxUserName), truncate(in.Device, maxDevice)
+		return nil, ErrBadRequest
+	}
+	if !s.limits.Allow("user:"+in.User, "ip:"+ip) {
+		return nil, ErrRateLimited
+	}
+
+	// comprobación (y rehash si está desactualizado) y alta de la nueva sesión
+	// en una única operación sobre el usuario
+	var failure error // fallo de autentificación (se registra en el usuario)
+	_, err = s.modify(in.User, func(u *User) error {
+		failure = nil
+		if s.now().Before(u.LockedUntil) { // bloqueada: igual que un usuario inexistente (no revela si la cuenta existe)
+			spendHash(in.Pass)
+			return ErrBadCredentials
+		}
+
+		if ok, err := checkPassword(u, in.Pass); err != nil {
+			return err
+		} else if !ok {
+			failure = ErrBadCredentials
+		} else if err := checkSecondFactor(u, in.Code, in.Recovery, s.now()); err == ErrTOTPRequired {
+			return err // falta el código: no es un fallo
+		} else if err != nil {
+			failure = err
+		}
+		if failure != nil {
+			loginFailed(u, s.now())
+			return nil // guardamos el fallo
+		}
+		loginOk(u)
+		if u.Disabled { // credenciales correctas, pero la cuenta no se puede usar
+			return ErrAccountDisabled
+		}
+
+		var c Claims
+		var err error
+		if token, c, err = s.tokens.Issue(u.Name, scopeData); err != nil { // token firmado para la sesión
+			return err
+		}
+		u.Seen = s.now() // asignamos tiempo de login
+		addSession(u, newSession(c, in.Device, ip), u.Seen)
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) { // la misma respuesta (y el mismo tiempo) que con una contraseña incorrecta
+		spendHash(in.Pass)
+		return nil, ErrBadCredentials
+	} else if err != nil {
+		return nil, err
+	}
+	return token, failure
+}
+
+// checkSecondFactor exige el código TOTP (o uno de recuperación) si el usuario lo tiene activado
+func checkSecondFactor(u *User, code, recovery string, now time.Time) error {
+	switch {
+	case u.TOTP == nil || !u.TOTP.Enabled:
+		return nil
+	case code != "":
+		if !u.TOTP.Verify(code, now) {
+			return ErrTOTPInvalid
+		}
+	case recovery != "":
+		if !u.TOTP.UseRecovery(recovery) {
+			return ErrTOTPInvalid
+		}
+	default:
+		return ErrTOTPRequired
+	}
+	return nil
+}
+
+// seenEvery es cada cuánto se guarda, como mucho, la última petición de una sesión
+// (guardarla en cada petición reescribiría el almacén con cada lectura)
+const seenEvery = time.Minute
+
+// authorize valida un token (sin consultar el almacén) y comprueba en el almacén que su sesión
+// sigue abierta; sólo escribe en él para actualizar cuándo se vio la sesión si hace más de
+// seenEvery de la última vez; el acceso al recurso (o el rechazo del token) queda en el
+// registro de auditoría
+func (s *server) authorize(token []byte, ip, resource string) (User, Claims, error) {
+	c, err := s.tokens.Verify(token)
+	if err != nil || !c.HasScope(scopeData) {
+		s.record("token_rejected", c.Sub, ip, c.Sid, ErrUnauthorized, resource)
+		return User{}, c, ErrUnauthorized
+	}
+	u, err := s.users.Get(c.Sub)
+	if err == nil {
+		err = checkSession(u, c)
+	}
+	if err == nil && s.now().Sub(u.Sessions[c.Sid].Seen) >= seenEvery {
+		u, err = s.modify(c.Sub, func(u *User) error {
+			if err := checkSession(*u, c); err != nil { // ha podido cerrarse entretanto
+				return err
+			}
+			sess := u.Sessions[c.Sid]
+			sess.Seen = s.now()
+			u.Sessions[c.Sid] = sess
+			return nil
+		})
+	}
+	if errors.Is(err, ErrNotFound) {
+		err = ErrUnauthorized
+	}
+	event := "access"
+	if err != nil {
+		event = "token_rejected"
+	}
+	s.record(event, c.Sub, ip, c.Sid, err, resource)
+	return u, c, err
+}
+
+// checkSession comprueba que la sesión del token sigue abierta y la cuenta habilitada
+func checkSession(u User, c Claims) error {
+	if _, ok := u.Sessions[c.Sid]; !ok {
+		return ErrUnauthorized // sesión cerrada desde otro dispositivo
+	}
+	if u.Disabled {
+		return ErrAccountDisabled
+	}
+	return nil
+}
+
+// putData añade o reemplaza datos del usuario (salvo las claves reservadas) y devuelve todos
+func (s *server) putData(c Claims, data map[string]string) (map[string]string, error) {
+	if len(data) > maxDataKeys {
+		return nil, ErrBadRequest
+	}
+	for k, v := range data {
+		if k == "" || reservedData[k] || !validText(k, maxDataKey) || !validText(v, maxDataValue) {
+			return nil, ErrBadRequest
+		}
+	}
+	u, err := s.modify(c.Sub, func(u *User) error {
+		if u.Data == nil {
+			u.Data = make(map[string]string)
+		}
+		for k, v := range data {
+			u.Data[k] = v
+		}
+		return nil
+	})
+	return u.Data, err
+}
+
+// changePassword cambia la contraseña tras comprobar la actual y guarda la clave privada
+// y las claves de la bóveda que el cliente ha vuelto a cifrar con la nueva clave de datos,
+// todo en una única modificación del usuario (si la bóveda ha cambiado mientras tanto, no se
+// cambia nada: quedarían claves ilegibles); cierra las demás sesiones y devuelve cuántas eran
+func (s *server) changePassword(c Claims, in passwordIn) (n int, err error) {
+	defer func() { s.record("password_change", c.Sub, "", c.Sid, err, "") }()
+	if !validBytes(in.Pass, maxPass) || !validPasswordIn(in) {
+		return 0, ErrBadRequest
+	}
+	if !s.limits.Allow("user:" + c.Sub) { // la contraseña actual también se puede adivinar por aquí
+		return 0, ErrRateLimited
+	}
+
+	hash, err := hashPassword(in.New) // fuera del bloqueo del usuario (es lento)
+	if err != nil {
+		return 0, err
+	}
+	var closed []Session
+	var failure error // contraseña actual incorrecta (cuenta como un login fallido)
+	_, err = s.modify(c.Sub, func(u *User) error {
+		closed, failure = nil, nil
+		if s.now().Before(u.LockedUntil) {
+			return ErrLockedOut
+		}
+		if ok, err := checkPassword(u, in.Pass); err != nil {
+			return err
+		} else if !ok {
+			failure = ErrBadCredentials
+			loginFailed(u, s.now())
+			return nil // guardamos el fallo
+		}
+		if err := applyPassword(u, hash, in); err != nil {
+			return err
+		}
+		for id, sess := range u.Sessions { // sólo queda abierta la sesión que cambia la contraseña
+			if id != c.Sid {
+				closed = append(closed, sess)
+				delete(u.Sessions, id)
+			}
+		}
+		loginOk(u)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, sess := range closed {
+		s.tokens.Revoke(sess.ID, sess.Expires)
+	}
+	return len(closed), failure
+}
+
+// applyPassword reemplaza el hash de la contraseña y todo lo cifrado con keyData
+// (comprueba que el cliente ha vuelto a cifrar todas las claves de la bóveda en su versión
+// actual y los datos de recuperación, si los hay; si no, quedarían datos ilegibles)
+func applyPassword(u *User, hash string, in passwordIn) error {
+	if len(in.Vault) != len(u.Vault) {
+		return ErrVersionConflict
+	}
+	for name, e := range u.Vault {
+		r, ok := in.Vault[name]
+		if !ok || r.Version != e.Version || len(r.Key) == 0 || len(r.Attachments) != len(e.Attachments) {
+			return ErrVersionConflict
+		}
+		for att := range e.Attachments {
+			if len(r.Attachments[att]) == 0 {
+				return ErrVersionConflict // subido mientras el cliente cifraba de nuevo
+			}
+		}
+	}
+	if u.Recovery != nil && (in.Recovery == nil || len(in.Recovery.Escrow) == 0 || len(in.Recovery.Secret) == 0) {
+		return ErrVersionConflict
+	}
+
+	u.PassHash, u.Hash, u.Salt = hash, nil, nil
+	u.Data["private"] = in.PriKey
+	for name, r := range in.Vault {
+		e := u.Vault[name]
+		e.Key = r.Key
+		for att, key := range r.Attachments {
+			a := e.Attachments[att]
+			a.Key = key
+			e.Attachments[att] = a
+		}
+		u.Vault[name] = e
+	}
+	if u.Recovery != nil {
+		u.Recovery.Escrow, u.Recovery.Secret = in.Recovery.Escrow, in.Recovery.Secret
+	}
+	return nil
+}
+
+// logout cierra la sesión sid del usuario (la actual si sid está vacío)
+func (s *server) logout(c Claims, sid string) (err error) {
+	defer func() { s.record("logout", c.Sub, "", c.Sid, err, sid) }()
+	if sid == "" {
+		sid = c.Sid
+	}
+	var closed Session
+	_, err = s.modify(c.Sub, func(u *User) error {
+		var ok bool
+		if closed, ok = u.Sessions[sid]; !ok {
+			return ErrSessionNotFound
+		}
+		delete(u.Sessions, sid)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.tokens.Revoke(closed.ID, closed.Expires) // el token deja de ser válido aunque no haya expirado
+	return nil
+}
+
+// logoutAll cierra todas las sesiones del usuario (incluida la actual) y devuelve cuántas eran
+func (s *server) logoutAll(c Claims) (n int, err error) {
+	defer func() { s.record("logout_all", c.Sub, "", c.Sid, err, "") }()
+	var closed map[string]Session
+	_, err = s.modify(c.Sub, func(u *User) error {
+		closed, u.Sessions = u.Sessions, nil
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, sess := range closed {
+		s.tokens.Revoke(sess.ID, sess.Expires)
+	}
+	return len(closed), nil
+}
+
+// totpSetup genera un secreto TOTP pendiente de confirmar y devuelve su URI otpauth://
+func (s *server) totpSetup(c Claims) (uri string, err error) {
+	_, err = s.modify(c.Sub, func(u *User) error {
+		if u.TOTP != nil && u.TOTP.Enabled {
+			return ErrTOTPEnabled
+		}
+		var err error
+		if u.TOTP, err = newTOTP(); err != nil {
+			return err
+		}
+		uri = u.TOTP.URI(u.Name)
+		return nil
+	})
+	return
+}
+
+// totpConfirm activa el TOTP pendiente con un primer código y devuelve los códigos
+// de recuperación (sólo se muestran esta vez)
+func (s *server) totpConfirm(c Claims, code string) (codes []string, err error) {
+	defer func() { s.record("totp_enabled", c.Sub, "", c.Sid, err, "") }()
+	if code == "" || !validText(code, maxCode) {
+		return nil, ErrBadRequest
+	}
+	_, err = s.modify(c.Sub, func(u *User) error {
+		if u.TOTP == nil || u.TOTP.Enabled {
+			return ErrTOTPNotPending
+		}
+		if !u.TOTP.Verify(code, s.now()) {
+			return ErrTOTPInvalid
+		}
+		u.TOTP.Enabled = true
+		var err error
+		codes, err = u.TOTP.newRecoveryCodes()
+		return err
+	})
+	return
+}
@@ -0,0 +1,57 @@
+package srv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLoginUnknownUser comprueba que un usuario inexistente recibe la misma respuesta que
+// una contraseña incorrecta (en el login y al iniciar una recuperación), también en intentos
+// seguidos en los que la cuenta existente ya está en la espera tras el fallo
+func TestLoginUnknownUser(t *testing.T) {
+	store, _ := openTestStore(t, "memory", "")
+	s := newTestServer(t, store)
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now } // el reloj no avanza: todos los intentos son seguidos
+	in := registerIn{User: "ana", Pass: []byte("clave"), PubKey: "pub", PriKey: "pri"}
+	if _, err := s.register(in, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	mux := s.routes()
+
+	// respuestas (código de estado y de error) a una serie de intentos con el usuario name
+	// (cada uno desde su IP, para que el límite por IP no distinga a uno del otro)
+	attempts := func(name, ip string) []string {
+		var out []string
+		for i, path := range []string{"/v1/sessions", "/v1/sessions", "/v1/sessions", "/v1/recovery", "/v1/recovery", "/v1/sessions", "/v1/sessions"} {
+			body := `{"user":"` + name + `","pass":"b3RyYQ=="}`
+			if path == "/v1/recovery" {
+				body = `{"user":"` + name + `","auth":"b3RyYQ=="}`
+			}
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+			req.RemoteAddr = ip + ":1234"
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			var r Resp
+			if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+				t.Fatalf("%s, intento %d: %v", name, i, err)
+			}
+			out = append(out, http.StatusText(rec.Code)+" "+r.Code)
+		}
+		return out
+	}
+
+	known, unknown := attempts("ana", "10.0.0.2"), attempts("nadie", "10.0.0.3")
+	if known[0] != "Unauthorized "+ErrBadCredentials.Code {
+		t.Fatalf("primer intento = %s, se esperaba invalid_credentials", known[0])
+	}
+	for i := range known {
+		if known[i] != unknown[i] {
+			t.Errorf("intento %d: usuario existente %q, inexistente %q", i, known[i], unknown[i])
+		}
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Hash de contraseñas (keyLogin) en el servidor
+*/
+package srv
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// argon2Params son los parámetros de Argon2id que se guardan junto a cada hash
+type argon2Params struct {
+	Time    uint32 // número de pasadas
+	Memory  uint32 // memoria en KiB
+	Threads uint8  // paralelismo
+	KeyLen  uint32 // longitud del hash en bytes
+	SaltLen uint32 // longitud de la sal en bytes
+}
+
+// política actual para los hashes nuevos (recomendación de OWASP para Argon2id)
+// al endurecerla, los hashes con parámetros antiguos se rehacen en el siguiente login
+var hashPolicy = argon2Params{Time: 1, Memory: 46 * 1024, Threads: 1, KeyLen: 32, SaltLen: 16}
+
+// errInvalidHash indica un hash codificado mal formado (no debería ocurrir salvo corrupción)
+var errInvalidHash = errors.New("hash de contraseña mal formado")
+
+// b64 es la codificación de sal y hash en el formato PHC (base64 sin relleno)
+var b64 = base64.RawStdEncoding
+
+// hashPassword obtiene el hash codificado de una contraseña con la política actual:
+// $argon2id$v=19$m=<memoria>,t=<pasadas>,p=<hilos>$<sal>$<hash>
+func hashPassword(password []byte) (string, error) {
+	p := hashPolicy
+	salt := make([]byte, p.SaltLen) // la sal es aleatoria
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	start := time.Now()
+	hash := argon2.IDKey(password, salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	hashSeconds.ObserveSince(start, "argon2id")
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads, b64.EncodeToString(salt), b64.EncodeToString(hash)), nil
+}
+
+// encodeScrypt codifica un hash scrypt antiguo (N=16384, r=8, p=1) en el mismo formato
+// para poder verificarlo y migrarlo: $scrypt$ln=14,r=8,p=1$<sal>$<hash>
+func encodeScrypt(hash, salt []byte) string {
+	return fmt.Sprintf("$scrypt$ln=14,r=8,p=1$%s$%s", b64.EncodeToString(salt), b64.EncodeToString(hash))
+}
+
+// verifyPassword comprueba una contraseña contra su hash codificado (en tiempo constante)
+// rehash indica que la contraseña es correcta pero el hash no sigue la política actual
+func verifyPassword(password []byte, encoded string) (ok, rehash bool, err error) {
+	parts := strings.Split(encoded, "$") // "", algoritmo, [versión,] parámetros, sal, hash
+	var hash, salt, want []byte
+
+	switch {
+	case len(parts) == 6 && parts[1] == "argon2id":
+		var v int
+		var p argon2Params
+		if _, err = fmt.Sscanf(parts[2], "v=%d", &v); err != nil || v != argon2.Version {
+			return false, false, errInvalidHash
+		}
+		if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+			return false, false, errInvalidHash
+		}
+		if salt, err = b64.DecodeString(parts[4]); err != nil {
+			return false, false, errInvalidHash
+		}
+		if want, err = b64.DecodeString(parts[5]); err != nil {
+			return false, false, errInvalidHash
+		}
+		p.SaltLen, p.KeyLen = uint32(len(salt)), uint32(len(want))
+		start := time.Now()
+		hash = argon2.IDKey(password, salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+		hashSeconds.ObserveSince(start, "argon2id")
+		rehash = p != hashPolicy
+
+	case len(parts) == 5 && parts[1] == "scrypt":
+		var ln, r, par int
+		if _, err = fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &ln, &r, &par); err != nil || ln < 1 || ln > 30 {
+			return false, false, errInvalidHash
+		}
+		if salt, err = b64.DecodeString(parts[3]); err != nil {
+			return false, false, errInvalidHash
+		}
+		if want, err = b64.DecodeString(parts[4]); err != nil {
+			return false, false, errInvalidHash
+		}
+		start := time.Now()
+		if hash, err = scrypt.Key(password, salt, 1<<ln, r, par, len(want)); err != nil {
+			return false, false, err
+		}
+		hashSeconds.ObserveSince(start, "scrypt")
+		rehash = true // scrypt siempre se migra a Argon2id
+
+	default:
+		return false, false, errInvalidHash
+	}
+
+	ok = subtle.ConstantTimeCompare(hash, want) == 1
+	return ok, ok && rehash, nil
+}
+
+// dummyHash es el hash con el que se verifica la contraseña de un usuario inexistente
+// (se calcula al primer uso, con la política actual)
+var dummyHash = sync.OnceValue(func() string {
+	h, err := hashPassword([]byte("sdshttp: usuario inexistente"))
+	chk(err)
+	return h
+})
+
+// spendHash gasta el mismo tiempo que la verificación de una contraseña, para que la
+// respuesta a un usuario inexistente no se distinga por el tiempo de la de uno existente
+func spendHash(password []byte) {
+	verifyPassword(password, dummyHash())
+}
+
+// checkPassword verifica la contraseña de un usuario y, si es correcta pero el hash está
+// desactualizado (scrypt o parámetros antiguos), lo rehace con la política actual en u
+func checkPassword(u *User, password []byte) (bool, error) {
+	encoded := u.PassHash
+	if encoded == "" && u.Hash != nil { // usuario anterior a Argon2id: hash scrypt en Hash y Salt
+		encoded = encodeScrypt(u.Hash, u.Salt)
+	}
+
+	ok, rehash, err := verifyPassword(password, encoded)
+	if err != nil || !ok {
+		return false, err
+	}
+	if rehash {
+		if u.PassHash, err = hashPassword(password); err != nil {
+			return false, err
+		}
+		u.Hash, u.Salt = nil, nil // ya no necesitamos el hash antiguo
+	}
+	return true, nil
+}
@@ -0,0 +1,193 @@
+/*
+Recuperación de la cuenta con un secreto de recuperación (una clave imprimible o varias
+partes de Shamir que genera el cliente)
+
+del secreto R el cliente deriva una clave de autentificación (cuyo hash guarda el servidor,
+como con la contraseña) y una clave con la que cifra keyData (Escrow); además guarda R cifrado
+con keyData para poder rehacer Escrow al cambiar la contraseña sin volver a pedir R
+*/
+package srv
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// permiso de los tokens de recuperación (sólo permiten fijar una contraseña nueva) y su duración
+const (
+	scopeRecover = "recover"
+	recoverTTL   = 10 * time.Minute
+)
+
+// errores de la recuperación
+var ErrNoRecovery = &APIError{http.StatusNotFound, "recovery_not_set", "La cuenta no tiene recuperación configurada"}
+
+// Recovery son los datos de recuperación de la cuenta
+type Recovery struct {
+	Verifier string    // hash (Argon2id) de la clave de autentificación derivada de R
+	Escrow   []byte    // keyData cifrada con la clave derivada de R (opaca)
+	Secret   []byte    // R cifrado con keyData (opaco)
+	Created  time.Time // alta de la recuperación
+	Used     string    `json:",omitempty"` // último token de recuperación usado (son de un solo uso)
+}
+
+// recoveryIn son los datos de recuperación que envía el cliente
+// (Auth sólo al configurarla; al cambiar la contraseña basta con Escrow y Secret)
+type recoveryIn struct {
+	Auth   []byte `json:"auth,omitempty"` // clave de autentificación derivada de R
+	Escrow []byte `json:"escrow"`         // keyData cifrada con la clave derivada de R
+	Secret []byte `json:"secret"`         // R cifrado con keyData
+}
+
+// recoverIn son los datos para iniciar una recuperación
+type recoverIn struct {
+	User string `json:"user"`
+	Auth []byte `json:"auth"` // clave de autentificación derivada de R
+}
+
+// recoverOut es lo que necesita el cliente para volver a cifrar todo con la contraseña nueva
+type recoverOut struct {
+	Escrow []byte       // keyData cifrada con la clave derivada de R
+	PriKey string       // clave privada cifrada con keyData
+	Vault  []VaultEntry // entradas de la bóveda con su clave cifrada (sin contenido)
+}
+
+// setupRecovery configura (o reemplaza) la recuperación de la cuenta
+func (s *server) setupRecovery(c Claims, in recoveryIn) (err error) {
+	defer func() { s.record("recovery_setup", c.Sub, "", c.Sid, err, "") }()
+	if !validBytes(in.Auth, maxPass) || !validBytes(in.Escrow, maxWrapped) || !validBytes(in.Secret, maxWrapped) {
+		return ErrBadRequest
+	}
+	verifier, err := hashPassword(in.Auth)
+	if err != nil {
+		return err
+	}
+	_, err = s.modify(c.Sub, func(u *User) error {
+		u.Recovery = &Recovery{Verifier: verifier, Escrow: in.Escrow, Secret: in.Secret, Created: s.now()}
+		return nil
+	})
+	return err
+}
+
+// recoveryGet devuelve los datos de recuperación que el cliente tiene que volver a cifrar
+// al cambiar la contraseña (sin el verificador)
+func recoveryGet(u User) (recoveryIn, error) {
+	if u.Recovery == nil {
+		return recoveryIn{}, ErrNoRecovery
+	}
+	return recoveryIn{Escrow: u.Recovery.Escrow, Secret: u.Recovery.Secret}, nil
+}
+
+// startRecovery comprueba la clave de autentificación de la recuperación y emite un token
+// de recuperación, junto con los datos cifrados que hay que volver a cifrar
+// (los fallos cuentan como logins fallidos, con las mismas esperas y límites)
+func (s *server) startRecovery(in recoverIn, ip string) (token []byte, out recoverOut, err error) {
+	defer func() {
+		event := "recovery_start"
+		if err != nil {
+			event = "recovery_failed"
+		}
+		s.record(event, in.User, ip, "", err, "")
+	}()
+	if in.User == "" || !validText(in.User, maxUserName) || !validBytes(in.Auth, maxPass) {
+		in.User = truncate(in.User, maxUserName)
+		return nil, out, ErrBadRequest
+	}
+	if !s.limits.Allow("user:"+in.User, "ip:"+ip) {
+		return nil, out, ErrRateLimited
+	}
+
+	var failure error
+	_, err = s.modify(in.User, func(u *User) error {
+		failure = nil
+		if s.now().Before(u.LockedUntil) { // bloqueada: igual que un usuario inexistente
+			spendHash(in.Auth)
+			return ErrBadCredentials
+		}
+		if u.Recovery == nil { // como si la clave fuera incorrecta (no revela si la cuenta existe)
+			spendHash(in.Auth)
+			failure = ErrBadCredentials
+			loginFailed(u, s.now())
+			return nil
+		}
+		if ok, _, err := verifyPassword(in.Auth, u.Recovery.Verifier); err != nil {
+			return err
+		} else if !ok {
+			failure = ErrBadCredentials
+			loginFailed(u, s.now())
+			return nil // guardamos el fallo
+		}
+		loginOk(u)
+		if u.Disabled {
+			return ErrAccountDisabled
+		}
+
+		var err error
+		if token, _, err = s.tokens.IssueTTL(u.Name, recoverTTL, scopeRecover); err != nil {
+			return err
+		}
+		out = recoverOut{Escrow: u.Recovery.Escrow, PriKey: u.Data["private"], Vault: make([]VaultEntry, 0, len(u.Vault))}
+		for _, e := range u.Vault {
+			e.Blob = nil
+			out.Vault = append(out.Vault, e)
+		}
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) { // igual que una clave incorrecta
+		spendHash(in.Auth)
+		return nil, out, ErrBadCredentials
+	} else if err != nil {
+		return nil, out, err
+	}
+	if failure != nil {
+		return nil, out, failure
+	}
+	return token, out, nil
+}
+
+// authorizeRecover valida un token de recuperación (no tiene sesión asociada)
+func (s *server) authorizeRecover(token []byte) (Claims, error) {
+	c, err := s.tokens.Verify(token)
+	if err != nil || !c.HasScope(scopeRecover) {
+		return c, ErrUnauthorized
+	}
+	return c, nil
+}
+
+// resetPassword fija una contraseña nueva con un token de recuperación (sin la actual)
+// y cierra todas las sesiones; el token sólo se puede usar una vez
+func (s *server) resetPassword(c Claims, in passwordIn) (n int, err error) {
+	defer func() { s.record("recovery_reset", c.Sub, "", c.Sid, err, "") }()
+	if !validPasswordIn(in) {
+		return 0, ErrBadRequest
+	}
+	hash, err := hashPassword(in.New)
+	if err != nil {
+		return 0, err
+	}
+	var closed map[string]Session
+	_, err = s.modify(c.Sub, func(u *User) error {
+		if u.Recovery == nil || u.Recovery.Used == c.Sid {
+			return ErrUnauthorized
+		}
+		if u.Disabled { // deshabilitada después de iniciar la recuperación
+			return ErrAccountDisabled
+		}
+		if err := applyPassword(u, hash, in); err != nil {
+			return err
+		}
+		u.Recovery.Used = c.Sid
+		closed, u.Sessions = u.Sessions, nil
+		loginOk(u)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	s.tokens.Revoke(c.Sid, c.Expires())
+	for _, sess := range closed {
+		s.tokens.Revoke(sess.ID, sess.Expires)
+	}
+	return len(closed), nil
+}
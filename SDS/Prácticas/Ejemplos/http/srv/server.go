@@ -4,15 +4,19 @@ Servidor
 package srv
 
 import (
-	"bytes"
-	"crypto/rand"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"io"
+	"log"
 	"net/http"
-	"sdshttp/util"
+	"os"
+	"os/signal"
+	"sdshttp/audit"
+	"sdshttp/metrics"
+	"syscall"
 	"time"
-
-	"golang.org/x/crypto/scrypt"
 )
 
 // chk comprueba y sale si hay errores (ahorra escritura en programas sencillos)
@@ -23,114 +27,153 @@ func chk(e error) {
 }
 
 // ejemplo de tipo para un usuario
-type user struct {
-	Name  string            // nombre de usuario
-	Hash  []byte            // hash de la contraseña
-	Salt  []byte            // sal para la contraseña
-	Token []byte            // token de sesión
-	Seen  time.Time         // última vez que fue visto
-	Data  map[string]string // datos adicionales del usuario
+type User struct {
+	Name     string             // nombre de usuario
+	Role     string             `json:",omitempty"` // rol (RoleUser si está vacío, o RoleAdmin)
+	Disabled bool               `json:",omitempty"` // cuenta deshabilitada por un administrador
+	PassHash string             // hash codificado de la contraseña (algoritmo, versión, parámetros, sal y hash)
+	Hash     []byte             `json:",omitempty"` // hash scrypt antiguo (se migra a PassHash en el siguiente login)
+	Salt     []byte             `json:",omitempty"` // sal del hash scrypt antiguo
+	Seen     time.Time          // última vez que fue visto
+	Sessions map[string]Session // sesiones abiertas (por identificador)
+	TOTP     *TOTP              `json:",omitempty"` // segundo factor (opcional)
+	Recovery *Recovery          `json:",omitempty"` // recuperación de la cuenta (opcional)
+
+	Failures    int                   // fallos de login consecutivos
+	LastFailure time.Time             // último fallo de login
+	LockedUntil time.Time             // login bloqueado hasta este instante (espera exponencial tras fallos)
+	Data        map[string]string     // datos adicionales del usuario
+	Vault       map[string]VaultEntry `json:",omitempty"` // bóveda con entradas cifradas en el cliente
+	SharesIn    map[string]Share      `json:",omitempty"` // entradas compartidas con el usuario (por identificador)
+	SharesOut   map[string]Share      `json:",omitempty"` // entradas que ha compartido el usuario (sólo metadatos)
+	Rev         uint64                // versión del registro (la gestiona el almacén, ver UserStore.Update)
 }
 
-// mapa con todos los usuarios
-// (se podría serializar con JSON o Gob, etc. y escribir/leer de disco para persistencia)
-var gUsers map[string]user
+// configuración del almacén de usuarios (variables de entorno, ver también config.go)
+const (
+	storeEnv  = "SDSHTTP_STORE"      // tipo de almacén: file (por defecto), bolt o memory
+	pathEnv   = "SDSHTTP_STORE_PATH" // ruta del almacén (users.dat o users.db por defecto)
+	keyEnv    = "SDSHTTP_MASTER_KEY" // clave maestra en base64 (si no, se usa master_key_file)
+	keyFile   = "users.key"          // fichero por defecto con la clave maestra (32 bytes aleatorios)
+	usersFile = "users.dat"          // almacén por defecto para file
+	usersDB   = "users.db"           // almacén por defecto para bolt
+)
 
-// gestiona el modo servidor
-func Run() {
-	gUsers = make(map[string]user) // inicializamos mapa de usuarios
+// server mantiene el estado compartido entre llamadas al handler
+// (net/http llama al handler desde varias gorutinas a la vez)
+type server struct {
+	users   UserStore        // almacén de usuarios (seguro para uso concurrente)
+	blobs   BlobStore        // contenido de los adjuntos de la bóveda
+	locks   keyLocks         // bloqueos por usuario para las secuencias de lectura-modificación-escritura
+	tokens  *tokenIssuer     // emisión y validación de tokens de sesión
+	limits  *limiter         // límite de intentos de login por usuario y por IP
+	events  *audit.Log       // registro de auditoría (nil si está desactivado)
+	maxBody int64            // tamaño máximo de los cuerpos JSON y formularios
+	stats   userStats        // recuento de usuarios y sesiones para las métricas (ver metrics.go)
+	now     func() time.Time // reloj (inyectable para pruebas, p.ej. con TOTP)
+}
 
-	http.HandleFunc("/", handler) // asignamos un handler global
+// permiso de los tokens de sesión para acceder a los datos del usuario
+const scopeData = "data"
 
-	// escuchamos el puerto 10443 con https y comprobamos el error
-	chk(http.ListenAndServeTLS(":10443", "localhost.crt", "localhost.key", nil))
+// openStore abre el almacén configurado (con su clave maestra si está en disco)
+func openStore(c Config) (UserStore, error) {
+	var key []byte
+	if c.Store != "memory" { // la clave sólo es necesaria para almacenes en disco
+		var err error
+		if key, err = loadMasterKey(c.MasterKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	return OpenStore(c.Store, c.storePath(), key)
 }
 
-func handler(w http.ResponseWriter, req *http.Request) {
-	req.ParseForm()                              // es necesario parsear el formulario
-	w.Header().Set("Content-Type", "text/plain") // cabecera estándar
+// gestiona el modo servidor con los argumentos de la línea de órdenes (ver LoadConfig):
+// atiende peticiones hasta recibir SIGINT o SIGTERM, y entonces deja terminar las que
+// están en curso y cierra el almacén, los límites y el registro de auditoría
+func Run(args []string) {
+	cfg, err := LoadConfig(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	chk(err)
 
-	switch req.Form.Get("cmd") { // comprobamos comando desde el cliente
-	case "register": // ** registro
-		_, ok := gUsers[req.Form.Get("user")] // ¿existe ya el usuario?
-		if ok {
-			response(w, false, "Usuario ya registrado", nil)
-			return
-		}
+	users, err := openStore(cfg) // abrimos (y cargamos) el almacén
+	chk(err)
+	defer users.Close()
 
-		u := user{}
-		u.Name = req.Form.Get("user")                   // nombre
-		u.Salt = make([]byte, 16)                       // sal (16 bytes == 128 bits)
-		rand.Read(u.Salt)                               // la sal es aleatoria
-		u.Data = make(map[string]string)                // reservamos mapa de datos de usuario
-		u.Data["private"] = req.Form.Get("prikey")      // clave privada
-		u.Data["public"] = req.Form.Get("pubkey")       // clave pública
-		password := util.Decode64(req.Form.Get("pass")) // contraseña (keyLogin)
-
-		// "hasheamos" la contraseña con scrypt (argon2 es mejor)
-		u.Hash, _ = scrypt.Key(password, u.Salt, 16384, 8, 1, 32)
-
-		u.Seen = time.Now()        // asignamos tiempo de login
-		u.Token = make([]byte, 16) // token (16 bytes == 128 bits)
-		rand.Read(u.Token)         // el token es aleatorio
-
-		gUsers[u.Name] = u
-		response(w, true, "Usuario registrado", u.Token)
-
-	case "login": // ** login
-		u, ok := gUsers[req.Form.Get("user")] // ¿existe ya el usuario?
-		if !ok {
-			response(w, false, "Usuario inexistente", nil)
-			return
-		}
+	path := cfg.storePath()
+	blobs, err := OpenBlobs(cfg.Store, path+".blobs") // adjuntos junto al almacén
+	chk(err)
 
-		password := util.Decode64(req.Form.Get("pass"))          // obtenemos la contraseña (keyLogin)
-		hash, _ := scrypt.Key(password, u.Salt, 16384, 8, 1, 32) // scrypt de keyLogin (argon2 es mejor)
-		if !bytes.Equal(u.Hash, hash) {                          // comparamos
-			response(w, false, "Credenciales inválidas", nil)
-
-		} else {
-			u.Seen = time.Now()        // asignamos tiempo de login
-			u.Token = make([]byte, 16) // token (16 bytes == 128 bits)
-			rand.Read(u.Token)         // el token es aleatorio
-			gUsers[u.Name] = u
-			response(w, true, "Credenciales válidas", u.Token)
-		}
+	tokens, err := newTokenIssuer(time.Now, time.Duration(cfg.SessionTTL)) // clave de firma de los tokens
+	chk(err)
 
-	case "data": // ** obtener datos de usuario
-		u, ok := gUsers[req.Form.Get("user")] // ¿existe ya el usuario?
-		if !ok {
-			response(w, false, "No autentificado", nil)
-			return
-		} else if (u.Token == nil) || (time.Since(u.Seen).Minutes() > 60) {
-			// sin token o con token expirado
-			response(w, false, "No autentificado", nil)
-			return
-		} else if !bytes.EqualFold(u.Token, util.Decode64(req.Form.Get("token"))) {
-			// token no coincide
-			response(w, false, "No autentificado", nil)
-			return
-		}
+	limitsPath := "" // el estado de los límites se conserva junto al almacén si éste es persistente
+	if cfg.Store != "memory" {
+		limitsPath = path + ".limits"
+	}
+	limits, err := newLimiter(limitsPath, time.Now)
+	chk(err)
+	defer limits.Close()
+
+	events, err := openAudit(cfg.AuditPath)
+	chk(err)
+	defer events.Close()
+
+	s := &server{users: users, blobs: blobs, tokens: tokens, limits: limits, events: events,
+		maxBody: cfg.MaxBodyBytes, now: time.Now}
+	s.registerMetrics()
+	hs := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           s.routes(), // API REST y endpoint antiguo (ver api.go y legacy.go)
+		ReadTimeout:       time.Duration(cfg.ReadTimeout),
+		ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout),
+		WriteTimeout:      time.Duration(cfg.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.IdleTimeout),
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
 
-		datos, err := json.Marshal(&u.Data) //
-		chk(err)
-		u.Seen = time.Now()
-		gUsers[u.Name] = u
-		response(w, true, string(datos), u.Token)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// errores al escuchar (API y puerto de administración)
+	done := make(chan error, 2)
+	go func() { done <- hs.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile) }() // escuchamos con https
 
-	default:
-		response(w, false, "Comando no implementado", nil)
+	var admin *http.Server // puerto de administración con las métricas (opcional: sin él no se publican)
+	if cfg.MetricsAddr != "" {
+		admin = metrics.Default.Server(cfg.MetricsAddr)
+		go func() { done <- admin.ListenAndServe() }()
 	}
 
+	select {
+	case err = <-done: // no ha podido escuchar (p.ej. puerto ocupado o certificado inexistente)
+		chk(err)
+	case <-ctx.Done():
+		stop() // una segunda señal ya termina el proceso de inmediato
+		log.Println("parando el servidor...")
+		sctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+		defer cancel()
+		if err = hs.Shutdown(sctx); err != nil { // no acepta más conexiones y espera a las peticiones en curso
+			log.Println("peticiones sin terminar al parar:", err)
+			hs.Close()
+		}
+		if admin != nil {
+			admin.Shutdown(sctx)
+		}
+	}
+	// los defer cierran el registro de auditoría, los límites y el almacén (que vuelca a disco)
 }
 
 // respuesta del servidor
 // (empieza con mayúscula ya que se utiliza en el cliente también)
 // (los variables empiezan con mayúscula para que sean consideradas en el encoding)
 type Resp struct {
-	Ok    bool   // true -> correcto, false -> error
-	Msg   string // mensaje adicional
-	Token []byte // token de sesión para utilizar por el cliente
+	Ok    bool            // true -> correcto, false -> error
+	Msg   string          // mensaje adicional
+	Token []byte          // token de sesión firmado para utilizar por el cliente (ver Claims)
+	Code  string          `json:",omitempty"` // código de error legible por máquina (ver APIError)
+	Data  json.RawMessage `json:",omitempty"` // datos de la respuesta (sólo en la API REST)
 }
 
 // función para escribir una respuesta del servidor
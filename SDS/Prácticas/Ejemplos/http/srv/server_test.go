@@ -0,0 +1,31 @@
+package srv
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// hashes baratos: las pruebas hacen cientos de logins en paralelo
+	hashPolicy = argon2Params{Time: 1, Memory: 64, Threads: 1, KeyLen: 32, SaltLen: 16}
+	os.Exit(m.Run())
+}
+
+// newTestServer crea un servidor sobre el almacén users, con adjuntos en memoria
+// y sin registro de auditoría; los tokens y los límites usan el reloj s.now, así que
+// las pruebas pueden adelantarlo
+func newTestServer(t testing.TB, users UserStore) *server {
+	t.Helper()
+	s := &server{users: users, blobs: NewMemBlobs(), maxBody: DefaultConfig.MaxBodyBytes, now: time.Now}
+	clock := func() time.Time { return s.now() }
+	var err error
+	if s.tokens, err = newTokenIssuer(clock, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if s.limits, err = newLimiter("", clock); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.limits.Close() })
+	return s
+}
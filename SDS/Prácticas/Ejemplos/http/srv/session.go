@@ -0,0 +1,60 @@
+/*
+Sesiones de usuario (una por login, se pueden tener varias a la vez)
+*/
+package srv
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Session es el registro de una sesión abierta
+// (exportado para que el cliente pueda decodificar el listado de sesiones)
+type Session struct {
+	ID      string    // identificador (coincide con Claims.Sid del token)
+	Device  string    // etiqueta del dispositivo indicada por el cliente
+	Created time.Time // inicio de la sesión (login)
+	Seen    time.Time // última petición con esta sesión (se guarda como mucho cada seenEvery)
+	IP      string    // dirección del cliente en el login
+	Expires time.Time // expiración del token
+}
+
+// newSession crea el registro de sesión para un token recién emitido
+func newSession(c Claims, device, ip string) Session {
+	now := time.Unix(c.Iat, 0)
+	return Session{ID: c.Sid, Device: device, Created: now, Seen: now, IP: ip, Expires: c.Expires()}
+}
+
+// remoteIP devuelve la IP del cliente (sin el puerto)
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// addSession guarda una sesión en el usuario y descarta las que ya han expirado
+func addSession(u *User, sess Session, now time.Time) {
+	if u.Sessions == nil {
+		u.Sessions = make(map[string]Session)
+	}
+	for id, old := range u.Sessions {
+		if !now.Before(old.Expires) {
+			delete(u.Sessions, id)
+		}
+	}
+	u.Sessions[sess.ID] = sess
+}
+
+// sortedSessions devuelve las sesiones de un usuario ordenadas por inicio
+func sortedSessions(u User) []Session {
+	l := make([]Session, 0, len(u.Sessions))
+	for _, sess := range u.Sessions {
+		l = append(l, sess)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Created.Before(l[j].Created) })
+	return l
+}
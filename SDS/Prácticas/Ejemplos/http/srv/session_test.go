@@ -0,0 +1,50 @@
+package srv
+
+import (
+	"testing"
+	"time"
+)
+
+// TestAuthorizeSeen comprueba que authorize sólo escribe en el almacén para actualizar
+// cuándo se vio la sesión si ha pasado seenEvery
+func TestAuthorizeSeen(t *testing.T) {
+	store, _ := openTestStore(t, "memory", "")
+	s := newTestServer(t, store)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	in := registerIn{User: "ana", Pass: []byte("clave"), PubKey: "pub", PriKey: "pri"}
+	token, err := s.register(in, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev := func() uint64 {
+		u, err := store.Get("ana")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u.Rev
+	}
+	authorize := func() {
+		t.Helper()
+		if _, _, err := s.authorize(token, "10.0.0.1", "prueba"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := rev()
+	now = now.Add(seenEvery / 2)
+	authorize()
+	if rev() != r {
+		t.Fatal("authorize ha escrito antes de seenEvery")
+	}
+	now = now.Add(seenEvery)
+	authorize()
+	if rev() != r+1 {
+		t.Fatal("authorize no ha guardado la última petición tras seenEvery")
+	}
+	authorize()
+	if rev() != r+1 {
+		t.Fatal("authorize ha vuelto a escribir")
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Entradas de la bóveda compartidas entre usuarios
+
+el emisor descifra la clave de la entrada y la cifra con la clave pública del destinatario
+(RSA-OAEP, como el esquema híbrido de sdspk); el servidor guarda una copia del contenido
+cifrado de la entrada en ese momento, así que ninguna de las dos claves pasa en claro por él
+(aunque sí hay que confiar en que entregue la clave pública correcta del destinatario)
+*/
+package srv
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// máximo de entradas compartidas recibidas por usuario
+const maxShares = 1000
+
+// errores de las entradas compartidas
+var (
+	ErrUserNotFound  = &APIError{http.StatusNotFound, "user_not_found", "Usuario inexistente"}
+	ErrShareNotFound = &APIError{http.StatusNotFound, "share_not_found", "Entrada compartida inexistente"}
+	ErrSharesFull    = &APIError{http.StatusRequestEntityTooLarge, "shares_full", "El destinatario tiene demasiadas entradas compartidas"}
+)
+
+// Share es una entrada compartida (la recibe el destinatario; el emisor guarda sólo los metadatos)
+type Share struct {
+	ID      string    // identificador
+	From    string    // usuario que la comparte
+	To      string    // destinatario
+	Name    string    // nombre de la entrada en la bóveda del emisor
+	Blob    []byte    `json:",omitempty"` // copia del contenido cifrado de la entrada
+	Key     []byte    `json:",omitempty"` // clave de la entrada cifrada con la clave pública del destinatario
+	Version uint64    // versión de la entrada compartida
+	Created time.Time // cuándo se compartió
+}
+
+// shareIn son los datos para compartir una entrada
+type shareIn struct {
+	To      string `json:"to"`      // destinatario
+	Name    string `json:"name"`    // entrada de la bóveda
+	Key     []byte `json:"key"`     // clave de la entrada cifrada para el destinatario (base64 en JSON)
+	Version uint64 `json:"version"` // versión de la entrada cuya clave se ha cifrado
+}
+
+// publicKey devuelve la clave pública registrada de un usuario y su algoritmo
+func (s *server) publicKey(name string) (pub, alg string, err error) {
+	u, err := s.users.Get(name)
+	if errors.Is(err, ErrNotFound) || (err == nil && u.Data["public"] == "") {
+		return "", "", ErrUserNotFound
+	} else if err != nil {
+		return "", "", err
+	}
+	return u.Data["public"], keyAlg(u), nil
+}
+
+// share comparte una entrada de la bóveda del usuario con otro usuario
+func (s *server) share(c Claims, in shareIn) (sh Share, err error) {
+	if in.To == "" || in.To == c.Sub || !validText(in.To, maxUserName) || !validBytes(in.Key, maxWrapped) {
+		return sh, ErrBadRequest
+	}
+	u, err := s.users.Get(c.Sub)
+	if err != nil {
+		return sh, err
+	}
+	e, err := vaultGet(u, in.Name)
+	if err != nil {
+		return sh, err
+	}
+	if e.Version != in.Version { // la clave de la entrada cambia con cada modificación
+		return sh, ErrVersionConflict
+	}
+	id := randomID(16)
+	sh = Share{ID: id, From: c.Sub, To: in.To, Name: e.Name, Blob: e.Blob, Key: in.Key, Version: e.Version, Created: s.now()}
+
+	// primero se entrega al destinatario y luego se anota en el emisor
+	// (son dos usuarios distintos, cada uno con su propio bloqueo)
+	_, err = s.modify(in.To, func(to *User) error {
+		if len(to.SharesIn) >= maxShares {
+			return ErrSharesFull
+		}
+		if to.SharesIn == nil {
+			to.SharesIn = make(map[string]Share)
+		}
+		to.SharesIn[id] = sh
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) {
+		return Share{}, ErrUserNotFound
+	} else if err != nil {
+		return Share{}, err
+	}
+
+	meta := sh
+	meta.Blob, meta.Key = nil, nil
+	_, err = s.modify(c.Sub, func(u *User) error {
+		if u.SharesOut == nil {
+			u.SharesOut = make(map[string]Share)
+		}
+		u.SharesOut[id] = meta
+		return nil
+	})
+	if err != nil { // deshacemos la entrega
+		s.modify(in.To, func(to *User) error {
+			delete(to.SharesIn, id)
+			return nil
+		})
+		return Share{}, err
+	}
+	return meta, nil
+}
+
+// sharesList devuelve las entradas compartidas recibidas y enviadas (sólo metadatos, por fecha)
+func sharesList(u User) (in, out []Share) {
+	list := func(m map[string]Share) []Share {
+		l := make([]Share, 0, len(m))
+		for _, sh := range m {
+			sh.Blob, sh.Key = nil, nil
+			l = append(l, sh)
+		}
+		sort.Slice(l, func(i, j int) bool { return l[i].Created.Before(l[j].Created) })
+		return l
+	}
+	return list(u.SharesIn), list(u.SharesOut)
+}
+
+// shareGet devuelve una entrada compartida recibida
+func shareGet(u User, id string) (Share, error) {
+	sh, ok := u.SharesIn[id]
+	if !ok {
+		return Share{}, ErrShareNotFound
+	}
+	return sh, nil
+}
+
+// unshare borra una entrada compartida: la revoca el emisor o la descarta el destinatario
+// (la revocación no deshace lo que el destinatario ya haya descifrado)
+func (s *server) unshare(c Claims, id string) error {
+	u, err := s.users.Get(c.Sub)
+	if err != nil {
+		return err
+	}
+	sh, ok := u.SharesOut[id]
+	if !ok {
+		if sh, ok = u.SharesIn[id]; !ok {
+			return ErrShareNotFound
+		}
+	}
+
+	for _, name := range []string{sh.To, sh.From} {
+		_, err = s.modify(name, func(u *User) error {
+			delete(u.SharesIn, id)
+			delete(u.SharesOut, id)
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrNotFound) { // el otro usuario puede haber desaparecido
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+/*
+Almacenamiento de usuarios
+*/
+package srv
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sdshttp/util"
+	"sort"
+	"sync"
+)
+
+// errores comunes a todos los almacenes
+var (
+	ErrNotFound = errors.New("usuario inexistente")
+	ErrExists   = errors.New("usuario ya registrado")
+	ErrConflict = errors.New("el usuario ha sido modificado concurrentemente")
+)
+
+// UserStore es la interfaz que deben cumplir los almacenes de usuarios
+// (las implementaciones deben poder usarse desde varias gorutinas a la vez)
+type UserStore interface {
+	Get(name string) (User, error) // obtiene un usuario (ErrNotFound si no existe)
+	Put(u User) error              // crea un usuario nuevo (ErrExists si ya existe)
+	Delete(name string) error      // elimina un usuario (ErrNotFound si no existe)
+	List() ([]User, error)         // todos los usuarios, ordenados por nombre
+	Update(u User) error           // compare-and-swap: sólo escribe si u.Rev coincide con la versión almacenada (ErrConflict)
+	Close() error                  // libera recursos y vuelca a disco
+}
+
+// OpenStore abre un almacén por su tipo: "memory", "file" o "bolt"
+// (path se ignora en memoria; key es la clave maestra para cifrar en disco)
+func OpenStore(kind, path string, key []byte) (UserStore, error) {
+	switch kind {
+	case "memory":
+		return NewMemStore(), nil
+	case "file", "":
+		return NewFileStore(path, key)
+	case "bolt":
+		return NewBoltStore(path, key)
+	default:
+		return nil, errors.New("tipo de almacén desconocido: " + kind)
+	}
+}
+
+// memStore guarda los usuarios en un mapa en memoria (se pierden al reiniciar)
+type memStore struct {
+	mu    sync.RWMutex
+	users map[string]User
+}
+
+// NewMemStore crea un almacén en memoria vacío
+func NewMemStore() UserStore {
+	return &memStore{users: make(map[string]User)}
+}
+
+func (s *memStore) Get(name string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[name]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u.clone(), nil
+}
+
+func (s *memStore) Put(u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[u.Name]; ok {
+		return ErrExists
+	}
+	u = u.clone()
+	u.Rev = 1
+	s.users[u.Name] = u
+	return nil
+}
+
+func (s *memStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[name]; !ok {
+		return ErrNotFound
+	}
+	delete(s.users, name)
+	return nil
+}
+
+func (s *memStore) List() ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedUsers(s.users), nil
+}
+
+func (s *memStore) Update(u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.users[u.Name]
+	if !ok {
+		return ErrNotFound
+	} else if old.Rev != u.Rev {
+		return ErrConflict
+	}
+	u = u.clone()
+	u.Rev++
+	s.users[u.Name] = u
+	return nil
+}
+
+func (s *memStore) Close() error { return nil }
+
+// sortedUsers devuelve los usuarios de un mapa ordenados por nombre
+func sortedUsers(m map[string]User) []User {
+	l := make([]User, 0, len(m))
+	for _, u := range m {
+		l = append(l, u.clone())
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Name < l[j].Name })
+	return l
+}
+
+// clone devuelve una copia profunda del usuario: los almacenes en memoria no deben
+// compartir mapas ni slices con quien los llama, o se modificarían sin bloqueo
+// (se hace con JSON para no tener que actualizarla con cada campo nuevo)
+func (u User) clone() User {
+	data, err := json.Marshal(u)
+	chk(err)
+	var c User
+	chk(json.Unmarshal(data, &c))
+	return c
+}
+
+// loadMasterKey obtiene la clave maestra del servidor (256 bits)
+// (primero de la variable de entorno en base64, si no del fichero, que se crea si no existe)
+func loadMasterKey(path string) ([]byte, error) {
+	if s := os.Getenv(keyEnv); s != "" {
+		key, err := util.Decode64(s)
+		if err != nil || len(key) != 32 {
+			return nil, errors.New(keyEnv + " debe contener 32 bytes en base64")
+		}
+		return key, nil
+	}
+
+	key, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) { // primera ejecución: generamos la clave
+		key = make([]byte, 32)
+		if _, err = rand.Read(key); err != nil {
+			return nil, err
+		}
+		return key, writeFileAtomic(path, key)
+	} else if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, errors.New("clave maestra inválida en " + path)
+	}
+	return key, nil
+}
+
+// seal comprime y cifra (AEAD) con la clave maestra los datos de un almacén en disco;
+// ad liga el resultado a su sitio en el almacén (no se puede mover a otro sin que se detecte)
+func seal(data, key []byte, ad string) ([]byte, error) {
+	z, err := util.Compress(data)
+	if err != nil {
+		return nil, err
+	}
+	return util.Seal(util.DefaultAlg, key, z, []byte(ad))
+}
+
+// unseal descifra y descomprime los datos de un almacén en disco (inverso de seal); los
+// cifrados con AES-CTR por versiones anteriores se leen igual y se migran al escribirlos
+func unseal(data, key []byte, ad string) ([]byte, error) {
+	z, _, err := util.OpenLegacy(key, data, []byte(ad))
+	if err != nil {
+		return nil, err
+	}
+	return util.DecompressLimit(z, storeLimits)
+}
+
+// storeLimits son los límites de la descompresión del almacén: sus datos están autentificados
+// con la clave maestra, así que sólo se acota el tamaño (el fichero guarda todos los usuarios)
+var storeLimits = util.Limits{MaxSize: 1 << 30}
+
+// writeFileAtomic escribe un fichero de forma atómica: fichero temporal en el
+// mismo directorio, sync y rename (un fallo a mitad deja intacta la versión anterior)
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no hace nada si el rename ha tenido éxito
+
+	if err = tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil { // forzamos la escritura en disco antes del rename
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
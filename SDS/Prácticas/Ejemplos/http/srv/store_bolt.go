@@ -0,0 +1,133 @@
+/*
+Almacén de usuarios en una base de datos clave-valor embebida (bbolt)
+*/
+package srv
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// cubeta de bbolt donde se guardan los usuarios (clave: nombre, valor: JSON cifrado)
+var bucketUsers = []byte("users")
+
+// datos asociados del cifrado de cada registro, seguidos del nombre (ver seal)
+const boltAD = "sdshttp user "
+
+// boltStore guarda cada usuario como un registro independiente en bbolt
+// (las transacciones de escritura de bbolt están serializadas, lo que hace trivial el compare-and-swap)
+type boltStore struct {
+	db  *bolt.DB
+	key []byte
+}
+
+// NewBoltStore abre (o crea) la base de datos en path; los registros se cifran con key
+func NewBoltStore(path string, key []byte) (UserStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second}) // timeout si otro proceso la tiene abierta
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketUsers)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db, key: key}, nil
+}
+
+// encode serializa y cifra un usuario
+func (s *boltStore) encode(u User) ([]byte, error) {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+	return seal(data, s.key, boltAD+u.Name)
+}
+
+// decode descifra y deserializa un usuario
+func (s *boltStore) decode(name string, data []byte) (u User, err error) {
+	if data, err = unseal(data, s.key, boltAD+name); err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &u)
+	return
+}
+
+// get lee un usuario dentro de una transacción
+func (s *boltStore) get(tx *bolt.Tx, name string) (User, error) {
+	data := tx.Bucket(bucketUsers).Get([]byte(name))
+	if data == nil {
+		return User{}, ErrNotFound
+	}
+	return s.decode(name, data)
+}
+
+// put escribe un usuario dentro de una transacción
+func (s *boltStore) put(tx *bolt.Tx, u User) error {
+	data, err := s.encode(u)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucketUsers).Put([]byte(u.Name), data)
+}
+
+func (s *boltStore) Get(name string) (u User, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		u, err = s.get(tx, name)
+		return err
+	})
+	return
+}
+
+func (s *boltStore) Put(u User) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketUsers).Get([]byte(u.Name)) != nil {
+			return ErrExists
+		}
+		u.Rev = 1
+		return s.put(tx, u)
+	})
+}
+
+func (s *boltStore) Delete(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketUsers)
+		if b.Get([]byte(name)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete([]byte(name))
+	})
+}
+
+func (s *boltStore) List() (l []User, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketUsers).ForEach(func(k, v []byte) error { // bbolt recorre las claves en orden
+			u, err := s.decode(string(k), v)
+			if err == nil {
+				l = append(l, u)
+			}
+			return err
+		})
+	})
+	return
+}
+
+func (s *boltStore) Update(u User) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		old, err := s.get(tx, u.Name)
+		if err != nil {
+			return err
+		} else if old.Rev != u.Rev {
+			return ErrConflict
+		}
+		u.Rev++
+		return s.put(tx, u)
+	})
+}
+
+func (s *boltStore) Close() error { return s.db.Close() }
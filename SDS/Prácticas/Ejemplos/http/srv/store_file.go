@@ -0,0 +1,125 @@
+/*
+Almacén de usuarios en un fichero cifrado
+*/
+package srv
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+// datos asociados del cifrado del fichero (ver seal)
+const fileAD = "sdshttp users"
+
+// fileStore mantiene los usuarios en memoria y reescribe el fichero completo
+// (JSON, comprimido y cifrado con la clave maestra) tras cada modificación
+type fileStore struct {
+	mu    sync.RWMutex
+	path  string
+	key   []byte
+	users map[string]User
+}
+
+// NewFileStore abre (o crea) un almacén en el fichero path cifrado con key
+func NewFileStore(path string, key []byte) (UserStore, error) {
+	s := &fileStore{path: path, key: key, users: make(map[string]User)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) { // todavía no hay usuarios
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// desciframos, descomprimimos y decodificamos (inverso de save)
+	if data, err = unseal(data, key, fileAD); err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &s.users); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// save escribe todos los usuarios en disco (se llama con mu bloqueado)
+func (s *fileStore) save() error {
+	data, err := json.Marshal(s.users)
+	if err != nil {
+		return err
+	}
+	if data, err = seal(data, s.key, fileAD); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+func (s *fileStore) Get(name string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[name]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u.clone(), nil
+}
+
+func (s *fileStore) Put(u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[u.Name]; ok {
+		return ErrExists
+	}
+	u = u.clone()
+	u.Rev = 1
+	s.users[u.Name] = u
+	if err := s.save(); err != nil {
+		delete(s.users, u.Name) // no registramos si no se ha podido guardar
+		return err
+	}
+	return nil
+}
+
+func (s *fileStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.users[name]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.users, name)
+	if err := s.save(); err != nil {
+		s.users[name] = old
+		return err
+	}
+	return nil
+}
+
+func (s *fileStore) List() ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedUsers(s.users), nil
+}
+
+func (s *fileStore) Update(u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.users[u.Name]
+	if !ok {
+		return ErrNotFound
+	} else if old.Rev != u.Rev {
+		return ErrConflict
+	}
+	u = u.clone()
+	u.Rev++
+	s.users[u.Name] = u
+	if err := s.save(); err != nil {
+		s.users[u.Name] = old
+		return err
+	}
+	return nil
+}
+
+// Close no tiene nada pendiente: cada modificación ya se ha escrito en disco
+func (s *fileStore) Close() error { return nil }
@@ -0,0 +1,229 @@
+package srv
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sdshttp/util"
+	"testing"
+)
+
+// testKey es una clave maestra fija para los almacenes en disco de las pruebas
+var testKey = bytes.Repeat([]byte{7}, 32)
+
+// storeKinds son los almacenes que deben cumplir el mismo contrato (UserStore)
+var storeKinds = []struct {
+	kind string
+	file string // nombre del fichero en el directorio temporal (vacío en memoria)
+}{
+	{"memory", ""},
+	{"file", "users.dat"},
+	{"bolt", "users.db"},
+}
+
+// openTestStore abre un almacén vacío del tipo dado en un directorio temporal
+func openTestStore(t testing.TB, kind, file string) (UserStore, string) {
+	t.Helper()
+	path := ""
+	if file != "" {
+		path = filepath.Join(t.TempDir(), file)
+	}
+	s, err := OpenStore(kind, path, testKey)
+	if err != nil {
+		t.Fatalf("OpenStore(%s): %v", kind, err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, path
+}
+
+// TestUserStore comprueba el contrato de UserStore en todos los almacenes
+func TestUserStore(t *testing.T) {
+	cases := []struct {
+		name string
+		run  func(t *testing.T, s UserStore)
+	}{
+		{"get inexistente", func(t *testing.T, s UserStore) {
+			if _, err := s.Get("nadie"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Get = %v, se esperaba ErrNotFound", err)
+			}
+		}},
+		{"put y get", func(t *testing.T, s UserStore) {
+			mustPut(t, s, User{Name: "ana", PassHash: "h", Data: map[string]string{"k": "v"}})
+			u, err := s.Get("ana")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Name != "ana" || u.PassHash != "h" || u.Data["k"] != "v" || u.Rev != 1 {
+				t.Fatalf("Get = %+v", u)
+			}
+		}},
+		{"put repetido", func(t *testing.T, s UserStore) {
+			mustPut(t, s, User{Name: "ana"})
+			if err := s.Put(User{Name: "ana"}); !errors.Is(err, ErrExists) {
+				t.Fatalf("Put = %v, se esperaba ErrExists", err)
+			}
+		}},
+		{"get devuelve una copia", func(t *testing.T, s UserStore) {
+			mustPut(t, s, User{Name: "ana", Data: map[string]string{"k": "v"}})
+			u, _ := s.Get("ana")
+			u.Data["k"] = "cambiado"
+			if u, _ = s.Get("ana"); u.Data["k"] != "v" {
+				t.Fatalf("el almacén comparte el mapa: %q", u.Data["k"])
+			}
+		}},
+		{"list ordenado", func(t *testing.T, s UserStore) {
+			for _, n := range []string{"carlos", "ana", "berta"} {
+				mustPut(t, s, User{Name: n})
+			}
+			l, err := s.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(l) != 3 || l[0].Name != "ana" || l[1].Name != "berta" || l[2].Name != "carlos" {
+				t.Fatalf("List = %v", names(l))
+			}
+		}},
+		{"delete", func(t *testing.T, s UserStore) {
+			mustPut(t, s, User{Name: "ana"})
+			if err := s.Delete("ana"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Get("ana"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Get tras Delete = %v", err)
+			}
+			if err := s.Delete("ana"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Delete repetido = %v, se esperaba ErrNotFound", err)
+			}
+			if l, _ := s.List(); len(l) != 0 {
+				t.Fatalf("List tras Delete = %v", names(l))
+			}
+		}},
+		{"update incrementa la versión", func(t *testing.T, s UserStore) {
+			mustPut(t, s, User{Name: "ana"})
+			u, _ := s.Get("ana")
+			u.PassHash = "nuevo"
+			if err := s.Update(u); err != nil {
+				t.Fatal(err)
+			}
+			if u, _ = s.Get("ana"); u.PassHash != "nuevo" || u.Rev != 2 {
+				t.Fatalf("Get tras Update = %+v", u)
+			}
+		}},
+		{"update con versión antigua", func(t *testing.T, s UserStore) {
+			mustPut(t, s, User{Name: "ana"})
+			a, _ := s.Get("ana")
+			b, _ := s.Get("ana")
+			a.PassHash, b.PassHash = "a", "b"
+			if err := s.Update(a); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Update(b); !errors.Is(err, ErrConflict) {
+				t.Fatalf("Update con Rev antigua = %v, se esperaba ErrConflict", err)
+			}
+			if u, _ := s.Get("ana"); u.PassHash != "a" {
+				t.Fatalf("el conflicto ha escrito: %q", u.PassHash)
+			}
+		}},
+		{"update inexistente", func(t *testing.T, s UserStore) {
+			if err := s.Update(User{Name: "nadie", Rev: 1}); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Update = %v, se esperaba ErrNotFound", err)
+			}
+		}},
+	}
+
+	for _, k := range storeKinds {
+		for _, c := range cases {
+			t.Run(k.kind+"/"+c.name, func(t *testing.T) {
+				s, _ := openTestStore(t, k.kind, k.file)
+				c.run(t, s)
+			})
+		}
+	}
+}
+
+// TestUserStoreReopen comprueba que los almacenes en disco conservan los usuarios y sus versiones
+func TestUserStoreReopen(t *testing.T) {
+	for _, k := range storeKinds {
+		if k.file == "" {
+			continue
+		}
+		t.Run(k.kind, func(t *testing.T) {
+			s, path := openTestStore(t, k.kind, k.file)
+			mustPut(t, s, User{Name: "ana", Data: map[string]string{"k": "v"}})
+			u, _ := s.Get("ana")
+			if err := s.Update(u); err != nil {
+				t.Fatal(err)
+			}
+			s.Close()
+
+			s, err := OpenStore(k.kind, path, testKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			if u, err = s.Get("ana"); err != nil || u.Data["k"] != "v" || u.Rev != 2 {
+				t.Fatalf("Get tras reabrir = %+v, %v", u, err)
+			}
+		})
+	}
+}
+
+// TestFileStoreLegacy comprueba que un fichero cifrado con AES-CTR por versiones anteriores
+// se lee y se vuelve a cifrar con un sobre autentificado en la siguiente escritura
+func TestFileStoreLegacy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.dat")
+	data, err := json.Marshal(map[string]User{"ana": {Name: "ana", Rev: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := util.Compress(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := util.Encrypt(z, testKey) // formato anterior: sin cabecera ni autentificación
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, old, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenStore("file", path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	u, err := s.Get("ana")
+	if err != nil {
+		t.Fatalf("Get del fichero antiguo: %v", err)
+	}
+	if err = s.Update(u); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if !util.IsEnvelope(data) {
+		t.Fatal("el fichero no se ha migrado al sobre autentificado")
+	}
+	if _, err = util.Open(testKey, data, []byte(fileAD)); err != nil {
+		t.Fatalf("Open del fichero migrado: %v", err)
+	}
+}
+
+func mustPut(t *testing.T, s UserStore, u User) {
+	t.Helper()
+	if err := s.Put(u); err != nil {
+		t.Fatalf("Put(%s): %v", u.Name, err)
+	}
+}
+
+func names(l []User) []string {
+	n := make([]string, len(l))
+	for i, u := range l {
+		n[i] = u.Name
+	}
+	return n
+}
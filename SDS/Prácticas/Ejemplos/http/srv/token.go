@@ -0,0 +1,206 @@
+/*
+Tokens de sesión firmados (sin estado en el servidor)
+
+Formato: sds1.<claims>.<firma>, con claims en JSON y ambas partes en base64url sin relleno.
+La firma es Ed25519 sobre "sds1.<claims>" con la clave indicada en Claims.Kid.
+*/
+package srv
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prefijo (y versión) de los tokens
+const tokenPrefix = "sds1."
+
+// cada cuánto se rota la clave de firma (la duración de las sesiones es Config.SessionTTL)
+const keyRotation = 24 * time.Hour
+
+// errores de validación de tokens
+var (
+	ErrTokenInvalid = errors.New("token inválido")
+	ErrTokenExpired = errors.New("token expirado")
+	ErrTokenRevoked = errors.New("token revocado")
+)
+
+// Claims es el contenido de un token de sesión
+// (exportado para que el cliente pueda consultarlo, ver ReadClaims)
+type Claims struct {
+	Sub    string   `json:"sub"` // usuario
+	Iat    int64    `json:"iat"` // emisión (segundos desde epoch)
+	Exp    int64    `json:"exp"` // expiración (segundos desde epoch)
+	Sid    string   `json:"sid"` // identificador de la sesión
+	Scopes []string `json:"scp"` // permisos del token
+	Kid    string   `json:"kid"` // clave con la que se ha firmado
+}
+
+// Expires devuelve el instante de expiración del token
+func (c Claims) Expires() time.Time { return time.Unix(c.Exp, 0) }
+
+// HasScope indica si el token incluye el permiso scope
+func (c Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadClaims extrae los claims de un token SIN comprobar la firma
+// (útil en el cliente para conocer la expiración; el servidor usa tokenIssuer.Verify)
+func ReadClaims(token []byte) (c Claims, err error) {
+	parts := strings.Split(string(token), ".")
+	if len(parts) != 3 || parts[0]+"." != tokenPrefix {
+		return c, ErrTokenInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return c, ErrTokenInvalid
+	}
+	if err = json.Unmarshal(payload, &c); err != nil {
+		return c, ErrTokenInvalid
+	}
+	return c, nil
+}
+
+// signingKey es una clave de firma de tokens
+type signingKey struct {
+	id      string
+	priv    ed25519.PrivateKey
+	created time.Time // creación (para la rotación)
+	retired time.Time // retirada (sus tokens son válidos como mucho hasta retired+ttl)
+}
+
+// tokenIssuer emite y valida tokens firmados con una clave Ed25519 que rota periódicamente;
+// las claves anteriores se conservan mientras puedan existir tokens válidos firmados con ellas.
+// Las claves y la lista de revocación sólo viven en memoria: al reiniciar el servidor
+// todas las sesiones dejan de ser válidas (y con ellas las revocaciones).
+type tokenIssuer struct {
+	mu      sync.Mutex
+	cur     signingKey            // clave actual para firmar
+	old     map[string]signingKey // claves anteriores (sólo para verificar)
+	revoked map[string]time.Time  // sesiones revocadas -> expiración del token
+	now     func() time.Time      // reloj (inyectable)
+	ttl     time.Duration         // duración de los tokens
+	rotate  time.Duration         // periodo de rotación de la clave
+}
+
+// newTokenIssuer crea un emisor de tokens que duran ttl con una clave recién generada
+func newTokenIssuer(now func() time.Time, ttl time.Duration) (*tokenIssuer, error) {
+	t := &tokenIssuer{old: make(map[string]signingKey), revoked: make(map[string]time.Time),
+		now: now, ttl: ttl, rotate: keyRotation}
+	return t, t.newKey()
+}
+
+// newKey genera una nueva clave de firma y retira la actual (se llama con mu bloqueado)
+func (t *tokenIssuer) newKey() error {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	if t.cur.priv != nil {
+		t.cur.retired = t.now()
+		t.old[t.cur.id] = t.cur
+	}
+	t.cur = signingKey{id: randomID(8), priv: priv, created: t.now()}
+
+	// olvidamos las claves retiradas hace más de ttl (sus tokens ya han expirado)
+	for id, k := range t.old {
+		if t.now().Sub(k.retired) > t.ttl {
+			delete(t.old, id)
+		}
+	}
+	return nil
+}
+
+// Issue emite un token nuevo para el usuario sub con los permisos indicados
+func (t *tokenIssuer) Issue(sub string, scopes ...string) ([]byte, Claims, error) {
+	return t.IssueTTL(sub, t.ttl, scopes...)
+}
+
+// IssueTTL emite un token con una duración menor que la de las sesiones (p.ej. para recuperación)
+func (t *tokenIssuer) IssueTTL(sub string, ttl time.Duration, scopes ...string) ([]byte, Claims, error) {
+	if ttl > t.ttl { // las claves retiradas se olvidan tras t.ttl
+		ttl = t.ttl
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.now().Sub(t.cur.created) > t.rotate { // rotación de la clave
+		if err := t.newKey(); err != nil {
+			return nil, Claims{}, err
+		}
+	}
+
+	now := t.now()
+	c := Claims{Sub: sub, Iat: now.Unix(), Exp: now.Add(ttl).Unix(), Sid: randomID(16), Scopes: scopes, Kid: t.cur.id}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return nil, c, err
+	}
+
+	signed := tokenPrefix + base64.RawURLEncoding.EncodeToString(payload)
+	sig := ed25519.Sign(t.cur.priv, []byte(signed))
+	return []byte(signed + "." + base64.RawURLEncoding.EncodeToString(sig)), c, nil
+}
+
+// Verify comprueba la firma, la expiración y la revocación de un token y devuelve sus claims
+func (t *tokenIssuer) Verify(token []byte) (Claims, error) {
+	c, err := ReadClaims(token)
+	if err != nil {
+		return c, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	k, ok := t.old[c.Kid]
+	if c.Kid == t.cur.id {
+		k, ok = t.cur, true
+	}
+	if !ok {
+		return c, ErrTokenInvalid // clave desconocida (o de antes de reiniciar el servidor)
+	}
+
+	i := strings.LastIndexByte(string(token), '.')
+	sig, err := base64.RawURLEncoding.DecodeString(string(token[i+1:]))
+	if err != nil || !ed25519.Verify(k.priv.Public().(ed25519.PublicKey), token[:i], sig) {
+		return c, ErrTokenInvalid
+	}
+
+	if !t.now().Before(c.Expires()) {
+		return c, ErrTokenExpired
+	}
+	if _, ok := t.revoked[c.Sid]; ok {
+		return c, ErrTokenRevoked
+	}
+	return c, nil
+}
+
+// Revoke invalida los tokens de la sesión sid hasta su expiración exp (logout)
+func (t *tokenIssuer) Revoke(sid string, exp time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for sid, exp := range t.revoked { // purgamos las revocaciones de tokens ya expirados
+		if !t.now().Before(exp) {
+			delete(t.revoked, sid)
+		}
+	}
+	t.revoked[sid] = exp
+}
+
+// randomID genera un identificador aleatorio de n bytes en base64url
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
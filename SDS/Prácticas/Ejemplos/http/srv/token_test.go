@@ -0,0 +1,182 @@
+package srv
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestIssuer crea un emisor de tokens con un reloj que la prueba puede adelantar
+func newTestIssuer(t *testing.T, ttl time.Duration) (*tokenIssuer, *time.Time) {
+	t.Helper()
+	now := time.Unix(1700000000, 0)
+	ti, err := newTokenIssuer(func() time.Time { return now }, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ti, &now
+}
+
+// TestTokenVerify comprueba que un token recién emitido es válido y lleva sus claims
+func TestTokenVerify(t *testing.T) {
+	ti, now := newTestIssuer(t, time.Hour)
+	token, issued, err := ti.Issue("ana", scopeData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(token), tokenPrefix) {
+		t.Fatalf("token sin prefijo: %s", token)
+	}
+	c, err := ti.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Sub != "ana" || c.Sid != issued.Sid || !c.HasScope(scopeData) || c.Exp != now.Add(time.Hour).Unix() {
+		t.Fatalf("claims = %+v, emitidos %+v", c, issued)
+	}
+	if c, err = ReadClaims(token); err != nil || c.Sid != issued.Sid {
+		t.Fatalf("ReadClaims = %+v, %v", c, err)
+	}
+}
+
+// TestTokenTampered comprueba que se rechaza un token con los claims o la firma modificados
+func TestTokenTampered(t *testing.T) {
+	ti, _ := newTestIssuer(t, time.Hour)
+	token, _, err := ti.Issue("ana", scopeData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(string(token), ".")
+	enc := base64.RawURLEncoding
+
+	payload, err := enc.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherClaims := strings.Replace(string(payload), `"sub":"ana"`, `"sub":"eva"`, 1)
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig[0] ^= 1
+	other, _ := newTestIssuer(t, time.Hour) // otra clave (p.ej. antes de reiniciar el servidor)
+	foreign, _, err := other.Issue("ana", scopeData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct{ name, token string }{
+		{"claims de otro usuario", parts[0] + "." + enc.EncodeToString([]byte(otherClaims)) + "." + parts[2]},
+		{"firma modificada", parts[0] + "." + parts[1] + "." + enc.EncodeToString(sig)},
+		{"sin firma", parts[0] + "." + parts[1] + "."},
+		{"firma no base64", parts[0] + "." + parts[1] + ".!!"},
+		{"otro prefijo", "sds2." + parts[1] + "." + parts[2]},
+		{"partes de más", string(token) + ".x"},
+		{"claims no JSON", parts[0] + "." + enc.EncodeToString([]byte("x")) + "." + parts[2]},
+		{"firmado con otra clave", string(foreign)},
+		{"vacío", ""},
+	} {
+		if _, err := ti.Verify([]byte(c.token)); !errors.Is(err, ErrTokenInvalid) {
+			t.Errorf("Verify con %s = %v, se esperaba ErrTokenInvalid", c.name, err)
+		}
+	}
+}
+
+// TestTokenExpired comprueba la expiración con el reloj inyectado
+func TestTokenExpired(t *testing.T) {
+	ti, now := newTestIssuer(t, time.Hour)
+	token, _, err := ti.Issue("ana", scopeData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	short, _, err := ti.IssueTTL("ana", 10*time.Minute, scopeRecover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	long, c, err := ti.IssueTTL("ana", 2*time.Hour) // no puede durar más que las sesiones
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Exp != now.Add(time.Hour).Unix() {
+		t.Fatalf("IssueTTL(2h) expira a las %v, se esperaba la duración de las sesiones", c.Expires())
+	}
+
+	*now = now.Add(10 * time.Minute)
+	if _, err = ti.Verify(short); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("Verify del token de 10 minutos = %v, se esperaba ErrTokenExpired", err)
+	}
+	*now = now.Add(50*time.Minute - time.Second)
+	if _, err = ti.Verify(token); err != nil {
+		t.Fatalf("Verify un segundo antes de expirar = %v", err)
+	}
+	*now = now.Add(time.Second)
+	for _, tok := range [][]byte{token, long} {
+		if _, err = ti.Verify(tok); !errors.Is(err, ErrTokenExpired) {
+			t.Fatalf("Verify al expirar = %v, se esperaba ErrTokenExpired", err)
+		}
+	}
+}
+
+// TestTokenRevoked comprueba que un token revocado se rechaza y los demás no
+func TestTokenRevoked(t *testing.T) {
+	ti, now := newTestIssuer(t, time.Hour)
+	token, c, err := ti.Issue("ana", scopeData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := ti.Issue("ana", scopeData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti.Revoke(c.Sid, c.Expires())
+	if _, err = ti.Verify(token); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Verify tras Revoke = %v, se esperaba ErrTokenRevoked", err)
+	}
+	if _, err = ti.Verify(other); err != nil {
+		t.Fatalf("Verify de otra sesión = %v", err)
+	}
+
+	// la revocación se olvida cuando el token ha expirado (ya no hace falta)
+	*now = now.Add(time.Hour)
+	ti.Revoke("otra", now.Add(time.Hour))
+	if _, ok := ti.revoked[c.Sid]; ok {
+		t.Fatal("no se ha purgado la revocación de un token expirado")
+	}
+}
+
+// TestTokenRotation comprueba que los tokens firmados con la clave anterior siguen siendo
+// válidos tras la rotación y se rechazan cuando esa clave se retira
+func TestTokenRotation(t *testing.T) {
+	ttl := 2 * keyRotation // las sesiones duran más que una clave
+	ti, now := newTestIssuer(t, ttl)
+	old, oc, err := ti.Issue("ana", scopeData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	*now = now.Add(keyRotation + time.Second)
+	_, nc, err := ti.Issue("eva", scopeData) // rota la clave
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nc.Kid == oc.Kid {
+		t.Fatal("no se ha rotado la clave")
+	}
+	if _, err = ti.Verify(old); err != nil {
+		t.Fatalf("Verify con la clave anterior = %v", err)
+	}
+
+	// la clave anterior se olvida en la siguiente rotación pasado ttl desde su retirada
+	*now = now.Add(ttl + time.Second)
+	if _, _, err = ti.Issue("eva", scopeData); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ti.old[oc.Kid]; ok {
+		t.Fatal("la clave retirada no se ha olvidado")
+	}
+	if _, err = ti.Verify(old); !errors.Is(err, ErrTokenInvalid) {
+		t.Fatalf("Verify con la clave retirada = %v, se esperaba ErrTokenInvalid", err)
+	}
+}
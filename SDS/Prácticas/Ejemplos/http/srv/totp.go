@@ -0,0 +1,128 @@
+/*
+Segundo factor de autentificación con TOTP (RFC 6238)
+*/
+package srv
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// parámetros de TOTP (los que usan por defecto las aplicaciones de autentificación)
+const (
+	totpPeriod   = 30 // segundos por intervalo
+	totpDigits   = 6  // dígitos del código
+	totpSkew     = 1  // intervalos de margen (antes y después) por desajuste de reloj
+	totpIssuer   = "sdshttp"
+	recoveryNum  = 10 // número de códigos de recuperación
+	recoverySize = 10 // bytes aleatorios por código de recuperación (16 caracteres en base32)
+)
+
+// base32 sin relleno (formato de los secretos en las URI otpauth://)
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTP es el estado del segundo factor de un usuario
+type TOTP struct {
+	Secret   []byte   // secreto compartido con la aplicación de autentificación
+	Enabled  bool     // confirmado con un primer código (hasta entonces no se exige en el login)
+	Last     int64    // último intervalo aceptado (un código no se puede reutilizar)
+	Recovery [][]byte // hashes SHA-256 de los códigos de recuperación sin usar
+}
+
+// TOTPCode calcula el código TOTP de un secreto en el instante t
+// (exportado para que el cliente pueda generar códigos en las pruebas)
+func TOTPCode(secret []byte, t time.Time) string {
+	return hotp(secret, t.Unix()/totpPeriod)
+}
+
+// hotp calcula el código HOTP (RFC 4226) para el contador c
+func hotp(secret []byte, c int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(c))
+	m := hmac.New(sha1.New, secret)
+	m.Write(msg[:])
+	sum := m.Sum(nil)
+
+	off := sum[len(sum)-1] & 0x0f // truncado dinámico
+	v := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, v%1000000)
+}
+
+// newTOTP genera un secreto nuevo (160 bits, el tamaño de salida de SHA-1) sin confirmar
+func newTOTP() (*TOTP, error) {
+	t := &TOTP{Secret: make([]byte, 20)}
+	_, err := rand.Read(t.Secret)
+	return t, err
+}
+
+// URI devuelve la URI otpauth:// para dar de alta el secreto en una aplicación (o como código QR)
+func (t *TOTP) URI(name string) string {
+	v := url.Values{}
+	v.Set("secret", b32.EncodeToString(t.Secret))
+	v.Set("issuer", totpIssuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+name) + "?" + v.Encode()
+}
+
+// Verify comprueba un código en el instante now con un margen de ±totpSkew intervalos
+// y lo marca como usado (no se aceptan códigos de intervalos anteriores al último aceptado)
+func (t *TOTP) Verify(code string, now time.Time) bool {
+	step := now.Unix() / totpPeriod
+	for i := step - totpSkew; i <= step+totpSkew; i++ {
+		if i <= t.Last {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(t.Secret, i)), []byte(code)) == 1 {
+			t.Last = i
+			return true
+		}
+	}
+	return false
+}
+
+// newRecoveryCodes genera los códigos de recuperación (se muestran una sola vez al usuario)
+// y guarda sólo sus hashes
+func (t *TOTP) newRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryNum)
+	t.Recovery = make([][]byte, recoveryNum)
+	for i := range codes {
+		b := make([]byte, recoverySize)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := b32.EncodeToString(b)
+		codes[i] = s[:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:]
+		t.Recovery[i] = hashRecovery(codes[i])
+	}
+	return codes, nil
+}
+
+// UseRecovery comprueba un código de recuperación y, si es válido, lo elimina
+func (t *TOTP) UseRecovery(code string) bool {
+	h := hashRecovery(code)
+	for i, r := range t.Recovery {
+		if subtle.ConstantTimeCompare(r, h) == 1 {
+			t.Recovery = append(t.Recovery[:i], t.Recovery[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// hashRecovery normaliza (mayúsculas, sin guiones ni espacios) y resume un código de recuperación
+func hashRecovery(code string) []byte {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	h := sha256.Sum256([]byte(code))
+	return h[:]
+}
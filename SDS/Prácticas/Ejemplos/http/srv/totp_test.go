@@ -0,0 +1,153 @@
+package srv
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTOTPVectors comprueba los vectores de prueba de SHA-1 del RFC 6238 (apéndice B),
+// truncados a los totpDigits dígitos que se usan aquí
+func TestTOTPVectors(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	for _, v := range []struct {
+		unix int64
+		code string // 8 dígitos, como en el RFC
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	} {
+		want := v.code[len(v.code)-totpDigits:]
+		if got := TOTPCode(secret, time.Unix(v.unix, 0)); got != want {
+			t.Errorf("TOTPCode(%d) = %s, se esperaba %s", v.unix, got, want)
+		}
+	}
+}
+
+// TestTOTPWindow comprueba el margen de ±totpSkew intervalos
+func TestTOTPWindow(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	for _, c := range []struct {
+		steps int64
+		ok    bool
+	}{
+		{-2, false}, {-1, true}, {0, true}, {1, true}, {2, false},
+	} {
+		tp, err := newTOTP()
+		if err != nil {
+			t.Fatal(err)
+		}
+		code := TOTPCode(tp.Secret, now.Add(time.Duration(c.steps*totpPeriod)*time.Second))
+		if got := tp.Verify(code, now); got != c.ok {
+			t.Errorf("código de %+d intervalos: Verify = %v, se esperaba %v", c.steps, got, c.ok)
+		}
+	}
+}
+
+// TestTOTPReuse comprueba que un código aceptado (o uno anterior) no se acepta otra vez
+func TestTOTPReuse(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tp, err := newTOTP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := TOTPCode(tp.Secret, now)
+	if !tp.Verify(code, now) {
+		t.Fatal("código válido rechazado")
+	}
+	if tp.Verify(code, now) || tp.Verify(code, now.Add(10*time.Second)) {
+		t.Fatal("código reutilizado en el mismo intervalo")
+	}
+	prev := TOTPCode(tp.Secret, now.Add(-totpPeriod*time.Second))
+	if tp.Verify(prev, now) {
+		t.Fatal("código de un intervalo anterior al último aceptado")
+	}
+	next := TOTPCode(tp.Secret, now.Add(totpPeriod*time.Second))
+	if !tp.Verify(next, now.Add(totpPeriod*time.Second)) {
+		t.Fatal("código del intervalo siguiente rechazado")
+	}
+}
+
+// TestRecoveryCodes comprueba que cada código de recuperación sirve una sola vez
+// (con o sin guiones y en minúsculas)
+func TestRecoveryCodes(t *testing.T) {
+	tp, err := newTOTP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := tp.newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryNum || len(tp.Recovery) != recoveryNum {
+		t.Fatalf("%d códigos, %d hashes", len(codes), len(tp.Recovery))
+	}
+	if !tp.UseRecovery(strings.ToLower(strings.ReplaceAll(codes[3], "-", ""))) {
+		t.Fatal("código de recuperación normalizado rechazado")
+	}
+	if tp.UseRecovery(codes[3]) {
+		t.Fatal("código de recuperación usado dos veces")
+	}
+	if !tp.UseRecovery(codes[0]) || len(tp.Recovery) != recoveryNum-2 {
+		t.Fatal("los demás códigos deben seguir valiendo")
+	}
+	if tp.UseRecovery("AAAA-BBBB-CCCC-DDDD") {
+		t.Fatal("código inventado aceptado")
+	}
+}
+
+// TestLoginSecondFactor recorre el alta de TOTP y los logins con código y con código de
+// recuperación, con el reloj inyectado del servidor
+func TestLoginSecondFactor(t *testing.T) {
+	store, _ := openTestStore(t, "memory", "")
+	s := newTestServer(t, store)
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+
+	pass := []byte("clave")
+	token, err := s.register(registerIn{User: "ana", Pass: pass, PubKey: "pub", PriKey: "pri"}, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := s.tokens.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.totpSetup(c); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := store.Get("ana")
+	secret := u.TOTP.Secret
+	codes, err := s.totpConfirm(c, TOTPCode(secret, now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(code, recovery string) error {
+		now = now.Add(time.Minute) // sin llegar al límite de intentos ni a la espera tras un fallo
+		_, err := s.login(loginIn{User: "ana", Pass: pass, Code: code, Recovery: recovery}, "10.0.0.1")
+		return err
+	}
+	if err = login("", ""); !errors.Is(err, ErrTOTPRequired) {
+		t.Fatalf("login sin código = %v", err)
+	}
+	code := TOTPCode(secret, now.Add(time.Minute-totpPeriod*time.Second)) // intervalo anterior (reloj del móvil atrasado)
+	if err = login(code, ""); err != nil {
+		t.Fatalf("login con código = %v", err)
+	}
+	now = now.Add(-time.Minute) // mismo intervalo que el código ya usado
+	if err = login(code, ""); !errors.Is(err, ErrTOTPInvalid) {
+		t.Fatalf("login con código reutilizado = %v", err)
+	}
+	if err = login("", codes[0]); err != nil {
+		t.Fatalf("login con código de recuperación = %v", err)
+	}
+	if err = login("", codes[0]); !errors.Is(err, ErrTOTPInvalid) {
+		t.Fatalf("login con código de recuperación reutilizado = %v", err)
+	}
+}
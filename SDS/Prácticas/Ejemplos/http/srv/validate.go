@@ -0,0 +1,75 @@
+/*
+Validación de los campos de las peticiones
+
+el servidor guarda casi todo cifrado sin poder interpretarlo, así que al menos comprueba que
+cada campo está presente y acota lo que ocupa (las operaciones lo hacen antes de tocar el almacén)
+*/
+package srv
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// límites de los campos
+const (
+	maxUserName  = 64       // longitud máxima del nombre de usuario
+	maxPass      = 64       // tamaño máximo de keyLogin (el cliente envía 32 bytes)
+	maxUserKey   = 16 << 10 // tamaño máximo de una clave del usuario (pública o privada cifrada, en base64)
+	maxKeyAlg    = 32       // longitud máxima del identificador de algoritmo
+	maxWrapped   = 4 << 10  // tamaño máximo de una clave o un secreto cifrados (entradas, recuperación)
+	maxDevice    = 128      // longitud máxima de la etiqueta de un dispositivo
+	maxCode      = 64       // longitud máxima de un código TOTP o de recuperación
+	maxDataKeys  = 64       // claves en los datos del usuario
+	maxDataKey   = 64       // longitud máxima de una clave de los datos
+	maxDataValue = 4 << 10  // longitud máxima de un valor de los datos
+)
+
+// validUserName comprueba el nombre de un usuario nuevo (va en las rutas de la API)
+func validUserName(name string) bool {
+	return validText(name, maxUserName) && name != "" &&
+		strings.IndexFunc(name, func(r rune) bool { return unicode.IsControl(r) || r == '/' || r == '\\' }) < 0
+}
+
+// validText comprueba un campo de texto opcional: UTF-8 válido y de longitud acotada
+func validText(s string, max int) bool {
+	return len(s) <= max && utf8.ValidString(s)
+}
+
+// validDevice comprueba la etiqueta opcional de un dispositivo
+func validDevice(d string) bool {
+	return validText(d, maxDevice)
+}
+
+// validPasswordIn comprueba los datos cifrados de nuevo de un cambio de contraseña
+// (la contraseña actual, si la hay, la comprueba quien llama)
+func validPasswordIn(in passwordIn) bool {
+	if !validBytes(in.New, maxPass) || in.PriKey == "" || len(in.PriKey) > maxUserKey || len(in.Vault) > maxEntries {
+		return false
+	}
+	for _, r := range in.Vault {
+		if len(r.Key) > maxWrapped || len(r.Attachments) > maxAttachments {
+			return false
+		}
+		for _, key := range r.Attachments {
+			if len(key) > maxWrapped {
+				return false
+			}
+		}
+	}
+	return in.Recovery == nil || (len(in.Recovery.Escrow) <= maxWrapped && len(in.Recovery.Secret) <= maxWrapped)
+}
+
+// truncate acorta un texto recibido para anotarlo sin que ocupe demasiado
+func truncate(s string, max int) string {
+	if len(s) > max {
+		s = s[:max]
+	}
+	return strings.ToValidUTF8(s, "?")
+}
+
+// validBytes comprueba un campo binario obligatorio: no vacío y de tamaño acotado
+func validBytes(b []byte, max int) bool {
+	return len(b) > 0 && len(b) <= max
+}
@@ -0,0 +1,129 @@
+/*
+Bóveda del usuario: entradas con nombre cifradas en el cliente
+(el servidor sólo guarda el contenido cifrado, la clave de la entrada cifrada y metadatos)
+*/
+package srv
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// límites de la bóveda
+const (
+	maxEntryName = 128      // longitud máxima del nombre de una entrada
+	maxEntries   = 1000     // entradas por usuario
+	maxEntryBlob = 64 << 10 // tamaño máximo del contenido cifrado de una entrada
+)
+
+// errores de la bóveda
+var (
+	ErrEntryNotFound   = &APIError{http.StatusNotFound, "entry_not_found", "Entrada inexistente"}
+	ErrEntryExists     = &APIError{http.StatusConflict, "entry_exists", "La entrada ya existe"}
+	ErrVersionConflict = &APIError{http.StatusConflict, "version_conflict", "La entrada ha cambiado, vuelva a leerla"}
+	ErrVaultFull       = &APIError{http.StatusRequestEntityTooLarge, "vault_full", "Demasiadas entradas en la bóveda"}
+)
+
+// VaultEntry es una entrada de la bóveda
+// (exportado para que el cliente pueda decodificarla; el nombre no va cifrado)
+type VaultEntry struct {
+	Name    string    // nombre de la entrada (elegido por el usuario)
+	Blob    []byte    `json:",omitempty"` // contenido cifrado con la clave de la entrada (opaco)
+	Key     []byte    `json:",omitempty"` // clave de la entrada cifrada con la clave de bóveda del cliente (opaca)
+	Version uint64    // versión (empieza en 1 y aumenta con cada modificación)
+	Created time.Time // alta de la entrada
+	Updated time.Time // última modificación
+
+	Attachments map[string]Attachment `json:",omitempty"` // adjuntos por nombre (ver attach.go)
+}
+
+// vaultIn son los datos de un alta o modificación de una entrada
+type vaultIn struct {
+	Blob    []byte `json:"blob"`    // contenido cifrado (base64 en JSON)
+	Key     []byte `json:"key"`     // clave de la entrada cifrada (base64 en JSON)
+	Version uint64 `json:"version"` // 0 para crear, o la versión que se modifica
+}
+
+// validEntryName comprueba el nombre de una entrada (se usa en la ruta de la API)
+func validEntryName(name string) bool {
+	return name != "" && len(name) <= maxEntryName && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// vaultList devuelve las entradas de la bóveda ordenadas por nombre (sólo metadatos)
+func vaultList(u User) []VaultEntry {
+	l := make([]VaultEntry, 0, len(u.Vault))
+	for _, e := range u.Vault {
+		e.Blob, e.Key = nil, nil
+		e.Attachments = attachmentsMeta(e.Attachments)
+		l = append(l, e)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Name < l[j].Name })
+	return l
+}
+
+// vaultGet devuelve una entrada de la bóveda
+func vaultGet(u User, name string) (VaultEntry, error) {
+	e, ok := u.Vault[name]
+	if !ok {
+		return VaultEntry{}, ErrEntryNotFound
+	}
+	return e, nil
+}
+
+// vaultPut crea (in.Version == 0) o modifica una entrada; la modificación sólo se hace si
+// in.Version coincide con la versión guardada (bloqueo optimista entre dispositivos)
+func (s *server) vaultPut(c Claims, name string, in vaultIn) (e VaultEntry, created bool, err error) {
+	if !validEntryName(name) || !validBytes(in.Blob, maxEntryBlob) || !validBytes(in.Key, maxWrapped) {
+		return e, false, ErrBadRequest
+	}
+	_, err = s.modify(c.Sub, func(u *User) error {
+		old, ok := u.Vault[name]
+		switch {
+		case in.Version == 0 && ok:
+			return ErrEntryExists
+		case in.Version == 0 && len(u.Vault) >= maxEntries:
+			return ErrVaultFull
+		case in.Version != 0 && !ok:
+			return ErrEntryNotFound
+		case in.Version != 0 && in.Version != old.Version:
+			return ErrVersionConflict
+		}
+
+		now := s.now()
+		e = VaultEntry{Name: name, Blob: in.Blob, Key: in.Key, Version: old.Version + 1, Created: old.Created, Updated: now,
+			Attachments: old.Attachments} // los adjuntos se modifican aparte
+		if !ok {
+			e.Created = now
+		}
+		if u.Vault == nil {
+			u.Vault = make(map[string]VaultEntry)
+		}
+		u.Vault[name] = e
+		created = !ok
+		return nil
+	})
+	return
+}
+
+// vaultDelete borra una entrada con sus adjuntos (si version no es 0, sólo si coincide con la guardada)
+func (s *server) vaultDelete(c Claims, name string, version uint64) error {
+	var old VaultEntry
+	_, err := s.modify(c.Sub, func(u *User) error {
+		var ok bool
+		if old, ok = u.Vault[name]; !ok {
+			return ErrEntryNotFound
+		}
+		if version != 0 && version != old.Version {
+			return ErrVersionConflict
+		}
+		delete(u.Vault, name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.deleteBlobs(attachmentsOf(map[string]VaultEntry{name: old})...)
+	return nil
+}
@@ -0,0 +1,195 @@
+package tlsconf
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// otherCert crea un certificado autofirmado distinto del del servidor de pruebas
+func otherCert(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "otra CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		DNSNames:              []string{"example.com"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// writeCA guarda un certificado como bundle PEM
+func writeCA(t *testing.T, cert *x509.Certificate) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.crt")
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// get hace una petición al servidor de pruebas con las opciones indicadas
+func get(t *testing.T, ts *httptest.Server, o Options) error {
+	t.Helper()
+	cfg, err := o.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	defer c.CloseIdleConnections()
+	resp, err := c.Get(ts.URL)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// testHost es un nombre del certificado de httptest (que también vale para 127.0.0.1, pero a una IP
+// no se le envía SNI y el cliente no sabe su nombre)
+const testHost = "example.com"
+
+func newServer(t *testing.T) *httptest.Server {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.Config.ErrorLog = log.New(io.Discard, "", 0) // los rechazos del cliente son lo esperado
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// TestCA comprueba la verificación con un bundle de CAs
+func TestCA(t *testing.T) {
+	ts := newServer(t)
+	ca := writeCA(t, ts.Certificate())
+	for _, name := range []string{testHost, "127.0.0.1"} {
+		if err := get(t, ts, Options{CAFile: ca, ServerName: name}); err != nil {
+			t.Fatalf("con la CA del servidor y %s: %v", name, err)
+		}
+	}
+
+	other := writeCA(t, otherCert(t))
+	err := get(t, ts, Options{CAFile: other, ServerName: testHost})
+	if !errors.Is(err, ErrUntrusted) || !strings.Contains(err.Error(), other) {
+		t.Fatalf("con otra CA: %v, se esperaba ErrUntrusted indicando el bundle", err)
+	}
+
+	// el nombre también se comprueba (el certificado de httptest es para example.com e IPs locales)
+	err = get(t, ts, Options{CAFile: ca, ServerName: "otro.example.org"})
+	if !errors.Is(err, ErrUntrusted) || !strings.Contains(err.Error(), "otro.example.org") {
+		t.Fatalf("con otro nombre: %v, se esperaba ErrUntrusted", err)
+	}
+	// sin nombre (conexión a una IP) no se puede comprobar
+	if err = get(t, ts, Options{CAFile: ca}); !errors.Is(err, ErrUntrusted) {
+		t.Fatalf("sin nombre: %v, se esperaba ErrUntrusted", err)
+	}
+}
+
+// TestPin comprueba la fijación de la huella SPKI
+func TestPin(t *testing.T) {
+	ts := newServer(t)
+	fp := Fingerprint(ts.Certificate())
+	raw, err := parsePin(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pin := range []string{fp, strings.TrimPrefix(fp, "sha256/"), hex.EncodeToString(raw), colonHex(raw)} {
+		if err := get(t, ts, Options{Pins: []string{Fingerprint(otherCert(t)), pin}}); err != nil {
+			t.Fatalf("con la huella %s: %v", pin, err)
+		}
+	}
+
+	err = get(t, ts, Options{Pins: []string{Fingerprint(otherCert(t))}})
+	if !errors.Is(err, ErrPinMismatch) || !strings.Contains(err.Error(), fp) {
+		t.Fatalf("con otra huella: %v, se esperaba ErrPinMismatch con la huella presentada", err)
+	}
+	// la huella no sustituye a la CA si también se indica
+	err = get(t, ts, Options{CAFile: writeCA(t, otherCert(t)), Pins: []string{fp}, ServerName: testHost})
+	if !errors.Is(err, ErrUntrusted) {
+		t.Fatalf("huella correcta y otra CA: %v, se esperaba ErrUntrusted", err)
+	}
+
+	if _, err = (Options{Pins: []string{"no es una huella"}}).Config(); err == nil {
+		t.Fatal("se acepta una huella inválida")
+	}
+}
+
+// colonHex da la huella en hexadecimal separada por ':' (como openssl x509 -fingerprint)
+func colonHex(b []byte) string {
+	s := make([]string, len(b))
+	for i, c := range b {
+		s[i] = strings.ToUpper(hex.EncodeToString([]byte{c}))
+	}
+	return strings.Join(s, ":")
+}
+
+// TestKnownHosts comprueba la confianza en el primer uso y la detección de cambios
+func TestKnownHosts(t *testing.T) {
+	ts := newServer(t)
+	known := filepath.Join(t.TempDir(), "known_hosts")
+	fp := Fingerprint(ts.Certificate())
+	opts := Options{KnownHosts: known, ServerName: testHost}
+
+	for i := 0; i < 2; i++ { // la primera vez se guarda, la segunda se comprueba
+		if err := get(t, ts, opts); err != nil {
+			t.Fatalf("conexión %d: %v", i+1, err)
+		}
+		data, err := os.ReadFile(known)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := testHost + " " + fp + "\n"; string(data) != want {
+			t.Fatalf("conexión %d: hosts conocidos %q, se esperaba %q", i+1, data, want)
+		}
+	}
+
+	// sin nombre no se guarda nada
+	if err := get(t, ts, Options{KnownHosts: known}); !errors.Is(err, ErrUntrusted) {
+		t.Fatalf("sin nombre: %v, se esperaba ErrUntrusted", err)
+	}
+
+	// otro certificado para el mismo host
+	old := Fingerprint(otherCert(t))
+	err := os.WriteFile(known, []byte("# comentario\notro.example.org "+fp+"\n"+testHost+" "+old+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = get(t, ts, opts)
+	if !errors.Is(err, ErrHostChanged) || !strings.Contains(err.Error(), known+":3") || !strings.Contains(err.Error(), old) {
+		t.Fatalf("huella cambiada: %v, se esperaba ErrHostChanged indicando la línea", err)
+	}
+	if data, _ := os.ReadFile(known); strings.Count(string(data), "\n") != 3 {
+		t.Fatalf("se ha modificado el fichero de hosts conocidos: %q", data)
+	}
+}
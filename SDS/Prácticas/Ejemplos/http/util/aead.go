@@ -0,0 +1,129 @@
+/*
+Cifrado autentificado (AEAD) con un sobre autodescriptivo
+
+formato del sobre: magic "SDE" | versión (1 byte) | algoritmo (1 byte) | nonce | texto cifrado y etiqueta
+el algoritmo y el nonce van en la cabecera, y la cabecera entera se autentifica junto con los
+datos asociados (ad), de modo que cualquier cambio en el sobre se detecta al abrirlo (ErrAuth)
+
+los datos cifrados con Encrypt (AES-CTR sin autentificar, IV al principio) no tienen cabecera:
+OpenLegacy los sigue descifrando para poder migrarlos (volviendo a cifrarlos con Seal)
+*/
+package util
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Alg identifica el algoritmo AEAD de un sobre
+type Alg byte
+
+// algoritmos AEAD (el valor es el que va en la cabecera: no se pueden cambiar)
+const (
+	AESGCM            Alg = 1 // AES-256-GCM (nonce de 96 bits)
+	XChaCha20Poly1305 Alg = 2 // XChaCha20-Poly1305 (nonce de 192 bits, aleatorio sin riesgo de repetición)
+)
+
+// DefaultAlg es el algoritmo que usan los programas cuando no eligen otro
+const DefaultAlg = AESGCM
+
+// cabecera del sobre
+const (
+	envelopeMagic   = "SDE"
+	envelopeVersion = 1
+	headerLen       = len(envelopeMagic) + 2 // magic, versión y algoritmo (sin el nonce)
+)
+
+// errores del cifrado autentificado
+var (
+	ErrAuth        = errors.New("util: autentificación fallida (datos manipulados, clave o datos asociados incorrectos)")
+	ErrNotEnvelope = errors.New("util: los datos no son un sobre cifrado")
+	ErrAlg         = errors.New("util: algoritmo de cifrado desconocido")
+)
+
+// newAEAD crea el cifrador del algoritmo alg con key (32 bytes)
+func newAEAD(alg Alg, key []byte) (cipher.AEAD, error) {
+	switch alg {
+	case AESGCM:
+		blk, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(blk)
+	case XChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	}
+	return nil, ErrAlg
+}
+
+// Seal cifra y autentifica data con key (32 bytes) y los datos asociados ad (que no se
+// cifran ni se guardan: hay que dar los mismos a Open) y devuelve el sobre
+func Seal(alg Alg, key, data, ad []byte) ([]byte, error) {
+	aead, err := newAEAD(alg, key)
+	if err != nil {
+		return nil, err
+	}
+	n := headerLen + aead.NonceSize()
+	out := make([]byte, n, n+len(data)+aead.Overhead())
+	copy(out, envelopeMagic)
+	out[len(envelopeMagic)], out[len(envelopeMagic)+1] = envelopeVersion, byte(alg)
+	if _, err = rand.Read(out[headerLen:n]); err != nil { // nonce aleatorio
+		return nil, err
+	}
+	return aead.Seal(out, out[headerLen:n], data, envelopeAD(out[:n], ad)), nil
+}
+
+// Open comprueba y descifra un sobre de Seal con key y los mismos datos asociados
+// (ErrAuth si se ha modificado algo, también la versión o el algoritmo de la cabecera,
+// o la clave o los datos asociados no son los de Seal)
+func Open(key, env, ad []byte) ([]byte, error) {
+	if !IsEnvelope(env) {
+		return nil, ErrNotEnvelope
+	}
+	if env[len(envelopeMagic)] != envelopeVersion {
+		return nil, ErrAuth
+	}
+	aead, err := newAEAD(Alg(env[len(envelopeMagic)+1]), key)
+	if errors.Is(err, ErrAlg) {
+		return nil, ErrAuth
+	} else if err != nil {
+		return nil, err
+	}
+	n := headerLen + aead.NonceSize()
+	if len(env) < n+aead.Overhead() {
+		return nil, ErrAuth
+	}
+	data, err := aead.Open(nil, env[headerLen:n], env[n:], envelopeAD(env[:n], ad))
+	if err != nil {
+		return nil, ErrAuth
+	}
+	return data, nil
+}
+
+// OpenLegacy abre un sobre de Seal o, si los datos no tienen cabecera, los descifra como los
+// antiguos de Encrypt (sin autentificar: legacy indica que conviene volver a cifrarlos con Seal)
+func OpenLegacy(key, data, ad []byte) (plain []byte, legacy bool, err error) {
+	if IsEnvelope(data) {
+		plain, err = Open(key, data, ad)
+		return plain, false, err
+	}
+	plain, err = Decrypt(data, key)
+	return plain, true, err
+}
+
+// IsEnvelope indica si data empieza con la cabecera de un sobre de Seal, de cualquier versión
+// (un IV de los datos antiguos la imita con una probabilidad de 2^-24; no se mira la versión
+// para que cambiarla no convierta el sobre en datos antiguos sin autentificar)
+func IsEnvelope(data []byte) bool {
+	return len(data) >= headerLen && bytes.HasPrefix(data, []byte(envelopeMagic))
+}
+
+// envelopeAD autentifica la cabecera (con el nonce) además de los datos asociados
+func envelopeAD(header, ad []byte) []byte {
+	return append(header[:len(header):len(header)], ad...)
+}
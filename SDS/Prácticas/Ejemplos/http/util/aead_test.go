@@ -0,0 +1,109 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var (
+	testKey  = bytes.Repeat([]byte{7}, 32)
+	testData = []byte("datos secretos de prueba")
+	testAD   = []byte("usuario ana")
+)
+
+// TestSealOpen comprueba el cifrado y descifrado con los dos algoritmos
+func TestSealOpen(t *testing.T) {
+	for _, alg := range []Alg{AESGCM, XChaCha20Poly1305} {
+		for _, data := range [][]byte{testData, {}} {
+			env, err := Seal(alg, testKey, data, testAD)
+			if err != nil {
+				t.Fatalf("Seal(%d): %v", alg, err)
+			}
+			if !IsEnvelope(env) || Alg(env[len(envelopeMagic)+1]) != alg {
+				t.Fatalf("Seal(%d): cabecera %x", alg, env[:headerLen])
+			}
+			plain, err := Open(testKey, env, testAD)
+			if err != nil || !bytes.Equal(plain, data) {
+				t.Fatalf("Open(Seal(%d)) = %q, %v", alg, plain, err)
+			}
+			if env2, _ := Seal(alg, testKey, data, testAD); bytes.Equal(env, env2) {
+				t.Fatalf("Seal(%d) repite el nonce", alg)
+			}
+		}
+	}
+	if _, err := Seal(Alg(9), testKey, testData, nil); !errors.Is(err, ErrAlg) {
+		t.Fatalf("Seal con algoritmo desconocido = %v, se esperaba ErrAlg", err)
+	}
+}
+
+// TestOpenTampered comprueba que cualquier cambio en el sobre, la clave o los datos asociados da ErrAuth
+func TestOpenTampered(t *testing.T) {
+	for _, alg := range []Alg{AESGCM, XChaCha20Poly1305} {
+		env, err := Seal(alg, testKey, testData, testAD)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modified := func(i int, b byte) []byte {
+			e := bytes.Clone(env)
+			e[i] = b
+			return e
+		}
+		other := XChaCha20Poly1305
+		if alg == other {
+			other = AESGCM
+		}
+		cases := []struct {
+			name     string
+			key, env []byte
+			ad       []byte
+		}{
+			{"texto cifrado", testKey, modified(len(env)-20, env[len(env)-20]^1), testAD},
+			{"etiqueta", testKey, modified(len(env)-1, env[len(env)-1]^1), testAD},
+			{"nonce", testKey, modified(headerLen, env[headerLen]^1), testAD},
+			{"versión", testKey, modified(len(envelopeMagic), envelopeVersion+1), testAD},
+			{"otro algoritmo", testKey, modified(len(envelopeMagic)+1, byte(other)), testAD},
+			{"algoritmo desconocido", testKey, modified(len(envelopeMagic)+1, 9), testAD},
+			{"truncado", testKey, env[:len(env)-1], testAD},
+			{"sólo la cabecera", testKey, env[:headerLen], testAD},
+			{"datos asociados", testKey, env, []byte("usuario eva")},
+			{"sin datos asociados", testKey, env, nil},
+			{"clave", bytes.Repeat([]byte{8}, 32), env, testAD},
+		}
+		for _, c := range cases {
+			if _, err := Open(c.key, c.env, c.ad); !errors.Is(err, ErrAuth) {
+				t.Errorf("Open(%d) con %s = %v, se esperaba ErrAuth", alg, c.name, err)
+			}
+			// OpenLegacy tampoco debe tomarlo por datos antiguos (sin autentificar)
+			if _, legacy, err := OpenLegacy(c.key, c.env, c.ad); legacy || !errors.Is(err, ErrAuth) {
+				t.Errorf("OpenLegacy(%d) con %s = %v (antiguo %v), se esperaba ErrAuth", alg, c.name, err, legacy)
+			}
+		}
+	}
+	if _, err := Open(testKey, []byte("no es un sobre"), nil); !errors.Is(err, ErrNotEnvelope) {
+		t.Fatalf("Open sin cabecera = %v, se esperaba ErrNotEnvelope", err)
+	}
+}
+
+// TestOpenLegacy comprueba que los datos antiguos de Encrypt se siguen descifrando y se migran con Seal
+func TestOpenLegacy(t *testing.T) {
+	old, err := Encrypt(testData, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, legacy, err := OpenLegacy(testKey, old, testAD)
+	if err != nil || !legacy || !bytes.Equal(plain, testData) {
+		t.Fatalf("OpenLegacy(antiguo) = %q, %v, %v", plain, legacy, err)
+	}
+
+	env, err := Seal(DefaultAlg, testKey, plain, testAD) // migración
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, legacy, err = OpenLegacy(testKey, env, testAD); err != nil || legacy || !bytes.Equal(plain, testData) {
+		t.Fatalf("OpenLegacy(migrado) = %q, %v, %v", plain, legacy, err)
+	}
+	if _, _, err = OpenLegacy(testKey, old[:10], nil); !errors.Is(err, ErrShortData) {
+		t.Fatalf("OpenLegacy(antiguo truncado) = %v, se esperaba ErrShortData", err)
+	}
+}
@@ -0,0 +1,246 @@
+/*
+Compresión con códecs seleccionables y límites contra bombas de descompresión
+
+los datos comprimidos llevan una etiqueta con el códec, así que se descomprimen sin saber con
+cuál se comprimieron:
+
+	magic "SDZ" | códec (1 byte) | datos comprimidos
+
+los datos sin etiqueta son los zlib de versiones anteriores (un flujo zlib nunca empieza por "S")
+
+al descomprimir se limita el tamaño de la salida y la razón entre la salida y la entrada
+(ver Limits): unos pocos bytes comprimidos pueden ocupar gigabytes al descomprimirlos
+*/
+package util
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec identifica el algoritmo de compresión de unos datos
+type Codec byte
+
+// códecs (el valor es el que va en la etiqueta: no se pueden cambiar)
+const (
+	CodecNone   Codec = 0 // sin comprimir (datos que ya lo están, p.ej. imágenes)
+	CodecZlib   Codec = 1 // zlib (deflate), el de las versiones anteriores
+	CodecGzip   Codec = 2 // gzip (deflate)
+	CodecZstd   Codec = 3 // Zstandard (más rápido y comprime más)
+	CodecBrotli Codec = 4 // Brotli (comprime más, más lento)
+)
+
+// DefaultCodec es el códec que usan los programas cuando no eligen otro
+const DefaultCodec = CodecZlib
+
+// etiqueta de los datos comprimidos
+const codecMagic = "SDZ"
+
+// Limits son los límites de una descompresión (0 es sin límite)
+type Limits struct {
+	MaxSize  int64 // tamaño máximo de los datos descomprimidos
+	MaxRatio int64 // razón máxima entre descomprimidos y comprimidos (a partir de ratioFloor bytes)
+}
+
+// DefaultLimits son los límites de Decompress
+var DefaultLimits = Limits{MaxSize: 64 << 20, MaxRatio: 1024}
+
+// la razón sólo se comprueba a partir de este tamaño de salida (con menos, unos datos muy
+// repetitivos la superan sin ser ningún peligro)
+const ratioFloor = 1 << 20
+
+// errores de la compresión
+var (
+	ErrCodec    = errors.New("util: códec de compresión desconocido")
+	ErrTooLarge = errors.New("util: los datos descomprimidos superan el tamaño máximo")
+	ErrRatio    = errors.New("util: los datos descomprimidos superan la razón de compresión máxima")
+)
+
+// codec es la implementación de un códec registrado
+type codec struct {
+	name      string
+	newWriter func(w io.Writer) (io.WriteCloser, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// códecs registrados (ver RegisterCodec)
+var codecs = map[Codec]codec{
+	CodecNone: {"none",
+		func(w io.Writer) (io.WriteCloser, error) { return nopWriteCloser{w}, nil },
+		func(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(r), nil }},
+	CodecZlib: {"zlib",
+		func(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriter(w), nil },
+		func(r io.Reader) (io.ReadCloser, error) { return zlib.NewReader(r) }},
+	CodecGzip: {"gzip",
+		func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) }},
+	CodecZstd: {"zstd",
+		func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1)) },
+		func(r io.Reader) (io.ReadCloser, error) {
+			// la ventana también se limita: la reserva el descompresor según la cabecera del flujo
+			d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(64<<20))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		}},
+	CodecBrotli: {"brotli",
+		func(w io.Writer) (io.WriteCloser, error) { return brotli.NewWriter(w), nil },
+		func(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(brotli.NewReader(r)), nil }},
+}
+
+// RegisterCodec añade (o reemplaza) un códec; hay que llamarla antes de usar la compresión
+// (p.ej. en un init), ya que el registro no se protege para uso concurrente
+func RegisterCodec(c Codec, name string, newWriter func(io.Writer) (io.WriteCloser, error),
+	newReader func(io.Reader) (io.ReadCloser, error)) {
+	codecs[c] = codec{name, newWriter, newReader}
+}
+
+// Codecs devuelve los nombres de los códecs registrados (ordenados)
+func Codecs() []string {
+	l := make([]string, 0, len(codecs))
+	for _, c := range codecs {
+		l = append(l, c.name)
+	}
+	sort.Strings(l)
+	return l
+}
+
+// ParseCodec obtiene un códec por su nombre (ver Codecs)
+func ParseCodec(name string) (Codec, error) {
+	for c, impl := range codecs {
+		if impl.name == name {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrCodec, name)
+}
+
+func (c Codec) String() string {
+	if impl, ok := codecs[c]; ok {
+		return impl.name
+	}
+	return fmt.Sprintf("códec(%d)", byte(c))
+}
+
+// nopWriteCloser es un escritor sin nada que terminar al cerrar
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// NewCodecWriter devuelve un escritor que comprime con c lo que se le escribe y lo pasa a w,
+// precedido de la etiqueta (Close termina la compresión, no cierra w)
+func NewCodecWriter(w io.Writer, c Codec) (io.WriteCloser, error) {
+	impl, ok := codecs[c]
+	if !ok {
+		return nil, ErrCodec
+	}
+	if _, err := w.Write(append([]byte(codecMagic), byte(c))); err != nil {
+		return nil, err
+	}
+	return impl.newWriter(w)
+}
+
+// NewDecompressReader devuelve un lector que descomprime lo que lee de r con el códec de su
+// etiqueta (o zlib si no la tiene) y falla con ErrTooLarge o ErrRatio al superar los límites
+func NewDecompressReader(r io.Reader, l Limits) (io.ReadCloser, error) {
+	in := &countReader{r: r}
+	head := make([]byte, len(codecMagic)+1)
+	n, _ := io.ReadFull(in, head)
+
+	var impl codec
+	var body io.Reader
+	if n == len(head) && bytes.HasPrefix(head, []byte(codecMagic)) {
+		var ok bool
+		if impl, ok = codecs[Codec(head[len(codecMagic)])]; !ok {
+			return nil, ErrCodec
+		}
+		body = in
+	} else { // datos antiguos: zlib sin etiqueta
+		impl, body = codecs[CodecZlib], io.MultiReader(bytes.NewReader(head[:n]), in)
+	}
+	dr, err := impl.newReader(body)
+	if err != nil {
+		return nil, err
+	}
+	return &limitReader{ReadCloser: dr, in: in, l: l}, nil
+}
+
+// countReader cuenta los bytes leídos (los comprimidos, para la razón)
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// limitReader comprueba los límites mientras se descomprime
+type limitReader struct {
+	io.ReadCloser
+	in  *countReader // entrada comprimida
+	l   Limits
+	out int64 // bytes descomprimidos
+	err error
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.out += int64(n)
+	switch {
+	case r.l.MaxSize > 0 && r.out > r.l.MaxSize:
+		r.err = ErrTooLarge
+	case r.l.MaxRatio > 0 && r.out > ratioFloor && r.out > r.in.n*r.l.MaxRatio:
+		r.err = ErrRatio
+	}
+	if r.err != nil {
+		return 0, r.err // no devolvemos nada de lo que supera el límite
+	}
+	return n, err
+}
+
+// CompressWith comprime data con el códec c (con la etiqueta)
+func CompressWith(c Codec, data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w, err := NewCodecWriter(&b, c)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// DecompressLimit descomprime data (con etiqueta o zlib antiguo) dentro de los límites l
+// (falla también si los datos están corruptos o incompletos, salvo con brotli, cuyo lector da
+// por bueno un flujo cortado tras un bloque completo: la integridad se comprueba con Seal)
+func DecompressLimit(data []byte, l Limits) ([]byte, error) {
+	r, err := NewDecompressReader(bytes.NewReader(data), l)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var b bytes.Buffer
+	if _, err = io.Copy(&b, r); err != nil { // comprueba también la suma de control del final
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,104 @@
+package util
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"testing"
+)
+
+// legacyZlib comprime data con zlib sin etiqueta (el formato de las versiones anteriores)
+func legacyZlib(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// compressors son los formatos que debe leer Decompress (todos los códecs y el zlib antiguo)
+func compressors(t *testing.T) map[string]func([]byte) []byte {
+	m := map[string]func([]byte) []byte{
+		"zlib sin etiqueta": func(data []byte) []byte { return legacyZlib(t, data) },
+	}
+	for _, c := range []Codec{CodecNone, CodecZlib, CodecGzip, CodecZstd, CodecBrotli} {
+		m[c.String()] = func(data []byte) []byte {
+			z, err := CompressWith(c, data)
+			if err != nil {
+				t.Fatalf("CompressWith(%s): %v", c, err)
+			}
+			return z
+		}
+	}
+	return m
+}
+
+// TestDecompressRoundTrip comprueba que los datos dentro de los límites se recuperan
+func TestDecompressRoundTrip(t *testing.T) {
+	inputs := [][]byte{
+		{},
+		[]byte("hola"),
+		randomData(100 << 10), // no se comprimen
+		bytes.Repeat([]byte("datos repetidos "), 1<<16), // 1 MiB muy repetitivo (por debajo de ratioFloor)
+	}
+	for name, compress := range compressors(t) {
+		for _, data := range inputs {
+			got, err := Decompress(compress(data))
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("%s, %d bytes: %d bytes, %v", name, len(data), len(got), err)
+			}
+		}
+	}
+}
+
+// TestDecompressLimits comprueba que una bomba pequeña supera los límites de tamaño y de razón
+func TestDecompressLimits(t *testing.T) {
+	bomb := make([]byte, 8<<20) // 8 MiB de ceros
+	for name, compress := range compressors(t) {
+		z := compress(bomb)
+
+		if _, err := DecompressLimit(z, Limits{MaxSize: 1 << 20}); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%s: límite de tamaño: %v, se esperaba ErrTooLarge", name, err)
+		}
+		if name == "none" { // sin comprimir la razón es 1
+			continue
+		}
+		if _, err := DecompressLimit(z, Limits{MaxRatio: 100}); !errors.Is(err, ErrRatio) {
+			t.Errorf("%s: límite de razón (%d bytes comprimidos): %v, se esperaba ErrRatio", name, len(z), err)
+		}
+		if got, err := DecompressLimit(z, Limits{MaxSize: int64(len(bomb))}); err != nil || len(got) != len(bomb) {
+			t.Errorf("%s: justo en el límite de tamaño: %d bytes, %v", name, len(got), err)
+		}
+		if _, err := DecompressLimit(z, Limits{MaxSize: int64(len(bomb)) - 1}); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%s: un byte por encima del límite: %v, se esperaba ErrTooLarge", name, err)
+		}
+	}
+}
+
+// TestDecompressInvalid comprueba las etiquetas desconocidas y los datos corruptos o incompletos
+func TestDecompressInvalid(t *testing.T) {
+	if _, err := Decompress([]byte(codecMagic + "\xff datos")); !errors.Is(err, ErrCodec) {
+		t.Errorf("etiqueta desconocida: %v, se esperaba ErrCodec", err)
+	}
+	data := bytes.Repeat([]byte("datos de prueba "), 1000)
+	for name, compress := range compressors(t) {
+		if name == "none" || name == "brotli" { // brotli no detecta el final que falta (ver DecompressLimit)
+			continue
+		}
+		z := compress(data)
+		if _, err := Decompress(z[:len(z)-4]); err == nil {
+			t.Errorf("%s: datos incompletos sin error", name)
+		}
+	}
+	if _, err := ParseCodec("lz4"); !errors.Is(err, ErrCodec) {
+		t.Errorf("ParseCodec(lz4) = %v, se esperaba ErrCodec", err)
+	}
+	if c, err := ParseCodec("zstd"); err != nil || c != CodecZstd {
+		t.Errorf("ParseCodec(zstd) = %v, %v", c, err)
+	}
+}
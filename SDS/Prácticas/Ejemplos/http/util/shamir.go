@@ -0,0 +1,111 @@
+/*
+Reparto de secretos de Shamir sobre GF(256)
+
+cada byte del secreto es el término independiente de un polinomio aleatorio de grado k-1;
+cada parte es el valor de todos los polinomios en un punto x distinto de 0 (el primer byte
+de la parte es x) y con k partes cualesquiera se interpola el valor en x = 0
+*/
+package util
+
+import (
+	"crypto/rand"
+	"errors"
+)
+
+// errores del reparto de secretos
+var (
+	ErrShamirParams = errors.New("shamir: se necesita 1 <= k <= n <= 255")
+	ErrShamirParts  = errors.New("shamir: partes inválidas (vacías, de distinto tamaño o repetidas)")
+)
+
+// tablas de exponenciales y logaritmos en GF(256) con el polinomio de AES (x^8+x^4+x^3+x+1)
+// y generador 3
+var gfExp, gfLog = func() (exp [510]byte, log [256]byte) {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i], exp[i+255] = x, x
+		log[x] = byte(i)
+		x ^= gfMul2(x) // x * 3 = x * 2 + x
+	}
+	return
+}()
+
+// gfMul2 multiplica por x (2) en GF(256)
+func gfMul2(a byte) byte {
+	if a&0x80 != 0 {
+		return a<<1 ^ 0x1b
+	}
+	return a << 1
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte { // b != 0
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// Split divide secret en n partes de las que bastan k cualesquiera para recuperarlo
+// (con menos de k partes no se obtiene ninguna información del secreto)
+func Split(secret []byte, n, k int) ([][]byte, error) {
+	if k < 1 || k > n || n > 255 || len(secret) == 0 {
+		return nil, ErrShamirParams
+	}
+
+	coef := make([]byte, k-1) // coeficientes aleatorios del polinomio de cada byte
+	parts := make([][]byte, n)
+	for i := range parts {
+		parts[i] = make([]byte, len(secret)+1)
+		parts[i][0] = byte(i + 1) // x de la parte
+	}
+	for j, s := range secret {
+		if _, err := rand.Read(coef); err != nil {
+			return nil, err
+		}
+		for _, p := range parts { // evaluación con el método de Horner
+			x, y := p[0], byte(0)
+			for c := len(coef) - 1; c >= 0; c-- {
+				y = gfMul(y^coef[c], x)
+			}
+			p[j+1] = y ^ s
+		}
+	}
+	return parts, nil
+}
+
+// Combine recupera el secreto a partir de k partes (interpolación de Lagrange en x = 0)
+// (con menos partes de las necesarias devuelve un valor incorrecto, no un error)
+func Combine(parts [][]byte) ([]byte, error) {
+	if len(parts) == 0 || len(parts[0]) < 2 {
+		return nil, ErrShamirParts
+	}
+	seen := make(map[byte]bool)
+	for _, p := range parts {
+		if len(p) != len(parts[0]) || p[0] == 0 || seen[p[0]] {
+			return nil, ErrShamirParts
+		}
+		seen[p[0]] = true
+	}
+
+	secret := make([]byte, len(parts[0])-1)
+	for i, pi := range parts {
+		// base de Lagrange en 0: producto de xj / (xj - xi) para j != i (la resta es xor)
+		l := byte(1)
+		for j, pj := range parts {
+			if i != j {
+				l = gfMul(l, gfDiv(pj[0], pj[0]^pi[0]))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(l, pi[b+1])
+		}
+	}
+	return secret, nil
+}
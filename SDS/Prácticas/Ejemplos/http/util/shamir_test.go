@@ -0,0 +1,108 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// subsets llama a fn con cada subconjunto de k elementos de {0..n-1}
+func subsets(n, k int, fn func(idx []int)) {
+	var rec func(start int, idx []int)
+	rec = func(start int, idx []int) {
+		if len(idx) == k {
+			fn(idx)
+			return
+		}
+		for i := start; i < n; i++ {
+			rec(i+1, append(idx, i))
+		}
+	}
+	rec(0, nil)
+}
+
+func pick(parts [][]byte, idx []int) [][]byte {
+	out := make([][]byte, len(idx))
+	for i, j := range idx {
+		out[i] = parts[j]
+	}
+	return out
+}
+
+// TestGF256 comprueba que la división deshace la multiplicación en todo el cuerpo
+func TestGF256(t *testing.T) {
+	for a := 0; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			if got := gfDiv(gfMul(byte(a), byte(b)), byte(b)); got != byte(a) {
+				t.Fatalf("(%d * %d) / %d = %d", a, b, b, got)
+			}
+		}
+	}
+}
+
+// TestShamir comprueba que cualquier subconjunto de k partes recupera el secreto y que con
+// menos no se obtiene
+func TestShamir(t *testing.T) {
+	secret := []byte("clave de recuperación de 32 bytes")
+	for _, c := range []struct{ n, k int }{{1, 1}, {3, 1}, {3, 3}, {5, 3}, {6, 4}} {
+		parts, err := Split(secret, c.n, c.k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(parts) != c.n {
+			t.Fatalf("Split(%d, %d): %d partes", c.n, c.k, len(parts))
+		}
+		for m := c.k; m <= c.n; m++ { // k partes o más
+			subsets(c.n, m, func(idx []int) {
+				got, err := Combine(pick(parts, idx))
+				if err != nil || !bytes.Equal(got, secret) {
+					t.Fatalf("Combine(%d de %d, partes %v) = %q, %v", c.k, c.n, idx, got, err)
+				}
+			})
+		}
+		if c.k > 1 {
+			subsets(c.n, c.k-1, func(idx []int) {
+				if got, err := Combine(pick(parts, idx)); err == nil && bytes.Equal(got, secret) {
+					t.Fatalf("Combine(%d de %d) recupera el secreto con %d partes %v", c.k, c.n, c.k-1, idx)
+				}
+			})
+		}
+	}
+}
+
+// TestShamirInvalid comprueba que se rechazan los parámetros y las partes inválidas
+func TestShamirInvalid(t *testing.T) {
+	for _, c := range []struct{ n, k int }{{3, 0}, {2, 3}, {256, 2}} {
+		if _, err := Split([]byte("s"), c.n, c.k); !errors.Is(err, ErrShamirParams) {
+			t.Errorf("Split(%d, %d) = %v, se esperaba ErrShamirParams", c.n, c.k, err)
+		}
+	}
+	if _, err := Split(nil, 3, 2); !errors.Is(err, ErrShamirParams) {
+		t.Errorf("Split de un secreto vacío = %v, se esperaba ErrShamirParams", err)
+	}
+
+	parts, err := Split([]byte("secreto"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zero := bytes.Clone(parts[1])
+	zero[0] = 0
+	dup := bytes.Clone(parts[1])
+	dup[0] = parts[0][0]
+	for _, c := range []struct {
+		name  string
+		parts [][]byte
+	}{
+		{"ninguna", nil},
+		{"vacía", [][]byte{{}}},
+		{"sólo x", [][]byte{{1}}},
+		{"x repetida (misma parte)", [][]byte{parts[0], parts[0]}},
+		{"x repetida (distinta parte)", [][]byte{parts[0], dup}},
+		{"x cero", [][]byte{parts[0], zero}},
+		{"distinto tamaño", [][]byte{parts[0], parts[1][:len(parts[1])-1]}},
+	} {
+		if _, err := Combine(c.parts); !errors.Is(err, ErrShamirParts) {
+			t.Errorf("Combine con %s = %v, se esperaba ErrShamirParts", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+/*
+Cifrado y compresión en flujo (io.Reader / io.Writer) para datos grandes
+
+el cifrado divide los datos en trozos de ChunkSize bytes cifrados cada uno con AEAD (como Seal):
+
+	cabecera: magic "SDS" | versión (1 byte) | algoritmo (1 byte) | prefijo del nonce
+	trozo:    último (1 byte: 0 o 1) | longitud del texto cifrado (4 bytes) | texto cifrado y etiqueta
+
+el nonce de cada trozo es el prefijo, su número (4 bytes) y la marca de último, así que se detecta
+el reordenamiento y la eliminación de trozos, el truncado (falta el último) y lo añadido detrás;
+sólo hay en memoria un trozo a la vez
+*/
+package util
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ChunkSize es el tamaño máximo del texto en claro de cada trozo
+const ChunkSize = 64 << 10
+
+// cabecera del flujo
+const (
+	streamMagic   = "SDS"
+	streamVersion = 1
+	frameHeader   = 5 // último y longitud
+)
+
+// ErrStreamClosed indica una escritura tras Close
+var ErrStreamClosed = errors.New("util: flujo cerrado")
+
+// streamNonce calcula el nonce del trozo n en nonce (que ya lleva el prefijo)
+func streamNonce(nonce []byte, n uint32, last bool) []byte {
+	p := len(nonce) - 5
+	binary.BigEndian.PutUint32(nonce[p:], n)
+	nonce[p+4] = 0
+	if last {
+		nonce[p+4] = 1
+	}
+	return nonce
+}
+
+// sealWriter cifra por trozos lo que se escribe (ver NewSealWriter)
+type sealWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	nonce  []byte
+	ad     []byte // cabecera y datos asociados
+	buf    []byte // texto en claro pendiente (como mucho ChunkSize)
+	out    []byte // trozo cifrado
+	n      uint32 // número del siguiente trozo
+	err    error
+	closed bool
+}
+
+// NewSealWriter devuelve un escritor que cifra con key (32 bytes) y los datos asociados ad
+// todo lo que se le escribe y lo pasa a w; Close escribe el último trozo (no cierra w)
+func NewSealWriter(w io.Writer, alg Alg, key, ad []byte) (io.WriteCloser, error) {
+	aead, err := newAEAD(alg, key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(streamMagic)+2+aead.NonceSize()-5)
+	copy(header, streamMagic)
+	header[len(streamMagic)], header[len(streamMagic)+1] = streamVersion, byte(alg)
+	if _, err = rand.Read(header[len(streamMagic)+2:]); err != nil { // prefijo aleatorio
+		return nil, err
+	}
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, header[len(streamMagic)+2:])
+	return &sealWriter{w: w, aead: aead, nonce: nonce, ad: append(header, ad...),
+		buf: make([]byte, 0, ChunkSize), out: make([]byte, 0, frameHeader+ChunkSize+aead.Overhead())}, nil
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, ErrStreamClosed
+	}
+	written := 0
+	for len(p) > 0 && s.err == nil {
+		if len(s.buf) == ChunkSize { // sólo sabemos que no es el último cuando llega más
+			s.err = s.flush(false)
+			continue
+		}
+		n := copy(s.buf[len(s.buf):ChunkSize], p)
+		s.buf, p, written = s.buf[:len(s.buf)+n], p[n:], written+n
+	}
+	return written, s.err
+}
+
+// flush cifra y escribe el trozo pendiente
+func (s *sealWriter) flush(last bool) error {
+	if s.n == ^uint32(0) && !last {
+		return errors.New("util: flujo demasiado largo")
+	}
+	s.out = s.out[:frameHeader]
+	s.out[0] = 0
+	if last {
+		s.out[0] = 1
+	}
+	s.out = s.aead.Seal(s.out, streamNonce(s.nonce, s.n, last), s.buf, s.ad)
+	binary.BigEndian.PutUint32(s.out[1:frameHeader], uint32(len(s.out)-frameHeader))
+	s.n++
+	s.buf = s.buf[:0]
+	_, err := s.w.Write(s.out)
+	return err
+}
+
+// Close escribe el último trozo (aunque esté vacío: marca el final del flujo)
+func (s *sealWriter) Close() error {
+	if s.closed {
+		return s.err
+	}
+	s.closed = true
+	if s.err == nil {
+		s.err = s.flush(true)
+	}
+	return s.err
+}
+
+// openReader descifra y comprueba por trozos (ver NewOpenReader)
+type openReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	nonce []byte
+	ad    []byte
+	frame []byte // trozo cifrado leído
+	plain []byte // texto en claro pendiente de devolver
+	n     uint32
+	done  bool // se ha leído el último trozo
+	err   error
+}
+
+// NewOpenReader devuelve un lector que descifra el flujo de NewSealWriter que lee de r; un
+// flujo modificado, truncado o con algo detrás da ErrAuth (lo leído hasta entonces es auténtico)
+func NewOpenReader(r io.Reader, key, ad []byte) (io.Reader, error) {
+	head := make([]byte, len(streamMagic)+2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, ErrNotEnvelope
+	}
+	if !bytes.HasPrefix(head, []byte(streamMagic)) || head[len(streamMagic)] != streamVersion {
+		return nil, ErrNotEnvelope
+	}
+	aead, err := newAEAD(Alg(head[len(streamMagic)+1]), key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(r, nonce[:len(nonce)-5]); err != nil {
+		return nil, ErrAuth
+	}
+	ad = append(append(head, nonce[:len(nonce)-5]...), ad...)
+	return &openReader{r: r, aead: aead, nonce: nonce, ad: ad,
+		frame: make([]byte, 0, ChunkSize+aead.Overhead())}, nil
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	for len(o.plain) == 0 && o.err == nil {
+		if o.done {
+			o.err = io.EOF
+			if n, _ := o.r.Read(make([]byte, 1)); n > 0 { // nada detrás del último trozo
+				o.err = ErrAuth
+			}
+			break
+		}
+		o.err = o.next()
+	}
+	if len(o.plain) > 0 {
+		n := copy(p, o.plain)
+		o.plain = o.plain[n:]
+		return n, nil
+	}
+	return 0, o.err
+}
+
+// next lee, comprueba y descifra el siguiente trozo
+func (o *openReader) next() error {
+	var h [frameHeader]byte
+	if _, err := io.ReadFull(o.r, h[:]); err != nil {
+		return ErrAuth // truncado: falta el último trozo
+	}
+	size := binary.BigEndian.Uint32(h[1:])
+	if h[0] > 1 || size < uint32(o.aead.Overhead()) || size > uint32(cap(o.frame)) {
+		return ErrAuth
+	}
+	o.frame = o.frame[:size]
+	if _, err := io.ReadFull(o.r, o.frame); err != nil {
+		return ErrAuth
+	}
+	last := h[0] == 1
+	plain, err := o.aead.Open(o.frame[:0], streamNonce(o.nonce, o.n, last), o.frame, o.ad)
+	if err != nil {
+		return ErrAuth
+	}
+	o.plain, o.done = plain, last
+	o.n++
+	return nil
+}
+
+// pipeWriter es una cadena de escritores que se cierran en orden (del primero al último)
+type pipeWriter []io.WriteCloser
+
+func (p pipeWriter) Write(b []byte) (int, error) { return p[0].Write(b) }
+
+func (p pipeWriter) Close() error {
+	for _, w := range p {
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewCompressSealWriter comprime con c (ver NewCodecWriter) y luego cifra por trozos (ver
+// NewSealWriter) lo que se le escribe y lo pasa a w; Close termina la compresión y el cifrado
+// (no cierra w)
+func NewCompressSealWriter(w io.Writer, c Codec, alg Alg, key, ad []byte) (io.WriteCloser, error) {
+	sw, err := NewSealWriter(w, alg, key, ad)
+	if err != nil {
+		return nil, err
+	}
+	cw, err := NewCodecWriter(sw, c)
+	if err != nil {
+		return nil, err
+	}
+	return pipeWriter{cw, sw}, nil
+}
+
+// openDecompressReader descomprime lo descifrado y, al terminar, comprueba el final del flujo cifrado
+type openDecompressReader struct {
+	zr   io.ReadCloser
+	open io.Reader
+}
+
+func (r *openDecompressReader) Read(p []byte) (int, error) {
+	n, err := r.zr.Read(p)
+	if err == io.EOF { // el compresor ha terminado: el flujo cifrado también debe terminar aquí
+		if _, derr := io.Copy(io.Discard, r.open); derr != nil {
+			err = derr
+		}
+	}
+	return n, err
+}
+
+func (r *openDecompressReader) Close() error { return r.zr.Close() }
+
+// NewOpenDecompressReader descifra y descomprime dentro de los límites l lo que lee de r
+// (inverso de NewCompressSealWriter)
+func NewOpenDecompressReader(r io.Reader, key, ad []byte, l Limits) (io.ReadCloser, error) {
+	open, err := NewOpenReader(r, key, ad)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := NewDecompressReader(open, l)
+	if err != nil {
+		return nil, err
+	}
+	return &openDecompressReader{zr: zr, open: open}, nil
+}
@@ -0,0 +1,178 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// randomData devuelve n bytes pseudoaleatorios (reproducibles)
+func randomData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+// sealStream cifra data en flujo, escribiéndolo en trozos de tamaños variados
+func sealStream(t *testing.T, alg Alg, data, ad []byte) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	w, err := NewSealWriter(&out, alg, testKey, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p, step := data, 1; len(p) > 0; step = step*3 + 1 {
+		n := min(step, len(p))
+		if _, err = w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte{1}); !errors.Is(err, ErrStreamClosed) {
+		t.Fatalf("Write tras Close = %v, se esperaba ErrStreamClosed", err)
+	}
+	return out.Bytes()
+}
+
+// openStream descifra un flujo entero
+func openStream(stream, ad []byte) ([]byte, error) {
+	r, err := NewOpenReader(bytes.NewReader(stream), testKey, ad)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// frames separa un flujo cifrado en su cabecera y sus trozos
+func frames(t *testing.T, alg Alg, stream []byte) (head []byte, fs [][]byte) {
+	t.Helper()
+	aead, err := newAEAD(alg, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := len(streamMagic) + 2 + aead.NonceSize() - 5
+	head, stream = stream[:n], stream[n:]
+	for len(stream) > 0 {
+		size := frameHeader + int(binary.BigEndian.Uint32(stream[1:frameHeader]))
+		fs, stream = append(fs, stream[:size]), stream[size:]
+	}
+	return head, fs
+}
+
+// TestSealStream comprueba el cifrado en flujo con tamaños alrededor de los límites de los trozos
+func TestSealStream(t *testing.T) {
+	sizes := []struct{ size, frames int }{
+		{0, 1}, // flujo vacío: sólo el último trozo, vacío
+		{1, 1},
+		{ChunkSize - 1, 1},
+		{ChunkSize, 1},
+		{ChunkSize + 1, 2},
+		{2 * ChunkSize, 2},
+		{3*ChunkSize + 17, 4},
+	}
+	for _, alg := range []Alg{AESGCM, XChaCha20Poly1305} {
+		for _, c := range sizes {
+			data := randomData(c.size)
+			stream := sealStream(t, alg, data, testAD)
+			if _, fs := frames(t, alg, stream); len(fs) != c.frames {
+				t.Errorf("%d bytes con %d: %d trozos, se esperaban %d", c.size, alg, len(fs), c.frames)
+			}
+			plain, err := openStream(stream, testAD)
+			if err != nil || !bytes.Equal(plain, data) {
+				t.Fatalf("%d bytes con %d: descifrados %d bytes, %v", c.size, alg, len(plain), err)
+			}
+		}
+	}
+}
+
+// TestOpenStreamTampered comprueba que el truncado, el reordenamiento, la eliminación de trozos,
+// lo añadido detrás y los cambios dan ErrAuth
+func TestOpenStreamTampered(t *testing.T) {
+	for _, alg := range []Alg{AESGCM, XChaCha20Poly1305} {
+		stream := sealStream(t, alg, randomData(2*ChunkSize+100), testAD)
+		head, fs := frames(t, alg, stream)
+		if len(fs) != 3 {
+			t.Fatalf("%d trozos, se esperaban 3", len(fs))
+		}
+		join := func(parts ...[]byte) []byte {
+			return bytes.Join(append([][]byte{head}, parts...), nil)
+		}
+		flipped := bytes.Clone(stream)
+		flipped[len(head)+frameHeader+10] ^= 1
+		notLast := bytes.Clone(fs[2])
+		notLast[0] = 0
+		last := bytes.Clone(fs[0])
+		last[0] = 1
+
+		cases := []struct {
+			name   string
+			stream []byte
+			ad     []byte
+		}{
+			{"sin el último trozo", join(fs[0], fs[1]), testAD},
+			{"cortado a mitad de un trozo", stream[:len(stream)-10], testAD},
+			{"sólo la cabecera", head, testAD},
+			{"trozos reordenados", join(fs[1], fs[0], fs[2]), testAD},
+			{"sin un trozo intermedio", join(fs[0], fs[2]), testAD},
+			{"trozo repetido", join(fs[0], fs[0], fs[1], fs[2]), testAD},
+			{"un byte detrás", append(bytes.Clone(stream), 0), testAD},
+			{"un trozo detrás", join(fs[0], fs[1], fs[2], fs[2]), testAD},
+			{"último sin marca", join(fs[0], fs[1], notLast), testAD},
+			{"primero marcado como último", join(last), testAD},
+			{"texto cifrado", flipped, testAD},
+			{"datos asociados", stream, []byte("usuario eva")},
+		}
+		for _, c := range cases {
+			if _, err := openStream(c.stream, c.ad); !errors.Is(err, ErrAuth) {
+				t.Errorf("%d, %s: %v, se esperaba ErrAuth", alg, c.name, err)
+			}
+		}
+	}
+	if _, err := openStream([]byte("no es un flujo"), nil); !errors.Is(err, ErrNotEnvelope) {
+		t.Fatalf("flujo sin cabecera: %v, se esperaba ErrNotEnvelope", err)
+	}
+}
+
+// TestCompressSealStream comprueba la composición de compresión y cifrado en flujo
+func TestCompressSealStream(t *testing.T) {
+	data := bytes.Repeat([]byte("datos muy repetidos "), 10000) // varios trozos sin comprimir
+	for _, c := range []Codec{CodecNone, CodecZlib, CodecZstd} {
+		for _, in := range [][]byte{data, {}} {
+			var out bytes.Buffer
+			w, err := NewCompressSealWriter(&out, c, DefaultAlg, testKey, testAD)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = w.Write(in); err != nil {
+				t.Fatal(err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := NewOpenDecompressReader(bytes.NewReader(out.Bytes()), testKey, testAD, DefaultLimits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			plain, err := io.ReadAll(r)
+			if err != nil || !bytes.Equal(plain, in) {
+				t.Fatalf("códec %d: %d bytes, %v", c, len(plain), err)
+			}
+
+			// el final del flujo cifrado se comprueba también al terminar la descompresión
+			r, err = NewOpenDecompressReader(bytes.NewReader(append(out.Bytes(), 0)), testKey, testAD, DefaultLimits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = io.ReadAll(r); !errors.Is(err, ErrAuth) {
+				t.Fatalf("códec %d con un byte detrás: %v, se esperaba ErrAuth", c, err)
+			}
+		}
+	}
+}
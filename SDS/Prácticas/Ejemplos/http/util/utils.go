@@ -4,62 +4,58 @@ Funciones comunes y utilidades
 package util
 
 import (
-	"bytes"
-	"compress/zlib"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
-	"io"
+	"errors"
 )
 
-// chk comprueba y sale si hay errores (ahorra escritura en programas sencillos)
-func chk(e error) {
-	if e != nil {
-		panic(e)
-	}
-}
+// ErrShortData indica unos datos cifrados demasiado cortos (ni siquiera tienen el IV)
+var ErrShortData = errors.New("util: datos cifrados demasiado cortos")
 
 // función para cifrar (AES-CTR 256), adjunta el IV al principio
-func Encrypt(data, key []byte) (out []byte) {
-	out = make([]byte, len(data)+16)    // reservamos espacio para el IV al principio
-	rand.Read(out[:16])                 // generamos el IV
-	blk, err := aes.NewCipher(key)      // cifrador en bloque (AES), usa key
-	chk(err)                            // comprobamos el error
-	ctr := cipher.NewCTR(blk, out[:16]) // cifrador en flujo: modo CTR, usa IV
-	ctr.XORKeyStream(out[16:], data)    // ciframos los datos
-	return
+//
+// Deprecated: no detecta modificaciones de los datos cifrados; usar Seal
+// (se mantiene, con Decrypt, para los datos antiguos: ver OpenLegacy)
+func Encrypt(data, key []byte) ([]byte, error) {
+	out := make([]byte, len(data)+aes.BlockSize)              // reservamos espacio para el IV al principio
+	if _, err := rand.Read(out[:aes.BlockSize]); err != nil { // generamos el IV
+		return nil, err
+	}
+	blk, err := aes.NewCipher(key) // cifrador en bloque (AES), usa key
+	if err != nil {                // clave de tamaño incorrecto
+		return nil, err
+	}
+	ctr := cipher.NewCTR(blk, out[:aes.BlockSize]) // cifrador en flujo: modo CTR, usa IV
+	ctr.XORKeyStream(out[aes.BlockSize:], data)    // ciframos los datos
+	return out, nil
 }
 
 // función para descifrar (AES-CTR 256)
-func Decrypt(data, key []byte) (out []byte) {
-	out = make([]byte, len(data)-16)     // la salida no va a tener el IV
-	blk, err := aes.NewCipher(key)       // cifrador en bloque (AES), usa key
-	chk(err)                             // comprobamos el error
-	ctr := cipher.NewCTR(blk, data[:16]) // cifrador en flujo: modo CTR, usa IV
-	ctr.XORKeyStream(out, data[16:])     // desciframos (doble cifrado) los datos
-	return
+func Decrypt(data, key []byte) ([]byte, error) {
+	if len(data) < aes.BlockSize { // no está ni el IV
+		return nil, ErrShortData
+	}
+	blk, err := aes.NewCipher(key) // cifrador en bloque (AES), usa key
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data)-aes.BlockSize)    // la salida no va a tener el IV
+	ctr := cipher.NewCTR(blk, data[:aes.BlockSize]) // cifrador en flujo: modo CTR, usa IV
+	ctr.XORKeyStream(out, data[aes.BlockSize:])     // desciframos (doble cifrado) los datos
+	return out, nil
 }
 
-// función para comprimir
-func Compress(data []byte) []byte {
-	var b bytes.Buffer      // b contendrá los datos comprimidos (tamaño variable)
-	w := zlib.NewWriter(&b) // escritor que comprime sobre b
-	w.Write(data)           // escribimos los datos
-	w.Close()               // cerramos el escritor (buffering)
-	return b.Bytes()        // devolvemos los datos comprimidos
+// función para comprimir (con DefaultCodec, ver CompressWith)
+func Compress(data []byte) ([]byte, error) {
+	return CompressWith(DefaultCodec, data)
 }
 
-// función para descomprimir
-func Decompress(data []byte) []byte {
-	var b bytes.Buffer // b contendrá los datos descomprimidos
-
-	r, err := zlib.NewReader(bytes.NewReader(data)) // lector descomprime al leer
-
-	chk(err)         // comprobamos el error
-	io.Copy(&b, r)   // copiamos del descompresor (r) al buffer (b)
-	r.Close()        // cerramos el lector (buffering)
-	return b.Bytes() // devolvemos los datos descomprimidos
+// función para descomprimir con DefaultLimits (ver DecompressLimit); falla si los datos
+// están corruptos o incompletos o si descomprimidos superan los límites
+func Decompress(data []byte) ([]byte, error) {
+	return DecompressLimit(data, DefaultLimits)
 }
 
 // función para codificar de []bytes a string (Base64)
@@ -68,8 +64,6 @@ func Encode64(data []byte) string {
 }
 
 // función para decodificar de string a []bytes (Base64)
-func Decode64(s string) []byte {
-	b, err := base64.StdEncoding.DecodeString(s) // recupera el formato original
-	chk(err)                                     // comprobamos el error
-	return b                                     // devolvemos los datos originales
+func Decode64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(s) // recupera el formato original
 }
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+)
+
+// FuzzDecode64 comprueba que Decode64 no entra en pánico y que deshace Encode64
+func FuzzDecode64(f *testing.F) {
+	for _, seed := range []string{"", "AAAA", "Y2xhdmU=", "Y2xhdmU", "====", "a\nb=", "\xff"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		data, err := Decode64(s)
+		if err != nil {
+			return
+		}
+		if back, err := Decode64(Encode64(data)); err != nil || !bytes.Equal(back, data) {
+			t.Fatalf("Decode64(Encode64(%x)) = %x, %v", data, back, err)
+		}
+	})
+}
+
+// FuzzDecrypt comprueba que Decrypt (y OpenLegacy, que la usa) no entra en pánico con datos
+// o claves al azar y que deshace Encrypt
+func FuzzDecrypt(f *testing.F) {
+	key := bytes.Repeat([]byte{7}, 32)
+	f.Add([]byte{}, key)
+	f.Add(make([]byte, 15), key)
+	f.Add(make([]byte, 16), key)
+	f.Add([]byte("SDE\x01\x01corto"), key)
+	f.Add(make([]byte, 40), []byte("clave corta"))
+	f.Fuzz(func(t *testing.T, data, key []byte) {
+		plain, err := Decrypt(data, key)
+		if err == nil && len(plain) != len(data)-16 {
+			t.Fatalf("Decrypt: %d bytes descifrados de %d", len(plain), len(data))
+		}
+		OpenLegacy(key, data, nil)
+
+		if len(key) != 32 {
+			return
+		}
+		enc, err := Encrypt(data, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plain, err = Decrypt(enc, key); err != nil || !bytes.Equal(plain, data) {
+			t.Fatalf("Decrypt(Encrypt(%x)) = %x, %v", data, plain, err)
+		}
+	})
+}
+
+// FuzzDecompress comprueba que Decompress no entra en pánico con datos al azar, que respeta
+// los límites y que deshace Compress
+func FuzzDecompress(f *testing.F) {
+	for _, c := range []Codec{CodecNone, CodecZlib, CodecGzip, CodecZstd, CodecBrotli} {
+		data, err := CompressWith(c, []byte("datos de prueba, datos de prueba"))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+		f.Add(data[:len(data)/2]) // incompletos
+	}
+	f.Add([]byte("SDZ\xff"))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		out, err := Decompress(data)
+		if err == nil && int64(len(out)) > DefaultLimits.MaxSize {
+			t.Fatalf("Decompress: %d bytes, por encima del límite", len(out))
+		}
+
+		comp, err := Compress(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, err = Decompress(comp); err != nil || !bytes.Equal(out, data) {
+			t.Fatalf("Decompress(Compress(%x)) = %x, %v", data, out, err)
+		}
+	})
+}
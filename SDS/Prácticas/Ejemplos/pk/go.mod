@@ -1,3 +1,14 @@
 module sdspk
 
-go 1.18
+go 1.22
+
+require sdshttp v0.0.0
+
+require (
+	github.com/andybalholm/brotli v1.1.1 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	golang.org/x/crypto v0.0.0-20220312131142-6068a2e6cfdc // indirect
+	golang.org/x/sys v0.18.0 // indirect
+)
+
+replace sdshttp => ../http
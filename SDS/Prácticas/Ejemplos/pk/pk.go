@@ -17,6 +17,10 @@ go build
 arrancar tanto el servidor como el cliente (simultáneo)
 sdspk
 
+la verificación del certificado del servidor se configura con variables de entorno (ver sdshttp/tlsconf):
+SDS_TLS_CA=bundle de CAs (localhost.crt por defecto), SDS_TLS_PIN=huella SPKI SHA-256,
+SDS_TLS_KNOWN_HOSTS=fichero de hosts conocidos (confianza en el primer uso)
+
 pd. se pueden reutilizar los certificados incluidos para localhost:
 (ver https://letsencrypt.org/docs/certificates-for-localhost/)
 
@@ -27,21 +31,20 @@ package main
 
 import (
 	"bytes"
-	"compress/zlib"
 	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
-	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
+	"sdshttp/tlsconf"
+	"sdshttp/util"
 	"strings"
 )
 
@@ -93,25 +96,22 @@ func hash(data []byte) []byte {
 	return h.Sum(nil) // obtenemos el resumen
 }
 
-// función para comprimir
+// función para comprimir (con la etiqueta del códec, ver util.CompressWith)
 func compress(data []byte) []byte {
-	var b bytes.Buffer      // b contendrá los datos comprimidos (tamaño variable)
-	w := zlib.NewWriter(&b) // escritor que comprime sobre b
-	w.Write(data)           // escribimos los datos
-	w.Close()               // cerramos el escritor (buffering)
-	return b.Bytes()        // devolvemos los datos comprimidos
+	z, err := util.CompressWith(util.DefaultCodec, data)
+	chk(err)
+	return z
 }
 
-// función para descomprimir
-func decompress(data []byte) []byte {
-	var b bytes.Buffer // b contendrá los datos descomprimidos
-
-	r, err := zlib.NewReader(bytes.NewReader(data)) // lector descomprime al leer
+// límites de la descompresión de los mensajes (son textos cortos: unos pocos bytes
+// comprimidos no pueden ocupar gigabytes en memoria al descomprimirlos)
+var msgLimits = util.Limits{MaxSize: 1 << 20, MaxRatio: 100}
 
-	chk(err)         // comprobamos el error
-	io.Copy(&b, r)   // copiamos del descompresor (r) al buffer (b)
-	r.Close()        // cerramos el lector (buffering)
-	return b.Bytes() // devolvemos los datos descomprimidos
+// función para descomprimir (detecta el códec; falla si se superan los límites)
+func decompress(data []byte) []byte {
+	out, err := util.DecompressLimit(data, msgLimits)
+	chk(err)
+	return out
 }
 
 /***
@@ -123,10 +123,13 @@ type msg map[string][]byte // mapa con índice de string y slice de bytes de con
 
 // función que hace un post al servidor y devuelve la respuesta
 func (m msg) post() (msg, error) {
-	/* creamos un cliente especial que no comprueba la validez de los certificados
-	esto es necesario por que usamos certificados autofirmados (para pruebas) */
+	// verificamos el servidor según las variables de entorno (por defecto con localhost.crt)
+	cfg, err := tlsconf.FromEnv().Config()
+	if err != nil {
+		return nil, err
+	}
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: cfg,
 	}
 	client := &http.Client{Transport: tr}
 
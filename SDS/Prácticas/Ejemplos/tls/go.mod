@@ -1,3 +1,7 @@
 module sdstls
 
-go 1.17
+go 1.22
+
+require sdshttp v0.0.0
+
+replace sdshttp => ../http
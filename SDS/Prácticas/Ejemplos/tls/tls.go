@@ -14,10 +14,17 @@ sdstls srv
 arrancar el cliente:
 sdstls cli
 
+la verificación del certificado del servidor se configura con variables de entorno (ver sdshttp/tlsconf):
+SDS_TLS_CA=bundle de CAs (localhost.crt por defecto), SDS_TLS_PIN=huella SPKI SHA-256,
+SDS_TLS_KNOWN_HOSTS=fichero de hosts conocidos (confianza en el primer uso)
+
+el servidor publica sus métricas (formato de Prometheus, ver sdshttp/metrics) en /metrics si se
+indica un puerto de administración: SDSTLS_METRICS_ADDR=127.0.0.1:9101 (http, sin TLS)
+
 pd. Comando openssl para generar el par certificado/clave para localhost:
 (ver https://letsencrypt.org/docs/certificates-for-localhost/)
 
-openssl req -x509 -out localhost.crt -keyout localhost.key \
+openssl req -x509 -out localhost.crt -keyout localhost.key -days 3650 \
   -newkey rsa:2048 -nodes -sha256 \
   -subj '/CN=localhost' -extensions EXT -config <( \
    printf "[dn]\nCN=localhost\n[req]\ndistinguished_name = dn\n[EXT]\nsubjectAltName=DNS:localhost\nkeyUsage=digitalSignature\nextendedKeyUsage=serverAuth")
@@ -32,6 +39,15 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"sdshttp/metrics"
+	"sdshttp/tlsconf"
+)
+
+// métricas del servidor
+var (
+	connsTotal  = metrics.Default.Counter("sdstls_connections_total", "Conexiones aceptadas")
+	connsActive = metrics.Default.Gauge("sdstls_active_connections", "Conexiones abiertas")
+	linesTotal  = metrics.Default.Counter("sdstls_lines_total", "Líneas recibidas de los clientes")
 )
 
 // función para comprobar errores (ahorra escritura)
@@ -74,9 +90,16 @@ func server() {
 	chk(err)
 	defer ln.Close() // nos aseguramos que cerramos las conexiones aunque el programa falle
 
+	if addr := os.Getenv("SDSTLS_METRICS_ADDR"); addr != "" { // puerto de administración (opcional)
+		metrics.RegisterRuntime(metrics.Default)
+		go func() { chk(metrics.Default.Server(addr).ListenAndServe()) }()
+	}
+
 	for { // búcle infinito, se sale con ctrl+c o matando el proceso
 		conn, err := ln.Accept() // para cada nueva petición de conexión
 		chk(err)
+		connsTotal.Inc()
+		connsActive.Add(1)
 
 		go func() { // lanzamos un cierre (lambda, función anónima) en concurrencia
 
@@ -88,19 +111,25 @@ func server() {
 			scanner := bufio.NewScanner(conn) // el scanner nos permite trabajar con la entrada línea a línea (por defecto)
 
 			for scanner.Scan() { // escaneamos la conexión
+				linesTotal.Inc()
 				fmt.Println("cliente[", port, "]: ", scanner.Text()) // mostramos el mensaje del cliente
 				fmt.Fprintln(conn, "ack: ", scanner.Text())          // enviamos ack al cliente
 			}
 
 			conn.Close() // cerramos al finalizar el cliente (EOF se envía con ctrl+d o ctrl+z según el sistema)
+			connsActive.Add(-1)
 			fmt.Println("cierre[", port, "]")
 		}()
 	}
 }
 
 func client() {
-	// desactivamos la comprobación del certificado (útil en desarrollo con certificado autofirmado)
-	cfg := &tls.Config{InsecureSkipVerify: true}
+	// verificamos el servidor según las variables de entorno (por defecto con localhost.crt)
+	// como conectamos por IP, indicamos el nombre que debe figurar en el certificado
+	opts := tlsconf.FromEnv()
+	opts.ServerName = "localhost"
+	cfg, err := opts.Config()
+	chk(err)
 
 	conn, err := tls.Dial("tcp", "127.0.0.1:1337", cfg) // llamamos al servidor con esa configuración
 	chk(err)
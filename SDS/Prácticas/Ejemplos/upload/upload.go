@@ -11,20 +11,35 @@ compilación: go build
 uso: sdsupl fichorigen fichdestino
 (poniendo los paths correctos)
 
+la verificación del certificado del servidor se configura con variables de entorno (ver sdshttp/tlsconf):
+SDS_TLS_CA=bundle de CAs (localhost.crt por defecto), SDS_TLS_PIN=huella SPKI SHA-256,
+SDS_TLS_KNOWN_HOSTS=fichero de hosts conocidos (confianza en el primer uso)
+
+mientras funciona, el servidor publica sus métricas (formato de Prometheus, ver sdshttp/metrics)
+en /metrics si se indica un puerto de administración: SDSUPL_METRICS_ADDR=127.0.0.1:9102 (http, sin TLS)
+
 */
 
 package main
 
 import (
-	"crypto/tls"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"runtime"
+	"sdshttp/metrics"
+	"sdshttp/tlsconf"
 	"time"
 )
 
+// métricas del servidor
+var (
+	uploadsTotal  = metrics.Default.Counter("sdsupl_uploads_total", "Ficheros recibidos")
+	uploadBytes   = metrics.Default.Counter("sdsupl_upload_bytes_total", "Bytes recibidos")
+	uploadSeconds = metrics.Default.Histogram("sdsupl_upload_duration_seconds", "Duración de las subidas (segundos)", nil)
+)
+
 // función para comprobar errores (ahorra escritura)
 func chk(e error) {
 	if e != nil {
@@ -54,6 +69,11 @@ func server() {
 	fmt.Println("Iniciando el servidor...")
 	http.HandleFunc("/", handler) // asignamos un handler global
 
+	if addr := os.Getenv("SDSUPL_METRICS_ADDR"); addr != "" { // puerto de administración (opcional)
+		metrics.RegisterRuntime(metrics.Default)
+		go func() { chk(metrics.Default.Server(addr).ListenAndServe()) }()
+	}
+
 	// escuchamos el puerto 10443 con https y comprobamos el error
 	chk(http.ListenAndServeTLS("localhost:10443", "localhost.crt", "localhost.key", nil))
 }
@@ -61,9 +81,12 @@ func server() {
 func handler(w http.ResponseWriter, req *http.Request) {
 	file, err := os.Create(os.Args[2]) // crea el fichero de destino (servidor)
 	chk(err)
-	defer file.Close()      // cierra el fichero al salir de ámbito
-	t := time.Now()         // timestamp para medir el tiempo
-	io.Copy(file, req.Body) // copia desde el Body del request al fichero con streaming
+	defer file.Close()              // cierra el fichero al salir de ámbito
+	t := time.Now()                 // timestamp para medir el tiempo
+	n, _ := io.Copy(file, req.Body) // copia desde el Body del request al fichero con streaming
+	uploadsTotal.Inc()
+	uploadBytes.Add(float64(n))
+	uploadSeconds.ObserveSince(t)
 
 	m := runtime.MemStats{} // obtiene información acerca del uso de memoria
 	runtime.ReadMemStats(&m)
@@ -82,10 +105,11 @@ func client() {
 
 	fmt.Println("Iniciando el cliente...")
 
-	/* creamos un cliente especial que no comprueba la validez de los certificados
-	esto es necesario por que usamos certificados autofirmados (para pruebas) */
+	// verificamos el servidor según las variables de entorno (por defecto con localhost.crt)
+	cfg, err := tlsconf.FromEnv().Config()
+	chk(err)
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: cfg,
 	}
 	client := &http.Client{Transport: tr}
 